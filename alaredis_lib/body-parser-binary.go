@@ -5,6 +5,7 @@ import (
 	"errors"
 	"encoding/binary"
 	"bytes"
+	"strconv"
 )
 
 type BodyParserBinary struct {
@@ -14,6 +15,10 @@ type BodyParserBinary struct {
 func (p BodyParserBinary) ComposeBody(val interface{}) (*bytes.Buffer, error) {
 	buf := bytes.NewBuffer(make([]byte, 0))
 	switch val.(type) {
+	case int:
+		s := strconv.Itoa(val.(int))
+		binary.Write(buf, binary.LittleEndian, int32(len(s)))
+		buf.Write([]byte(s))
 	case string:
 		s := val.(string)
 		binary.Write(buf, binary.LittleEndian, int32(len(s)))
@@ -24,6 +29,17 @@ func (p BodyParserBinary) ComposeBody(val interface{}) (*bytes.Buffer, error) {
 			binary.Write(buf, binary.LittleEndian, int32(len(s[i])))
 			buf.Write([]byte(s[i]))
 		}
+	case []*string:
+		s := val.([]*string)
+		for _, v := range s {
+			if v == nil {
+				// negative size marks nil value
+				binary.Write(buf, binary.LittleEndian, int32(-1))
+			} else {
+				binary.Write(buf, binary.LittleEndian, int32(len(*v)))
+				buf.Write([]byte(*v))
+			}
+		}
 	case map[string]string:
 		s := val.(map[string]string)
 		for i, v := range s {
@@ -32,10 +48,108 @@ func (p BodyParserBinary) ComposeBody(val interface{}) (*bytes.Buffer, error) {
 			binary.Write(buf, binary.LittleEndian, int32(len(v)))
 			buf.Write([]byte(v))
 		}
+	case map[string]struct{}:
+		// sets are sent as lists
+		s := val.(map[string]struct{})
+		for member := range s {
+			binary.Write(buf, binary.LittleEndian, int32(len(member)))
+			buf.Write([]byte(member))
+		}
+	case map[string]*string:
+		s := val.(map[string]*string)
+		for i, v := range s {
+			binary.Write(buf, binary.LittleEndian, int32(len(i)))
+			buf.Write([]byte(i))
+			if v == nil {
+				// negative size marks nil value
+				binary.Write(buf, binary.LittleEndian, int32(-1))
+			} else {
+				binary.Write(buf, binary.LittleEndian, int32(len(*v)))
+				buf.Write([]byte(*v))
+			}
+		}
+	case *Transaction:
+		// watch count, watched keys with versions, then operations one after another
+		tx := val.(*Transaction)
+		writeChunk(buf, strconv.Itoa(len(tx.Watch)))
+		for k, version := range tx.Watch {
+			writeChunk(buf, k)
+			writeChunk(buf, strconv.FormatUint(version, 10))
+		}
+		for _, op := range tx.Ops {
+			writeChunk(buf, op.Op)
+			writeChunk(buf, op.Key)
+			writeChunk(buf, op.Idx)
+			writeChunk(buf, strconv.FormatInt(op.Ttl, 10))
+			writeChunk(buf, strconv.Itoa(len(op.Args)))
+			for _, arg := range op.Args {
+				writeChunk(buf, arg)
+			}
+			writeChunk(buf, string(op.Body))
+		}
+	case []TxResult:
+		// error message and body for each result
+		for _, r := range val.([]TxResult) {
+			writeChunk(buf, r.Err)
+			writeChunk(buf, string(r.Body))
+		}
+	case []StreamEntry:
+		// id and fields count for each entry, then its fields with values
+		for _, e := range val.([]StreamEntry) {
+			writeChunk(buf, e.Id)
+			writeChunk(buf, strconv.Itoa(len(e.Fields)))
+			for f, v := range e.Fields {
+				writeChunk(buf, f)
+				writeChunk(buf, v)
+			}
+		}
+	case []PendingEntry:
+		for _, e := range val.([]PendingEntry) {
+			writeChunk(buf, e.Id)
+			writeChunk(buf, e.Consumer)
+			writeChunk(buf, strconv.FormatInt(e.Idle, 10))
+			writeChunk(buf, strconv.Itoa(e.Deliveries))
+		}
 	}
 	return buf, nil
 }
 
+func writeChunk(buf *bytes.Buffer, s string) {
+	binary.Write(buf, binary.LittleEndian, int32(len(s)))
+	buf.Write([]byte(s))
+}
+
+// reads next chunk, io.EOF is returned only if there is no data left before chunk
+func readChunk(body io.Reader) (string, error) {
+	var size int32
+	if err := binary.Read(body, binary.LittleEndian, &size); err != nil {
+		return ``, err
+	}
+	if size < 0 {
+		return ``, errors.New("Negative chunk size")
+	}
+	valBuf := make([]byte, size)
+	if _, err := io.ReadFull(body, valBuf); err != nil {
+		return ``, err
+	}
+	return string(valBuf), nil
+}
+
+// reads several chunks, running out of data is an error. Count comes from request body,
+// so chunks are appended as they are read instead of allocating them in advance
+func readChunks(body io.Reader, cnt int) ([]string, error) {
+	var data []string
+	for i := 0; i < cnt; i++ {
+		chunk, err := readChunk(body)
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil { return nil, err }
+		data = append(data, chunk)
+	}
+	return data, nil
+}
+
 func (h BodyParserBinary) parseBody(body io.Reader, data *[]string, limit int) (error) {
 	var size int32
 	valsCnt := 0
@@ -70,6 +184,28 @@ func (p BodyParserBinary) GetListValue(body io.Reader) ([]string, error) {
 	return data, err
 }
 
+func (p BodyParserBinary) GetNullableListValue(body io.Reader) ([]*string, error) {
+	data := make([]*string, 0)
+	var size int32
+	for {
+		if err := binary.Read(body, binary.LittleEndian, &size); err != nil {
+			if err == io.EOF { break }
+			return nil, err
+		}
+		if size < 0 {
+			data = append(data, nil)
+			continue
+		}
+		valBuf := make([]byte, size)
+		if _, err := io.ReadFull(body, valBuf); err != nil {
+			return nil, err
+		}
+		s := string(valBuf)
+		data = append(data, &s)
+	}
+	return data, nil
+}
+
 func (p BodyParserBinary) GetDictValue(body io.Reader) (map[string]string, error) {
 	var data []string
 	err := p.parseBody(body, &data, 0)
@@ -89,6 +225,148 @@ func (p BodyParserBinary) GetDictValue(body io.Reader) (map[string]string, error
 	return m, nil
 }
 
+func (p BodyParserBinary) GetDictPatchValue(body io.Reader) (map[string]*string, error) {
+	m := make(map[string]*string)
+	var size int32
+	var k *string
+	for {
+		if err := binary.Read(body, binary.LittleEndian, &size); err != nil {
+			if err == io.EOF { break }
+			return nil, err
+		}
+		var v *string
+		if size >= 0 {
+			valBuf := make([]byte, size)
+			if _, err := io.ReadFull(body, valBuf); err != nil {
+				return nil, err
+			}
+			s := string(valBuf)
+			v = &s
+		}
+		if k == nil {
+			if v == nil {
+				return nil, errors.New("Dict key is nil")
+			}
+			k = v
+		} else {
+			m[*k] = v
+			k = nil
+		}
+	}
+	if k != nil {
+		return nil, errors.New("Key count is not equal to values count")
+	}
+	return m, nil
+}
+
+func (p BodyParserBinary) GetIntValue(body io.Reader) (int, error) {
+	var data []string
+	err := p.parseBody(body, &data, 1)
+	if err != nil { return 0, err }
+	if len(data) == 0 {
+		return 0, errors.New("Body is empty")
+	}
+	return strconv.Atoi(data[0])
+}
+
+func (p BodyParserBinary) GetTransactionValue(body io.Reader) (*Transaction, error) {
+	tx := new(Transaction)
+	data, err := readChunks(body, 1)
+	if err != nil { return nil, err }
+	watchCnt, err := strconv.Atoi(data[0])
+	if err != nil || watchCnt < 0 {
+		return nil, errors.New("Wrong watched keys count")
+	}
+	if watchCnt > 0 {
+		tx.Watch = make(map[string]uint64)
+	}
+	for i := 0; i < watchCnt; i++ {
+		data, err := readChunks(body, 2)
+		if err != nil { return nil, err }
+		version, err := strconv.ParseUint(data[1], 10, 64)
+		if err != nil {
+			return nil, errors.New("Wrong version of watched key: "+err.Error())
+		}
+		tx.Watch[data[0]] = version
+	}
+	for {
+		op, err := readChunk(body)
+		if err == io.EOF { break }
+		if err != nil { return nil, err }
+		data, err := readChunks(body, 4)
+		if err != nil { return nil, err }
+		ttl, err := strconv.ParseInt(data[2], 10, 64)
+		if err != nil {
+			return nil, errors.New("Non integer ttl: "+err.Error())
+		}
+		argsCnt, err := strconv.Atoi(data[3])
+		if err != nil || argsCnt < 0 {
+			return nil, errors.New("Wrong args count")
+		}
+		args, err := readChunks(body, argsCnt+1)
+		if err != nil { return nil, err }
+		tx.Ops = append(tx.Ops, TxOperation{op, data[0], data[1], args[:argsCnt], ttl, []byte(args[argsCnt])})
+	}
+	return tx, nil
+}
+
+func (p BodyParserBinary) GetTransactionResults(body io.Reader) ([]TxResult, error) {
+	res := make([]TxResult, 0)
+	for {
+		e, err := readChunk(body)
+		if err == io.EOF { break }
+		if err != nil { return nil, err }
+		data, err := readChunks(body, 1)
+		if err != nil { return nil, err }
+		res = append(res, TxResult{e, []byte(data[0])})
+	}
+	return res, nil
+}
+
+func (p BodyParserBinary) GetStreamEntries(body io.Reader) ([]StreamEntry, error) {
+	res := make([]StreamEntry, 0)
+	for {
+		id, err := readChunk(body)
+		if err == io.EOF { break }
+		if err != nil { return nil, err }
+		data, err := readChunks(body, 1)
+		if err != nil { return nil, err }
+		fieldsCnt, err := strconv.Atoi(data[0])
+		if err != nil || fieldsCnt < 0 {
+			return nil, errors.New("Wrong fields count")
+		}
+		e := StreamEntry{id, make(map[string]string)}
+		for i := 0; i < fieldsCnt; i++ {
+			data, err = readChunks(body, 2)
+			if err != nil { return nil, err }
+			e.Fields[data[0]] = data[1]
+		}
+		res = append(res, e)
+	}
+	return res, nil
+}
+
+func (p BodyParserBinary) GetPendingEntries(body io.Reader) ([]PendingEntry, error) {
+	res := make([]PendingEntry, 0)
+	for {
+		id, err := readChunk(body)
+		if err == io.EOF { break }
+		if err != nil { return nil, err }
+		data, err := readChunks(body, 3)
+		if err != nil { return nil, err }
+		idle, err := strconv.ParseInt(data[1], 10, 64)
+		if err != nil {
+			return nil, errors.New("Non integer idle time: "+err.Error())
+		}
+		deliveries, err := strconv.Atoi(data[2])
+		if err != nil {
+			return nil, errors.New("Non integer deliveries count: "+err.Error())
+		}
+		res = append(res, PendingEntry{id, data[0], idle, deliveries})
+	}
+	return res, nil
+}
+
 func (p BodyParserBinary) GetContentType() string {
 	return `application/octet-stream`
 }
\ No newline at end of file
@@ -16,6 +16,14 @@ func (p BodyParserJson) parseBody(r io.Reader, val interface{}) error {
 }
 
 func (p BodyParserJson) ComposeBody(val interface{}) (*bytes.Buffer, error) {
+	if set, ok := val.(map[string]struct{}); ok {
+		// sets are sent as lists
+		list := make([]string, 0, len(set))
+		for member := range set {
+			list = append(list, member)
+		}
+		val = list
+	}
 	b,err := json.Marshal(val);
 	return bytes.NewBuffer(b), err
 }
@@ -32,12 +40,54 @@ func (p BodyParserJson) GetListValue(r io.Reader) ([]string, error) {
 	return v, err
 }
 
+func (p BodyParserJson) GetNullableListValue(r io.Reader) ([]*string, error) {
+	var v []*string
+	err := p.parseBody(r, &v)
+	return v, err
+}
+
 func (p BodyParserJson) GetDictValue(r io.Reader) (map[string]string, error) {
 	var v map[string]string
 	err := p.parseBody(r, &v)
 	return v, err
 }
 
+func (p BodyParserJson) GetDictPatchValue(r io.Reader) (map[string]*string, error) {
+	var v map[string]*string
+	err := p.parseBody(r, &v)
+	return v, err
+}
+
+func (p BodyParserJson) GetIntValue(r io.Reader) (int, error) {
+	var v int
+	err := p.parseBody(r, &v)
+	return v, err
+}
+
+func (p BodyParserJson) GetTransactionValue(r io.Reader) (*Transaction, error) {
+	v := new(Transaction)
+	err := p.parseBody(r, v)
+	return v, err
+}
+
+func (p BodyParserJson) GetTransactionResults(r io.Reader) ([]TxResult, error) {
+	var v []TxResult
+	err := p.parseBody(r, &v)
+	return v, err
+}
+
+func (p BodyParserJson) GetStreamEntries(r io.Reader) ([]StreamEntry, error) {
+	var v []StreamEntry
+	err := p.parseBody(r, &v)
+	return v, err
+}
+
+func (p BodyParserJson) GetPendingEntries(r io.Reader) ([]PendingEntry, error) {
+	var v []PendingEntry
+	err := p.parseBody(r, &v)
+	return v, err
+}
+
 func (p BodyParserJson) GetContentType() string {
 	return `application/json`
 }
\ No newline at end of file
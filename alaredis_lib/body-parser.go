@@ -9,6 +9,15 @@ type BodyParser interface {
 	ComposeBody(val interface{}) (*bytes.Buffer, error)
 	GetStringValue(body io.Reader) (string, error)
 	GetListValue(body io.Reader) ([]string, error)
+	// list, where nil values mark missing ones
+	GetNullableListValue(body io.Reader) ([]*string, error)
 	GetDictValue(body io.Reader) (map[string]string, error)
+	// dict, where nil values mark removed keys
+	GetDictPatchValue(body io.Reader) (map[string]*string, error)
+	GetIntValue(body io.Reader) (int, error)
+	GetTransactionValue(body io.Reader) (*Transaction, error)
+	GetTransactionResults(body io.Reader) ([]TxResult, error)
+	GetStreamEntries(body io.Reader) ([]StreamEntry, error)
+	GetPendingEntries(body io.Reader) ([]PendingEntry, error)
 	GetContentType() string
 }
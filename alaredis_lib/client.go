@@ -9,10 +9,16 @@ import (
 	"io/ioutil"
 	"strconv"
 	"errors"
+	"net/url"
+	"time"
+	"encoding/json"
 )
 
 type CacheClient struct {
 	baseUrl string
+	// url of server without namespace, used by admin operations
+	serverUrl string
+	namespace string
 	client *http.Client
 	bodyParser BodyParser
 }
@@ -20,11 +26,21 @@ type CacheClient struct {
 func NewClient(host string, port int) *CacheClient {
 	c := new(CacheClient)
 	c.baseUrl = fmt.Sprintf("http://%s:%d", host, port)
+	c.serverUrl = c.baseUrl
 	c.client = &http.Client{}
 	c.bodyParser = BodyParserJson{}
 	return c
 }
 
+// returns client bound to namespace (logical database), which performs all requests on keys of namespace.
+// Client shares connections with original one
+func (c *CacheClient) WithNamespace(namespace string) *CacheClient {
+	nsClient := *c
+	nsClient.baseUrl = c.serverUrl+`/db/`+url.PathEscape(namespace)
+	nsClient.namespace = namespace
+	return &nsClient
+}
+
 func (c *CacheClient) GetBaseUrl() string {
 	return c.baseUrl
 }
@@ -40,9 +56,30 @@ func (c *CacheClient) Url(action string, key string, idx string, ttl int) string
 	return url
 }
 
+// returned by conditional writes when stored object has another version
+var ErrVersionMismatch = errors.New("Version mismatch")
+
 func (c *CacheClient) doRequest(method string, url string, body interface{}) (io.ReadCloser, error) {
+	resp, err := c.doRequestWithHeaders(method, url, body, nil)
+	if resp == nil {
+		return nil, err
+	}
+	return resp.Body, err
+}
+
+func (c *CacheClient) doRequestWithHeaders(method string, url string, body interface{}, headers map[string]string) (*http.Response, error) {
 	var reader bytes.Buffer
-	if body != nil {
+	contentType := ``
+	// raw bytes and json documents are sent as is, bypassing body parser
+	switch raw := body.(type) {
+	case []byte:
+		reader.Write(raw)
+		contentType = `application/octet-stream`
+	case json.RawMessage:
+		reader.Write(raw)
+		contentType = `application/json`
+	case nil:
+	default:
 		r, err := c.bodyParser.ComposeBody(body)
 		if err != nil { return nil, err }
 		reader = *r
@@ -51,6 +88,12 @@ func (c *CacheClient) doRequest(method string, url string, body interface{}) (io
 	if err != nil {
 		return nil, err
 	}
+	if contentType != `` {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for h, v := range headers {
+		req.Header.Set(h, v)
+	}
 	resp, err := c.client.Do(req)
 	if err != nil {
 		if resp != nil {
@@ -59,18 +102,44 @@ func (c *CacheClient) doRequest(method string, url string, body interface{}) (io
 		return nil, err
 	}
 	if resp.StatusCode/100 >= 4 {
-		if resp != nil {
-			defer resp.Body.Close()
-			defer io.Copy(ioutil.Discard, resp.Body)
-			// read error string from body
-			buf := new(bytes.Buffer)
-			buf.ReadFrom(resp.Body)
-			return nil, errors.New(buf.String())
-	 	} else {
-			return nil, errors.New("Undefined error")
+		defer resp.Body.Close()
+		defer io.Copy(ioutil.Discard, resp.Body)
+		if resp.StatusCode == http.StatusPreconditionFailed {
+			return nil, ErrVersionMismatch
 		}
+		// read error string from body
+		buf := new(bytes.Buffer)
+		buf.ReadFrom(resp.Body)
+		return nil, errors.New(buf.String())
 	}
-	return resp.Body, err
+	return resp, err
+}
+
+// performs GET request and returns body reader with object version taken from ETag header
+func (c *CacheClient) doVersionedGet(url string) (io.ReadCloser, uint64, error) {
+	resp, err := c.doRequestWithHeaders("GET", url, nil, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	version, err := strconv.ParseUint(strings.Trim(resp.Header.Get("ETag"), `"`), 10, 64)
+	if err != nil {
+		return resp.Body, 0, errors.New("Wrong ETag header: "+err.Error())
+	}
+	return resp.Body, version, nil
+}
+
+// performs POST request, which is applied only if stored object has passed version.
+// Zero version means that object must not exist
+func (c *CacheClient) doVersionedSet(url string, v interface{}, version uint64) error {
+	resp, err := c.doRequestWithHeaders("POST", url, v, map[string]string{
+		"If-Match": `"`+strconv.FormatUint(version, 10)+`"`,
+	})
+	if resp != nil {
+		defer resp.Body.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, resp.Body)
+	}
+	return err
 }
 
 // OP_SET
@@ -98,6 +167,129 @@ func (c *CacheClient) Get(k string) (string, error) {
 	return c.bodyParser.GetStringValue(bodyReader)
 }
 
+// OP_GET returning object version, which can be passed to SetIfVersion
+func (c *CacheClient) GetWithVersion(k string) (string, uint64, error) {
+	bodyReader, version, err := c.doVersionedGet(c.Url(`get`, k, ``, 0))
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return ``, 0, err
+	}
+	v, err := c.bodyParser.GetStringValue(bodyReader)
+	return v, version, err
+}
+
+// OP_SET with If-Match header, returns ErrVersionMismatch if object was changed
+func (c *CacheClient) SetIfVersion(k string, v string, version uint64, ttl int) error {
+	return c.doVersionedSet(c.Url(`set`, k, ``, ttl), v, version)
+}
+
+// OP_BSET, stores bytes as is, so they are not encoded by body parser
+func (c *CacheClient) SetBytes(k string, v []byte, ttl int) error {
+	bodyReader, err := c.doRequest("POST", c.Url(`bset`, k, ``, ttl), v)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	return err
+}
+
+// OP_BGET
+func (c *CacheClient) GetBytes(k string) ([]byte, error) {
+	bodyReader, err := c.doRequest("GET", c.Url(`bget`, k, ``, 0), nil)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(bodyReader)
+}
+
+// OP_JSET, sets value encoded to json by path in json document, empty path or $ sets the whole document.
+// Ttl is applied only when the whole document is set
+func (c *CacheClient) JSet(k string, path string, v interface{}, ttl int) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	bodyReader, err := c.doRequest("POST", c.Url(`jset`, k, url.PathEscape(path), ttl), json.RawMessage(data))
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	return err
+}
+
+// OP_JGET, decodes value selected by path in json document to v, empty path selects the whole document
+func (c *CacheClient) JGet(k string, path string, v interface{}) error {
+	bodyReader, err := c.doRequest("GET", c.Url(`jget`, k, url.PathEscape(path), 0), nil)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return err
+	}
+	return json.NewDecoder(bodyReader).Decode(v)
+}
+
+// OP_JDEL, removes value selected by path in json document, empty path removes the whole document.
+// Returns count of removed values
+func (c *CacheClient) JDel(k string, path string) (int, error) {
+	bodyReader, err := c.doRequest("POST", c.Url(`jdel`, k, url.PathEscape(path), 0), nil)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return c.bodyParser.GetIntValue(bodyReader)
+}
+
+// OP_JARRAPPEND, appends values to array selected by path in json document. Returns new length of array
+func (c *CacheClient) JArrAppend(k string, path string, values ...interface{}) (int, error) {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return 0, err
+	}
+	bodyReader, err := c.doRequest("POST", c.Url(`jarrappend`, k, url.PathEscape(path), 0), json.RawMessage(data))
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return c.bodyParser.GetIntValue(bodyReader)
+}
+
+// OP_JNUMINCRBY, increments number selected by path in json document. Returns new number
+func (c *CacheClient) JNumIncrBy(k string, path string, delta float64) (float64, error) {
+	idx := url.PathEscape(path)+`/`+strconv.FormatFloat(delta, 'f', -1, 64)
+	bodyReader, err := c.doRequest("POST", c.Url(`jnumincrby`, k, idx, 0), nil)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return 0, err
+	}
+	var v float64
+	err = json.NewDecoder(bodyReader).Decode(&v)
+	return v, err
+}
+
 // OP_DELETE
 func (c *CacheClient) Delete(k string) error {
 	bodyReader, err := c.doRequest("DELETE", c.Url(`delete`, k, ``, 0), nil)
@@ -122,7 +314,12 @@ func (c *CacheClient) LSet(k string, v []string, ttl int) error {
 
 // OP_LSETI
 func (c *CacheClient) LSetI(k string, v string, idx int) error {
-	bodyReader, err := c.doRequest("POST", c.Url(`lseti`, k, strconv.Itoa(idx), 0), v)
+	return c.LSetIWithTTL(k, v, idx, 0)
+}
+
+// OP_LSETI with ttl of list item, which follows item when list values are shifted
+func (c *CacheClient) LSetIWithTTL(k string, v string, idx int, ttl int) error {
+	bodyReader, err := c.doRequest("POST", c.Url(`lseti`, k, strconv.Itoa(idx), ttl), v)
 	if bodyReader != nil {
 		defer bodyReader.Close()
 		// just to read data to end
@@ -145,6 +342,26 @@ func (c *CacheClient) LGet(k string) ([]string, error) {
 	return c.bodyParser.GetListValue(bodyReader)
 }
 
+// OP_LGET returning object version, which can be passed to LSetIfVersion
+func (c *CacheClient) LGetWithVersion(k string) ([]string, uint64, error) {
+	bodyReader, version, err := c.doVersionedGet(c.Url(`lget`, k, ``, 0))
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	v, err := c.bodyParser.GetListValue(bodyReader)
+	return v, version, err
+}
+
+// OP_LSET with If-Match header, returns ErrVersionMismatch if object was changed
+func (c *CacheClient) LSetIfVersion(k string, v []string, version uint64, ttl int) error {
+	return c.doVersionedSet(c.Url(`lset`, k, ``, ttl), v, version)
+}
+
 // OP_LGETI
 func (c *CacheClient) LGetI(k string, idx int) (string, error) {
 	bodyReader, err := c.doRequest("GET", c.Url(`lgeti`, k, strconv.Itoa(idx), 0), nil)
@@ -158,6 +375,156 @@ func (c *CacheClient) LGetI(k string, idx int) (string, error) {
 	}
 	return c.bodyParser.GetStringValue(bodyReader)
 }
+// OP_LPUSH
+func (c *CacheClient) LPush(k string, v []string) (int, error) {
+	return c.push(`lpush`, k, v)
+}
+
+// OP_RPUSH
+func (c *CacheClient) RPush(k string, v []string) (int, error) {
+	return c.push(`rpush`, k, v)
+}
+
+func (c *CacheClient) push(action string, k string, v []string) (int, error) {
+	bodyReader, err := c.doRequest("POST", c.Url(action, k, ``, 0), v)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return c.bodyParser.GetIntValue(bodyReader)
+}
+
+// OP_LPOP
+func (c *CacheClient) LPop(k string) (string, error) {
+	return c.pop(`lpop`, k)
+}
+
+// OP_RPOP
+func (c *CacheClient) RPop(k string) (string, error) {
+	return c.pop(`rpop`, k)
+}
+
+// OP_BLPOP, waits for value until timeout is over, zero timeout means waiting forever.
+// If nothing is popped, error is returned as for LPop from missing list
+func (c *CacheClient) BLPop(k string, timeout time.Duration) (string, error) {
+	return c.blockingPop(`blpop`, k, timeout)
+}
+
+// OP_BRPOP, waits for value until timeout is over, zero timeout means waiting forever
+func (c *CacheClient) BRPop(k string, timeout time.Duration) (string, error) {
+	return c.blockingPop(`brpop`, k, timeout)
+}
+
+func (c *CacheClient) blockingPop(action string, k string, timeout time.Duration) (string, error) {
+	return c.popByUrl(c.Url(action, k, strconv.FormatFloat(timeout.Seconds(), 'f', -1, 64), 0))
+}
+
+func (c *CacheClient) pop(action string, k string) (string, error) {
+	return c.popByUrl(c.Url(action, k, ``, 0))
+}
+
+func (c *CacheClient) popByUrl(url string) (string, error) {
+	bodyReader, err := c.doRequest("POST", url, nil)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return ``, err
+	}
+	return c.bodyParser.GetStringValue(bodyReader)
+}
+
+// OP_LLEN
+func (c *CacheClient) LLen(k string) (int, error) {
+	bodyReader, err := c.doRequest("GET", c.Url(`llen`, k, ``, 0), nil)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return c.bodyParser.GetIntValue(bodyReader)
+}
+
+// OP_LRANGE
+func (c *CacheClient) LRange(k string, start int, stop int) ([]string, error) {
+	bodyReader, err := c.doRequest("GET", c.Url(`lrange`, k, strconv.Itoa(start)+`/`+strconv.Itoa(stop), 0), nil)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.bodyParser.GetListValue(bodyReader)
+}
+
+// OP_LTRIM
+func (c *CacheClient) LTrim(k string, start int, stop int) error {
+	bodyReader, err := c.doRequest("POST", c.Url(`ltrim`, k, strconv.Itoa(start)+`/`+strconv.Itoa(stop), 0), nil)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	return err
+}
+
+// OP_LINSERT, inserts value before or after the first occurrence of pivot
+func (c *CacheClient) LInsert(k string, before bool, pivot string, v string) (int, error) {
+	where := `after`
+	if before {
+		where = `before`
+	}
+	bodyReader, err := c.doRequest("POST", c.Url(`linsert`, k, where+`/`+url.PathEscape(pivot), 0), v)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return c.bodyParser.GetIntValue(bodyReader)
+}
+
+// OP_LREM
+func (c *CacheClient) LRem(k string, count int, v string) (int, error) {
+	bodyReader, err := c.doRequest("POST", c.Url(`lrem`, k, strconv.Itoa(count), 0), v)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return c.bodyParser.GetIntValue(bodyReader)
+}
+
+// OP_LPOS
+func (c *CacheClient) LPos(k string, v string) (int, error) {
+	bodyReader, err := c.doRequest("GET", c.Url(`lpos`, k, url.PathEscape(v), 0), nil)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return c.bodyParser.GetIntValue(bodyReader)
+}
+
 // OP_DSET
 func (c *CacheClient) DSet(k string, v map[string]string, ttl int) error {
 	bodyReader, err := c.doRequest("POST", c.Url(`dset`, k, ``, ttl), v)
@@ -171,7 +538,12 @@ func (c *CacheClient) DSet(k string, v map[string]string, ttl int) error {
 
 // OP_DSETI
 func (c *CacheClient) DSetI(k string, v string, idx string) error {
-	bodyReader, err := c.doRequest("POST", c.Url(`dseti`, k, idx, 0), v)
+	return c.DSetIWithTTL(k, v, idx, 0)
+}
+
+// OP_DSETI with ttl of dict field, only the field is removed when it expires
+func (c *CacheClient) DSetIWithTTL(k string, v string, idx string, ttl int) error {
+	bodyReader, err := c.doRequest("POST", c.Url(`dseti`, k, idx, ttl), v)
 	if bodyReader != nil {
 		defer bodyReader.Close()
 		// just to read data to end
@@ -194,6 +566,26 @@ func (c *CacheClient) DGet(k string) (map[string]string, error) {
 	return c.bodyParser.GetDictValue(bodyReader)
 }
 
+// OP_DGET returning object version, which can be passed to DSetIfVersion
+func (c *CacheClient) DGetWithVersion(k string) (map[string]string, uint64, error) {
+	bodyReader, version, err := c.doVersionedGet(c.Url(`dget`, k, ``, 0))
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	v, err := c.bodyParser.GetDictValue(bodyReader)
+	return v, version, err
+}
+
+// OP_DSET with If-Match header, returns ErrVersionMismatch if object was changed
+func (c *CacheClient) DSetIfVersion(k string, v map[string]string, version uint64, ttl int) error {
+	return c.doVersionedSet(c.Url(`dset`, k, ``, ttl), v, version)
+}
+
 // OP_DGETI
 func (c *CacheClient) DGetI(k string, idx string) (string, error) {
 	bodyReader, err := c.doRequest("GET", c.Url(`dgeti`, k, idx, 0), nil)
@@ -220,4 +612,721 @@ func (c *CacheClient) DKeys(k string) ([]string, error) {
 		return nil, err
 	}
 	return c.bodyParser.GetListValue(bodyReader)
-}
\ No newline at end of file
+}
+// OP_INCR
+func (c *CacheClient) Incr(k string) (int64, error) {
+	return c.doIntRequest(c.Url(`incr`, k, ``, 0))
+}
+
+// OP_DECR
+func (c *CacheClient) Decr(k string) (int64, error) {
+	return c.doIntRequest(c.Url(`decr`, k, ``, 0))
+}
+
+// OP_INCRBY
+func (c *CacheClient) IncrBy(k string, delta int64) (int64, error) {
+	return c.doIntRequest(c.Url(`incrby`, k, strconv.FormatInt(delta, 10), 0))
+}
+
+// OP_INCRBYFLOAT
+func (c *CacheClient) IncrByFloat(k string, delta float64) (float64, error) {
+	bodyReader, err := c.doRequest("POST", c.Url(`incrbyfloat`, k, strconv.FormatFloat(delta, 'f', -1, 64), 0), nil)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return 0, err
+	}
+	v, err := c.bodyParser.GetStringValue(bodyReader)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(v, 64)
+}
+
+// performs counter operation, which returns new integer value
+func (c *CacheClient) doIntRequest(url string) (int64, error) {
+	bodyReader, err := c.doRequest("POST", url, nil)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return 0, err
+	}
+	v, err := c.bodyParser.GetStringValue(bodyReader)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
+
+// OP_DDELI
+func (c *CacheClient) DDelI(k string, idx string) error {
+	bodyReader, err := c.doRequest("POST", c.Url(`ddeli`, k, idx, 0), nil)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	return err
+}
+
+// OP_DLEN
+func (c *CacheClient) DLen(k string) (int, error) {
+	bodyReader, err := c.doRequest("GET", c.Url(`dlen`, k, ``, 0), nil)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return c.bodyParser.GetIntValue(bodyReader)
+}
+
+// OP_DEXISTS
+func (c *CacheClient) DExists(k string, idx string) (bool, error) {
+	bodyReader, err := c.doRequest("GET", c.Url(`dexists`, k, idx, 0), nil)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return false, err
+	}
+	v, err := c.bodyParser.GetIntValue(bodyReader)
+	return v == 1, err
+}
+
+// OP_DVALS
+func (c *CacheClient) DVals(k string) ([]string, error) {
+	bodyReader, err := c.doRequest("GET", c.Url(`dvals`, k, ``, 0), nil)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.bodyParser.GetListValue(bodyReader)
+}
+
+// OP_DMGET, missing fields are not included to result
+func (c *CacheClient) DMGet(k string, idxs []string) (map[string]string, error) {
+	bodyReader, err := c.doRequest("POST", c.Url(`dmget`, k, ``, 0), idxs)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.bodyParser.GetDictValue(bodyReader)
+}
+
+// OP_DMSET, merges v into stored dict, nil values remove fields
+func (c *CacheClient) DMSet(k string, v map[string]*string) error {
+	bodyReader, err := c.doRequest("POST", c.Url(`dmset`, k, ``, 0), v)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	return err
+}
+
+// OP_SADD
+func (c *CacheClient) SAdd(k string, members []string) (int, error) {
+	return c.doListIntRequest(c.Url(`sadd`, k, ``, 0), members)
+}
+
+// OP_SREM
+func (c *CacheClient) SRem(k string, members []string) (int, error) {
+	return c.doListIntRequest(c.Url(`srem`, k, ``, 0), members)
+}
+
+// OP_SMEMBERS
+func (c *CacheClient) SMembers(k string) ([]string, error) {
+	bodyReader, err := c.doRequest("GET", c.Url(`smembers`, k, ``, 0), nil)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.bodyParser.GetListValue(bodyReader)
+}
+
+// OP_SISMEMBER
+func (c *CacheClient) SIsMember(k string, member string) (bool, error) {
+	bodyReader, err := c.doRequest("GET", c.Url(`sismember`, k, url.PathEscape(member), 0), nil)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return false, err
+	}
+	v, err := c.bodyParser.GetIntValue(bodyReader)
+	return v == 1, err
+}
+
+// OP_SCARD
+func (c *CacheClient) SCard(k string) (int, error) {
+	bodyReader, err := c.doRequest("GET", c.Url(`scard`, k, ``, 0), nil)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return c.bodyParser.GetIntValue(bodyReader)
+}
+
+// OP_SPOP
+func (c *CacheClient) SPop(k string) (string, error) {
+	return c.pop(`spop`, k)
+}
+
+// OP_SINTER
+func (c *CacheClient) SInter(k string, otherKeys ...string) ([]string, error) {
+	return c.setAlgebra(`sinter`, k, otherKeys)
+}
+
+// OP_SUNION
+func (c *CacheClient) SUnion(k string, otherKeys ...string) ([]string, error) {
+	return c.setAlgebra(`sunion`, k, otherKeys)
+}
+
+// OP_SDIFF
+func (c *CacheClient) SDiff(k string, otherKeys ...string) ([]string, error) {
+	return c.setAlgebra(`sdiff`, k, otherKeys)
+}
+
+func (c *CacheClient) setAlgebra(action string, k string, otherKeys []string) ([]string, error) {
+	if otherKeys == nil {
+		otherKeys = []string{}
+	}
+	bodyReader, err := c.doRequest("POST", c.Url(action, k, ``, 0), otherKeys)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.bodyParser.GetListValue(bodyReader)
+}
+
+// performs request with list body, which returns integer value
+func (c *CacheClient) doListIntRequest(url string, v []string) (int, error) {
+	bodyReader, err := c.doRequest("POST", url, v)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return c.bodyParser.GetIntValue(bodyReader)
+}
+
+// OP_ZADD
+func (c *CacheClient) ZAdd(k string, scores map[string]float64) (int, error) {
+	v := make(map[string]string, len(scores))
+	for member, score := range scores {
+		v[member] = strconv.FormatFloat(score, 'f', -1, 64)
+	}
+	bodyReader, err := c.doRequest("POST", c.Url(`zadd`, k, ``, 0), v)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return c.bodyParser.GetIntValue(bodyReader)
+}
+
+// OP_ZINCRBY
+func (c *CacheClient) ZIncrBy(k string, delta float64, member string) (float64, error) {
+	bodyReader, err := c.doRequest("POST", c.Url(`zincrby`, k, strconv.FormatFloat(delta, 'f', -1, 64), 0), member)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return 0, err
+	}
+	v, err := c.bodyParser.GetStringValue(bodyReader)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(v, 64)
+}
+
+// OP_ZREM
+func (c *CacheClient) ZRem(k string, members []string) (int, error) {
+	return c.doListIntRequest(c.Url(`zrem`, k, ``, 0), members)
+}
+
+// OP_ZSCORE
+func (c *CacheClient) ZScore(k string, member string) (float64, error) {
+	bodyReader, err := c.doRequest("GET", c.Url(`zscore`, k, url.PathEscape(member), 0), nil)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return 0, err
+	}
+	v, err := c.bodyParser.GetStringValue(bodyReader)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(v, 64)
+}
+
+// OP_ZRANK
+func (c *CacheClient) ZRank(k string, member string) (int, error) {
+	bodyReader, err := c.doRequest("GET", c.Url(`zrank`, k, url.PathEscape(member), 0), nil)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return c.bodyParser.GetIntValue(bodyReader)
+}
+
+// OP_ZCARD
+func (c *CacheClient) ZCard(k string) (int, error) {
+	bodyReader, err := c.doRequest("GET", c.Url(`zcard`, k, ``, 0), nil)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return c.bodyParser.GetIntValue(bodyReader)
+}
+
+// OP_ZRANGE
+func (c *CacheClient) ZRange(k string, start int, stop int) ([]string, error) {
+	return c.getList(c.Url(`zrange`, k, strconv.Itoa(start)+`/`+strconv.Itoa(stop), 0))
+}
+
+// OP_ZRANGEBYSCORE, min and max are float scores, optionally prefixed with '(' to be exclusive,
+// or -inf/+inf. Negative count means no limit
+func (c *CacheClient) ZRangeByScore(k string, min string, max string, offset int, count int) ([]string, error) {
+	idx := url.PathEscape(min)+`/`+url.PathEscape(max)
+	if offset > 0 || count >= 0 {
+		idx = idx+`/`+strconv.Itoa(offset)+`/`+strconv.Itoa(count)
+	}
+	return c.getList(c.Url(`zrangebyscore`, k, idx, 0))
+}
+
+func (c *CacheClient) getList(url string) ([]string, error) {
+	bodyReader, err := c.doRequest("GET", url, nil)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.bodyParser.GetListValue(bodyReader)
+}
+
+// OP_XADD, appends entry to stream. Empty id or '*' means auto generated one, positive maxLen trims the oldest
+// entries. Returns id of added entry
+func (c *CacheClient) XAdd(k string, id string, fields map[string]string, maxLen int) (string, error) {
+	if id == `` {
+		id = `*`
+	}
+	idx := url.PathEscape(id)
+	if maxLen > 0 {
+		idx = idx+`/`+strconv.Itoa(maxLen)
+	}
+	bodyReader, err := c.doRequest("POST", c.Url(`xadd`, k, idx, 0), fields)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return ``, err
+	}
+	return c.bodyParser.GetStringValue(bodyReader)
+}
+
+// OP_XRANGE, start and end are entry ids or '-' and '+' for the first and the last entries. Negative count means no limit
+func (c *CacheClient) XRange(k string, start string, end string, count int) ([]StreamEntry, error) {
+	return c.streamRange(`xrange`, k, start, end, count)
+}
+
+// OP_XREVRANGE, returns entries from end to start in reverse order
+func (c *CacheClient) XRevRange(k string, end string, start string, count int) ([]StreamEntry, error) {
+	return c.streamRange(`xrevrange`, k, end, start, count)
+}
+
+func (c *CacheClient) streamRange(action string, k string, from string, to string, count int) ([]StreamEntry, error) {
+	idx := url.PathEscape(from)+`/`+url.PathEscape(to)
+	if count >= 0 {
+		idx = idx+`/`+strconv.Itoa(count)
+	}
+	return c.getStreamEntries("GET", c.Url(action, k, idx, 0))
+}
+
+func (c *CacheClient) getStreamEntries(method string, url string) ([]StreamEntry, error) {
+	bodyReader, err := c.doRequest(method, url, nil)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.bodyParser.GetStreamEntries(bodyReader)
+}
+
+// OP_XLEN
+func (c *CacheClient) XLen(k string) (int, error) {
+	bodyReader, err := c.doRequest("GET", c.Url(`xlen`, k, ``, 0), nil)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return c.bodyParser.GetIntValue(bodyReader)
+}
+
+// OP_XTRIM, removes the oldest entries, so stream has not more than maxLen ones. Returns count of removed entries
+func (c *CacheClient) XTrim(k string, maxLen int) (int, error) {
+	bodyReader, err := c.doRequest("POST", c.Url(`xtrim`, k, strconv.Itoa(maxLen), 0), nil)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return c.bodyParser.GetIntValue(bodyReader)
+}
+
+// OP_XGROUPCREATE, group receives entries added after id, '$' or empty id means the last entry, '0' - all entries.
+// Missing stream is created
+func (c *CacheClient) XGroupCreate(k string, group string, id string) error {
+	idx := url.PathEscape(group)
+	if id != `` {
+		idx = idx+`/`+url.PathEscape(id)
+	}
+	bodyReader, err := c.doRequest("POST", c.Url(`xgroupcreate`, k, idx, 0), nil)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	return err
+}
+
+// OP_XREADGROUP, delivers entries, which were not delivered to group yet, to consumer. Negative count means no limit.
+// Entries are pending, until they are acknowledged by XAck
+func (c *CacheClient) XReadGroup(k string, group string, consumer string, count int) ([]StreamEntry, error) {
+	return c.XReadGroupPending(k, group, consumer, count, `>`)
+}
+
+// OP_XREADGROUP, delivers again entries pending for consumer with ids greater than passed one,
+// '>' means new entries as XReadGroup does
+func (c *CacheClient) XReadGroupPending(k string, group string, consumer string, count int, after string) ([]StreamEntry, error) {
+	idx := url.PathEscape(group)+`/`+url.PathEscape(consumer)+`/`+strconv.Itoa(count)+`/`+url.PathEscape(after)
+	return c.getStreamEntries("POST", c.Url(`xreadgroup`, k, idx, 0))
+}
+
+// OP_XACK, returns count of acknowledged entries, which were pending
+func (c *CacheClient) XAck(k string, group string, ids []string) (int, error) {
+	return c.doListIntRequest(c.Url(`xack`, k, url.PathEscape(group), 0), ids)
+}
+
+// OP_XPENDING, returns entries pending in group ordered by id, empty consumer means all consumers
+func (c *CacheClient) XPending(k string, group string, consumer string) ([]PendingEntry, error) {
+	idx := url.PathEscape(group)
+	if consumer != `` {
+		idx = idx+`/`+url.PathEscape(consumer)
+	}
+	bodyReader, err := c.doRequest("GET", c.Url(`xpending`, k, idx, 0), nil)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.bodyParser.GetPendingEntries(bodyReader)
+}
+
+// OP_TTL, returns remaining ttl in seconds, -1 if object does not expire and -2 if there is no object
+func (c *CacheClient) TTL(k string) (int, error) {
+	return c.FieldTTL(k, ``)
+}
+
+// OP_TTL for dict field or list item index, returns -2 if there is no such field
+func (c *CacheClient) FieldTTL(k string, idx string) (int, error) {
+	bodyReader, err := c.doRequest("GET", c.Url(`ttl`, k, idx, 0), nil)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return c.bodyParser.GetIntValue(bodyReader)
+}
+
+// OP_EXPIRE, non positive ttl removes object
+func (c *CacheClient) Expire(k string, ttl int) error {
+	bodyReader, err := c.doRequest("POST", c.Url(`expire`, k, ``, 0)+`?ttl=`+strconv.Itoa(ttl), nil)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	return err
+}
+
+// OP_EXPIREAT, object is removed if time has already come
+func (c *CacheClient) ExpireAt(k string, t time.Time) error {
+	bodyReader, err := c.doRequest("POST", c.Url(`expireat`, k, strconv.FormatInt(t.Unix(), 10), 0), nil)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	return err
+}
+
+// OP_PERSIST, removes expiration of object. Returns false if object did not expire
+func (c *CacheClient) Persist(k string) (bool, error) {
+	v, err := c.doListIntRequest(c.Url(`persist`, k, ``, 0), nil)
+	return v == 1, err
+}
+
+// OP_MGET, returns values in the same order as keys, nil marks missing or non string object
+func (c *CacheClient) MGet(keys []string) ([]*string, error) {
+	bodyReader, err := c.doRequest("POST", c.baseUrl+`/mget`, keys)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.bodyParser.GetNullableListValue(bodyReader)
+}
+
+// OP_MSET, ttl is applied to each key
+func (c *CacheClient) MSet(v map[string]string, ttl int) error {
+	url := c.baseUrl+`/mset`
+	if ttl > 0 {
+		url = url+`?ttl=`+fmt.Sprint(ttl)
+	}
+	bodyReader, err := c.doRequest("POST", url, v)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	return err
+}
+
+// OP_MDEL, returns count of deleted objects
+func (c *CacheClient) MDel(keys []string) (int, error) {
+	return c.doListIntRequest(c.baseUrl+`/mdel`, keys)
+}
+
+// OP_SCAN, returns next cursor and page of keys. Empty match and type filters are not applied.
+// Scanning starts and finishes with "0" cursor
+func (c *CacheClient) ScanPage(cursor string, match string, t string, count int) (string, []string, error) {
+	query := url.Values{}
+	query.Set(`cursor`, cursor)
+	if len(match) > 0 {
+		query.Set(`match`, match)
+	}
+	if len(t) > 0 {
+		query.Set(`type`, t)
+	}
+	if count > 0 {
+		query.Set(`count`, strconv.Itoa(count))
+	}
+	bodyReader, err := c.doRequest("GET", c.baseUrl+`/scan?`+query.Encode(), nil)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return ``, nil, err
+	}
+	res, err := c.bodyParser.GetListValue(bodyReader)
+	if err != nil {
+		return ``, nil, err
+	}
+	if len(res) == 0 {
+		return ``, nil, errors.New("Cursor is missing in scan response")
+	}
+	return res[0], res[1:], nil
+}
+
+// OP_DBSIZE, returns count of keys
+func (c *CacheClient) DbSize() (int, error) {
+	bodyReader, err := c.doRequest("GET", c.baseUrl+`/dbsize`, nil)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return c.bodyParser.GetIntValue(bodyReader)
+}
+
+// removes all keys of namespace, which client is bound to. Admin token is required by server.
+// If async is set, keys are removed by server in background
+func (c *CacheClient) FlushDb(adminToken string, async bool) error {
+	adminUrl := c.serverUrl+`/admin/flushdb`
+	if c.namespace != `` {
+		adminUrl = adminUrl+`/`+url.PathEscape(c.namespace)
+	}
+	return c.doAdminRequest(adminUrl, adminToken, async)
+}
+
+// removes all keys of all namespaces. Admin token is required by server.
+// If async is set, keys are removed by server in background
+func (c *CacheClient) FlushAll(adminToken string, async bool) error {
+	return c.doAdminRequest(c.serverUrl+`/admin/flushall`, adminToken, async)
+}
+
+func (c *CacheClient) doAdminRequest(adminUrl string, adminToken string, async bool) error {
+	if async {
+		adminUrl = adminUrl+`?async=1`
+	}
+	resp, err := c.doRequestWithHeaders("POST", adminUrl, nil, map[string]string{
+		"Authorization": `Bearer `+adminToken,
+	})
+	if resp != nil {
+		defer resp.Body.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, resp.Body)
+	}
+	return err
+}
+
+// KeyIterator iterates over keys of cache page by page:
+//	it := c.Scan(`user:*`, ``, 100)
+//	for it.Next() {
+//		print(it.Key())
+//	}
+//	if it.Err() != nil { ... }
+type KeyIterator struct {
+	c      *CacheClient
+	match  string
+	t      string
+	count  int
+	cursor string
+	keys   []string
+	key    string
+	err    error
+}
+
+// returns iterator over keys matching glob pattern and type, count is page size hint
+func (c *CacheClient) Scan(match string, t string, count int) *KeyIterator {
+	return &KeyIterator{c: c, match: match, t: t, count: count}
+}
+
+// moves iterator to the next key, returns false when there are no more keys or error occurred
+func (it *KeyIterator) Next() bool {
+	for len(it.keys) == 0 {
+		if it.err != nil || it.cursor == `0` {
+			return false
+		}
+		cursor := it.cursor
+		if cursor == `` {
+			cursor = `0`
+		}
+		it.cursor, it.keys, it.err = it.c.ScanPage(cursor, it.match, it.t, it.count)
+	}
+	it.key = it.keys[0]
+	it.keys = it.keys[1:]
+	return true
+}
+
+func (it *KeyIterator) Key() string {
+	return it.key
+}
+
+func (it *KeyIterator) Err() error {
+	return it.err
+}
+
+// returns body parser, which should be used to encode bodies of transaction operations and decode their results
+func (c *CacheClient) GetBodyParser() BodyParser {
+	return c.bodyParser
+}
+
+// composes operation for transaction, v is operation body and can be nil
+func (c *CacheClient) TxOp(action string, k string, idx string, v interface{}, ttl int, args ...string) (TxOperation, error) {
+	op := TxOperation{Op: action, Key: k, Idx: idx, Args: args, Ttl: int64(ttl)}
+	if v != nil {
+		buf, err := c.bodyParser.ComposeBody(v)
+		if err != nil { return op, err }
+		op.Body = buf.Bytes()
+	}
+	return op, nil
+}
+
+// performs operations atomically and returns their results in the same order.
+// Returns ErrVersionMismatch and performs nothing, if any of watched keys has other version
+func (c *CacheClient) Exec(watch map[string]uint64, ops []TxOperation) ([]TxResult, error) {
+	bodyReader, err := c.doRequest("POST", c.baseUrl+`/exec`, &Transaction{watch, ops})
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.bodyParser.GetTransactionResults(bodyReader)
+}
@@ -0,0 +1,111 @@
+package alaredis_lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strings"
+)
+
+// Message is received from pub/sub channel
+type Message struct {
+	Channel string `json:"channel"`
+	// pattern, which matched channel, set for pattern subscriptions only
+	Pattern string `json:"pattern,omitempty"`
+	Message string `json:"message"`
+}
+
+// Subscription receives messages of subscribed channels, until it is closed or connection is lost
+type Subscription struct {
+	// is closed, when subscription is finished
+	C    <-chan Message
+	body io.ReadCloser
+	done chan struct{}
+	err  error
+}
+
+// OP_PUBLISH, returns count of subscribers, which received message
+func (c *CacheClient) Publish(channel string, message string) (int, error) {
+	bodyReader, err := c.doRequest("POST", c.Url(`publish`, channel, ``, 0), message)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		// just to read data to end
+		defer io.Copy(ioutil.Discard, bodyReader)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return c.bodyParser.GetIntValue(bodyReader)
+}
+
+// subscribes to channels and channel glob patterns, messages are received from returned subscription channel
+func (c *CacheClient) Subscribe(channels []string, patterns []string) (*Subscription, error) {
+	query := url.Values{}
+	for _, ch := range channels {
+		query.Add(`channel`, ch)
+	}
+	for _, p := range patterns {
+		query.Add(`pattern`, p)
+	}
+	resp, err := c.doRequestWithHeaders("GET", c.baseUrl+`/subscribe?`+query.Encode(), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	msgChan := make(chan Message)
+	sub := &Subscription{C: msgChan, body: resp.Body, done: make(chan struct{})}
+	go sub.read(msgChan)
+	return sub, nil
+}
+
+// reads server-sent events from response body, only message events are passed to channel.
+// Lines are read without length limit, as message is sent in one line
+func (sub *Subscription) read(msgChan chan Message) {
+	defer close(msgChan)
+	r := bufio.NewReader(sub.body)
+	event := ``
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			select {
+			case <-sub.done:
+				// reading error is caused by closing subscription
+			default:
+				if err != io.EOF {
+					sub.err = err
+				}
+			}
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case strings.HasPrefix(line, `event: `):
+			event = line[len(`event: `):]
+		case strings.HasPrefix(line, `data: `) && event == `message`:
+			var msg Message
+			if err := json.Unmarshal([]byte(line[len(`data: `):]), &msg); err != nil {
+				sub.err = err
+				return
+			}
+			select {
+			case msgChan <- msg:
+			case <-sub.done:
+				return
+			}
+		case line == ``:
+			event = ``
+		}
+	}
+}
+
+// finishes subscription, its channel is closed after that
+func (sub *Subscription) Close() error {
+	close(sub.done)
+	return sub.body.Close()
+}
+
+// returns error, which finished subscription, if any. Must be called after subscription channel is closed
+func (sub *Subscription) Err() error {
+	return sub.err
+}
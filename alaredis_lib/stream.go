@@ -0,0 +1,16 @@
+package alaredis_lib
+
+// StreamEntry is entry of stream, its id is <unix time in milliseconds>-<sequence number>
+type StreamEntry struct {
+	Id     string            `json:"id"`
+	Fields map[string]string `json:"fields"`
+}
+
+// PendingEntry is stream entry delivered to consumer of group, but not acknowledged yet
+type PendingEntry struct {
+	Id         string `json:"id"`
+	Consumer   string `json:"consumer"`
+	// milliseconds passed since the last delivery
+	Idle       int64  `json:"idle"`
+	Deliveries int    `json:"deliveries"`
+}
@@ -0,0 +1,71 @@
+package alaredis_lib
+
+import (
+	"encoding/json"
+)
+
+// Transaction is ordered batch of operations, which are performed atomically.
+// If versions of watched keys differ from passed ones, no operation is performed
+type Transaction struct {
+	// key versions, zero version means that key must not exist
+	Watch map[string]uint64 `json:"watch,omitempty"`
+	Ops   []TxOperation     `json:"ops"`
+}
+
+// TxOperation describes one operation of transaction in terms of http request: /<op>/<key>/<idx>/<args>...
+type TxOperation struct {
+	Op   string
+	Key  string
+	Idx  string
+	Args []string
+	Ttl  int64
+	// operation body encoded with the same body parser as transaction itself
+	Body []byte
+}
+
+// TxResult is result of one operation of transaction, either error message or encoded response body
+type TxResult struct {
+	Err  string
+	Body []byte
+}
+
+// json form of operation keeps body as is, so it is just nested json object
+type txOperationJson struct {
+	Op   string          `json:"op"`
+	Key  string          `json:"key"`
+	Idx  string          `json:"idx,omitempty"`
+	Args []string        `json:"args,omitempty"`
+	Ttl  int64           `json:"ttl,omitempty"`
+	Val  json.RawMessage `json:"val,omitempty"`
+}
+
+type txResultJson struct {
+	Err string          `json:"error,omitempty"`
+	Val json.RawMessage `json:"val,omitempty"`
+}
+
+func (op TxOperation) MarshalJSON() ([]byte, error) {
+	return json.Marshal(txOperationJson{op.Op, op.Key, op.Idx, op.Args, op.Ttl, op.Body})
+}
+
+func (op *TxOperation) UnmarshalJSON(b []byte) error {
+	var v txOperationJson
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*op = TxOperation{v.Op, v.Key, v.Idx, v.Args, v.Ttl, v.Val}
+	return nil
+}
+
+func (r TxResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(txResultJson{r.Err, r.Body})
+}
+
+func (r *TxResult) UnmarshalJSON(b []byte) error {
+	var v txResultJson
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*r = TxResult{v.Err, v.Val}
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// serves admin operations, which require admin token in "Authorization: Bearer <token>" header:
+// POST /admin/flushdb[/<namespace>] removes all keys of namespace, default one without it,
+// POST /admin/flushall removes all keys of all namespaces.
+// With async=1 query param keys are removed in background and 202 Accepted is returned at once
+func (h *HttpHandler) HandleAdminRequest(w http.ResponseWriter, r *http.Request) {
+	if h.adminToken == `` {
+		http.Error(w, "Admin operations are disabled", http.StatusForbidden)
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), `Bearer `)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.adminToken)) != 1 {
+		http.Error(w, "Wrong admin token", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method "+r.Method+" is not allowed for admin operations", http.StatusMethodNotAllowed)
+		return
+	}
+	async := false
+	if asyncStr := r.URL.Query().Get("async"); len(asyncStr) > 0 {
+		var err error
+		async, err = strconv.ParseBool(asyncStr)
+		if err != nil {
+			http.Error(w, "Wrong async param: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// url - /admin/<operation>[/<namespace>]
+	pathParams := strings.Split(r.URL.Path, `/`)
+	var flush func()
+	switch pathParams[2] {
+	case `flushdb`:
+		namespace := ``
+		if len(pathParams) > 3 {
+			namespace = pathParams[3]
+		}
+		storage, ok := h.findNamespace(namespace)
+		if !ok {
+			// namespace does not exist, so it has no keys
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		flush = storage.clear
+	case `flushall`:
+		flush = h.storage.clear
+		if h.namespaces != nil {
+			flush = h.namespaces.clear
+		}
+	default:
+		http.Error(w, "Admin operation is not supported", http.StatusNotFound)
+		return
+	}
+
+	log.Printf("Performing admin operation %s", r.URL.Path)
+	if async {
+		go flush()
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	flush()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// returns storage of existing namespace, empty name means default one
+func (h *HttpHandler) findNamespace(name string) (*Storage, bool) {
+	if name == `` || name == DEFAULT_NAMESPACE {
+		return h.storage, true
+	}
+	if h.namespaces == nil {
+		return nil, false
+	}
+	return h.namespaces.find(name)
+}
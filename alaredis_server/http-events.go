@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// comment line is sent periodically, so disconnected clients are detected
+const EVENTS_PING_PERIOD = time.Duration(30)*time.Second
+
+// streams keyspace events as server-sent events - /events?match=<key pattern>&ops=<event>,<event>...
+// Each event has name of operation and json data with key, operation and unix time in milliseconds
+func (h *HttpHandler) HandleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method "+r.Method+" is not allowed for events", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	match := r.URL.Query().Get("match")
+	if _, err := path.Match(match, ``); err != nil {
+		http.Error(w, "Wrong match pattern: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	ops := make(map[string]bool)
+	if opsStr := r.URL.Query().Get("ops"); len(opsStr) > 0 {
+		for _, op := range strings.Split(opsStr, `,`) {
+			if !EVENT_NAMES[op] {
+				http.Error(w, "Unknown event '"+op+"'", http.StatusBadRequest)
+				return
+			}
+			ops[op] = true
+		}
+	}
+
+	sub := h.storage.events.subscribe(match, ops)
+	defer h.storage.events.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ping := time.NewTicker(EVENTS_PING_PERIOD)
+	defer ping.Stop()
+	for {
+		select {
+		case e, ok := <-sub.events:
+			if !ok {
+				return
+			}
+			writeDropped(w, sub.takeDropped())
+			buf, _ := json.Marshal(e)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Op, buf)
+		case <-ping.C:
+			writeDropped(w, sub.takeDropped())
+			fmt.Fprint(w, ": ping\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// slow subscriber is told how many events or messages it missed
+func writeDropped(w http.ResponseWriter, n uint64) {
+	if n > 0 {
+		fmt.Fprintf(w, "event: dropped\ndata: %d\n\n", n)
+	}
+}
+
+// streams messages of pub/sub channels as server-sent events - /subscribe?channel=<channel>&pattern=<channel pattern>...
+// Both params could be repeated. Each message is sent as json with channel, matched pattern and message itself
+func (h *HttpHandler) HandleSubscribe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method "+r.Method+" is not allowed for subscription", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	channels := r.URL.Query()["channel"]
+	patterns := r.URL.Query()["pattern"]
+	if len(channels) == 0 && len(patterns) == 0 {
+		http.Error(w, "Channel or pattern is not set", http.StatusBadRequest)
+		return
+	}
+	for _, p := range patterns {
+		if _, err := path.Match(p, ``); err != nil {
+			http.Error(w, "Wrong pattern '"+p+"': "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	sub := h.storage.pubsub.subscribe(channels, patterns)
+	defer h.storage.pubsub.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ping := time.NewTicker(EVENTS_PING_PERIOD)
+	defer ping.Stop()
+	for {
+		select {
+		case msg, ok := <-sub.messages:
+			if !ok {
+				return
+			}
+			writeDropped(w, sub.takeDropped())
+			buf, _ := json.Marshal(msg)
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", buf)
+		case <-ping.C:
+			writeDropped(w, sub.takeDropped())
+			fmt.Fprint(w, ": ping\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
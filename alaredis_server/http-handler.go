@@ -3,19 +3,27 @@ package main
 
 import (
 	"net/http"
+	"net/url"
 	"strings"
 	"io"
+	"bytes"
 	"github.com/yutas/alaredis-server/alaredis_lib"
 	"errors"
 	"strconv"
 	"log"
+	"io/ioutil"
+	"encoding/json"
 )
 
 
 type HttpHandler struct {
 	storage       *Storage
 	bodyParser    alaredis_lib.BodyParser
-	opBodyParsers []func(r io.Reader, val *interface{}) error
+	opBodyParsers map[int]func(r io.Reader, val *interface{}) error
+	// storages of namespaces, requests to them are served by HandleNamespaceRequest
+	namespaces    *Namespaces
+	// token required by admin operations, empty one disables them
+	adminToken    string
 }
 
 
@@ -32,6 +40,138 @@ var OPERATIONS = map[string]int {
 	`dget`: OP_DGET,
 	`dgeti`: OP_DGETI,
 	`dkeys`: OP_DKEYS,
+	`expire`: OP_EXPIRE,
+	`add`: OP_ADD,
+	`replace`: OP_REPLACE,
+	`touch`: OP_TOUCH,
+	`incrby`: OP_INCRBY,
+	`incr`: OP_INCR,
+	`decr`: OP_DECR,
+	`incrbyfloat`: OP_INCRBYFLOAT,
+	`lpush`: OP_LPUSH,
+	`rpush`: OP_RPUSH,
+	`lpop`: OP_LPOP,
+	`rpop`: OP_RPOP,
+	`llen`: OP_LLEN,
+	`lrange`: OP_LRANGE,
+	`ltrim`: OP_LTRIM,
+	`linsert`: OP_LINSERT,
+	`lrem`: OP_LREM,
+	`lpos`: OP_LPOS,
+	`ddeli`: OP_DDELI,
+	`dlen`: OP_DLEN,
+	`dexists`: OP_DEXISTS,
+	`dvals`: OP_DVALS,
+	`dmget`: OP_DMGET,
+	`dmset`: OP_DMSET,
+	`sadd`: OP_SADD,
+	`srem`: OP_SREM,
+	`smembers`: OP_SMEMBERS,
+	`sismember`: OP_SISMEMBER,
+	`scard`: OP_SCARD,
+	`spop`: OP_SPOP,
+	`sinter`: OP_SINTER,
+	`sunion`: OP_SUNION,
+	`sdiff`: OP_SDIFF,
+	`zadd`: OP_ZADD,
+	`zincrby`: OP_ZINCRBY,
+	`zrem`: OP_ZREM,
+	`zscore`: OP_ZSCORE,
+	`zrank`: OP_ZRANK,
+	`zcard`: OP_ZCARD,
+	`zrange`: OP_ZRANGE,
+	`zrangebyscore`: OP_ZRANGEBYSCORE,
+	`exec`: OP_EXEC,
+	`mget`: OP_MGET,
+	`mset`: OP_MSET,
+	`mdel`: OP_MDEL,
+	`scan`: OP_SCAN,
+	`dbsize`: OP_DBSIZE,
+	`ttl`: OP_TTL,
+	`expireat`: OP_EXPIREAT,
+	`persist`: OP_PERSIST,
+	`pttl`: OP_PTTL,
+	`publish`: OP_PUBLISH,
+	`blpop`: OP_BLPOP,
+	`brpop`: OP_BRPOP,
+	`xadd`: OP_XADD,
+	`xrange`: OP_XRANGE,
+	`xrevrange`: OP_XREVRANGE,
+	`xlen`: OP_XLEN,
+	`xtrim`: OP_XTRIM,
+	`xgroupcreate`: OP_XGROUPCREATE,
+	`xreadgroup`: OP_XREADGROUP,
+	`xack`: OP_XACK,
+	`xpending`: OP_XPENDING,
+	`bset`: OP_BSET,
+	`bget`: OP_BGET,
+	`jset`: OP_JSET,
+	`jget`: OP_JGET,
+	`jdel`: OP_JDEL,
+	`jarrappend`: OP_JARRAPPEND,
+	`jnumincrby`: OP_JNUMINCRBY,
+}
+
+// operations, which are not bound to one key, so key is not set in path
+var KEYLESS_OPERATIONS = map[int]bool {
+	OP_EXEC: true,
+	OP_MGET: true,
+	OP_MSET: true,
+	OP_MDEL: true,
+	OP_SCAN: true,
+	OP_DBSIZE: true,
+}
+
+// operations, which bodies are raw bytes or json documents passed as is, bypassing body parser. They are not supported
+// in transactions, as bodies of transaction operations are encoded by body parser
+var RAW_BODY_OPERATIONS = map[int]bool {
+	OP_BSET: true,
+	OP_BGET: true,
+	OP_JSET: true,
+	OP_JGET: true,
+	OP_JDEL: true,
+	OP_JARRAPPEND: true,
+	OP_JNUMINCRBY: true,
+}
+
+// content type of raw bytes bodies
+const RAW_BODY_CONTENT_TYPE = `application/octet-stream`
+
+// content type of json documents
+const JSON_BODY_CONTENT_TYPE = `application/json`
+
+// number of required path params after key - /<operation>/<key>/<idx>/<args>...
+// Optional params may follow required ones
+var OPERATION_PARAMS = map[int]int {
+	OP_LGETI: 1,
+	OP_LSETI: 1,
+	OP_DSETI: 1,
+	OP_DGETI: 1,
+	OP_INCRBY: 1,
+	OP_INCRBYFLOAT: 1,
+	OP_LRANGE: 2,
+	OP_LTRIM: 2,
+	OP_LINSERT: 2,
+	OP_LREM: 1,
+	OP_LPOS: 1,
+	OP_DDELI: 1,
+	OP_DEXISTS: 1,
+	OP_SISMEMBER: 1,
+	OP_ZINCRBY: 1,
+	OP_ZSCORE: 1,
+	OP_ZRANK: 1,
+	OP_ZRANGE: 2,
+	OP_ZRANGEBYSCORE: 2,
+	OP_EXPIREAT: 1,
+	OP_XRANGE: 2,
+	OP_XREVRANGE: 2,
+	OP_XTRIM: 1,
+	OP_XGROUPCREATE: 1,
+	OP_XREADGROUP: 2,
+	OP_XACK: 1,
+	OP_XPENDING: 1,
+	OP_JARRAPPEND: 1,
+	OP_JNUMINCRBY: 2,
 }
 
 
@@ -41,24 +181,61 @@ func NewHttpHandler(storage *Storage, bodyParser alaredis_lib.BodyParser) *HttpH
 	h.storage = storage
 	h.bodyParser = bodyParser
 
-	h.opBodyParsers = make([]func(r io.Reader, val *interface{}) error, len(OPERATIONS))
+	h.opBodyParsers = make(map[int]func(r io.Reader, val *interface{}) error)
 	h.opBodyParsers[OP_SET] = func(r io.Reader, val *interface{}) error {
 		v, err := h.bodyParser.GetStringValue(r)
 		*val = v
 		return err
 	}
+	h.opBodyParsers[OP_ADD] = h.opBodyParsers[OP_SET]
+	h.opBodyParsers[OP_REPLACE] = h.opBodyParsers[OP_SET]
 	h.opBodyParsers[OP_LSETI] = h.opBodyParsers[OP_SET]
+	h.opBodyParsers[OP_LINSERT] = h.opBodyParsers[OP_SET]
+	h.opBodyParsers[OP_LREM] = h.opBodyParsers[OP_SET]
 	h.opBodyParsers[OP_DSETI] = h.opBodyParsers[OP_SET]
+	h.opBodyParsers[OP_ZINCRBY] = h.opBodyParsers[OP_SET]
+	h.opBodyParsers[OP_PUBLISH] = h.opBodyParsers[OP_SET]
 	h.opBodyParsers[OP_LSET] = func(r io.Reader, val *interface{}) error {
 		v, err := h.bodyParser.GetListValue(r)
 		*val = v
 		return err
 	}
+	h.opBodyParsers[OP_LPUSH] = h.opBodyParsers[OP_LSET]
+	h.opBodyParsers[OP_RPUSH] = h.opBodyParsers[OP_LSET]
+	h.opBodyParsers[OP_DMGET] = h.opBodyParsers[OP_LSET]
+	h.opBodyParsers[OP_ZREM] = h.opBodyParsers[OP_LSET]
+	h.opBodyParsers[OP_SADD] = h.opBodyParsers[OP_LSET]
+	h.opBodyParsers[OP_SREM] = h.opBodyParsers[OP_LSET]
+	h.opBodyParsers[OP_SINTER] = h.opBodyParsers[OP_LSET]
+	h.opBodyParsers[OP_SUNION] = h.opBodyParsers[OP_LSET]
+	h.opBodyParsers[OP_SDIFF] = h.opBodyParsers[OP_LSET]
+	h.opBodyParsers[OP_MGET] = h.opBodyParsers[OP_LSET]
+	h.opBodyParsers[OP_MDEL] = h.opBodyParsers[OP_LSET]
+	h.opBodyParsers[OP_XACK] = h.opBodyParsers[OP_LSET]
 	h.opBodyParsers[OP_DSET] = func(r io.Reader, val *interface{}) error {
 		v, err := h.bodyParser.GetDictValue(r)
 		*val = v
 		return err
 	}
+	h.opBodyParsers[OP_ZADD] = h.opBodyParsers[OP_DSET]
+	h.opBodyParsers[OP_MSET] = h.opBodyParsers[OP_DSET]
+	h.opBodyParsers[OP_XADD] = h.opBodyParsers[OP_DSET]
+	h.opBodyParsers[OP_DMSET] = func(r io.Reader, val *interface{}) error {
+		v, err := h.bodyParser.GetDictPatchValue(r)
+		*val = v
+		return err
+	}
+	h.opBodyParsers[OP_BSET] = func(r io.Reader, val *interface{}) error {
+		v, err := ioutil.ReadAll(r)
+		*val = v
+		return err
+	}
+	h.opBodyParsers[OP_JSET] = func(r io.Reader, val *interface{}) error {
+		v, err := ioutil.ReadAll(r)
+		*val = json.RawMessage(v)
+		return err
+	}
+	h.opBodyParsers[OP_JARRAPPEND] = h.opBodyParsers[OP_JSET]
 
 	return h
 }
@@ -70,11 +247,34 @@ func (h *HttpHandler) HandleRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	//log.Printf("Received request %s with body '%v'", r.URL, (*req).val)
-	h.storage.processInnerRequest(req)
+	if BLOCKING_OPERATIONS[req.op] {
+		// waiting is stopped, when client disconnects
+		h.storage.processBlockingRequest(r.Context(), req)
+	} else {
+		h.storage.processInnerRequest(req)
+	}
 	select {
 	case val:=<-req.outCh:
-		if val == nil {
+		if req.op == OP_EXEC {
+			val = h.composeTxResults(val.([]interface{}))
+		}
+		val = toResponseValue(val)
+		etag := ``
+		// publish key is channel, not stored object
+		if req.version > 0 && req.op != OP_DELETE && req.op != OP_PUBLISH {
+			etag = versionToETag(req.version)
+			w.Header().Set("ETag", etag)
+		}
+		if etag != `` && READ_OPERATIONS[req.op] && r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+		} else if val == nil {
 			w.WriteHeader(http.StatusNoContent)
+		} else if raw, ok := val.([]byte); ok {
+			w.Header().Set("Content-Type", RAW_BODY_CONTENT_TYPE)
+			w.Write(raw)
+		} else if doc, ok := val.(json.RawMessage); ok {
+			w.Header().Set("Content-Type", JSON_BODY_CONTENT_TYPE)
+			w.Write(doc)
 		} else {
 			buf, err := h.bodyParser.ComposeBody(val)
 			if err == nil {
@@ -91,6 +291,12 @@ func (h *HttpHandler) HandleRequest(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 			case *ObjectNotFound:
 				http.Error(w, err.Error(), http.StatusNotFound)
+			case *ObjectExists:
+				http.Error(w, err.Error(), http.StatusConflict)
+			case *VersionMismatch:
+				http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			case *OutOfMemory:
+				http.Error(w, err.Error(), http.StatusInsufficientStorage)
 			default:
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 			}
@@ -100,6 +306,41 @@ func (h *HttpHandler) HandleRequest(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// serves requests to namespace - /db/<namespace>/<operation>/<key>/..., /db/<namespace>/events and
+// /db/<namespace>/subscribe. Request is handled as request without namespace prefix, but on namespace storage.
+// Pub/sub channels are shared by namespaces, so subscription is the same as one without namespace
+func (h *HttpHandler) HandleNamespaceRequest(w http.ResponseWriter, r *http.Request) {
+	// url - /db/<namespace>/<rest of url>
+	pathParams := strings.SplitN(r.URL.Path, `/`, 4)
+	if len(pathParams) < 4 || len(pathParams[2]) == 0 {
+		http.Error(w, "Namespace or operation is not set", http.StatusBadRequest)
+		return
+	}
+	if h.namespaces == nil {
+		http.Error(w, "Namespaces are not supported", http.StatusNotFound)
+		return
+	}
+	storage, err := h.namespaces.get(pathParams[2])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	nsHandler := *h
+	nsHandler.storage = storage
+	nsUrl := *r.URL
+	nsUrl.Path = `/`+pathParams[3]
+	nsUrl.RawPath = ``
+	nsRequest := r.WithContext(r.Context())
+	nsRequest.URL = &nsUrl
+	switch nsUrl.Path {
+	case `/events`:
+		nsHandler.HandleEvents(w, nsRequest)
+	case `/subscribe`:
+		nsHandler.HandleSubscribe(w, nsRequest)
+	default:
+		nsHandler.HandleRequest(w, nsRequest)
+	}
+}
 
 func (h *HttpHandler) createInnerRequest(w http.ResponseWriter, r *http.Request) (*innerRequest, error) {
 	// url - /<operation>/<key>/<idx>
@@ -113,37 +354,204 @@ func (h *HttpHandler) createInnerRequest(w http.ResponseWriter, r *http.Request)
 		return nil, errors.New("Method "+r.Method+" is not allowed for requested operation")
 	}
 
-	if len(pathParams) < 3 || len(pathParams[2])== 0 {
+	if op == OP_EXEC {
+		tx, err := h.parseTransaction(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		return h.storage.newInnerRequest(op, ``, ``, tx, 0), nil
+	}
+	if op == OP_SCAN {
+		q, err := parseScanQuery(r.URL.Query())
+		if err != nil {
+			return nil, err
+		}
+		return h.storage.newInnerRequest(op, ``, ``, q, 0), nil
+	}
+
+	key := ``
+	if len(pathParams) > 2 {
+		key = pathParams[2]
+	}
+	if len(key) == 0 && !KEYLESS_OPERATIONS[op] {
 		return nil, errors.New("Key is not set or is empty")
 	}
-	key := pathParams[2]
+	// ttl is set in seconds by ttl param or in milliseconds by pttl param
+	ttlStr := r.URL.Query().Get("ttl")
+	pttlStr := r.URL.Query().Get("pttl")
+	var ttl int64
+	if len(pttlStr) > 0 {
+		var err error
+		ttl, err = strconv.ParseInt(pttlStr, 10, 64)
+		if err != nil {
+			return nil, errors.New("Non integer pttl: "+err.Error())
+		}
+	} else if len(ttlStr) > 0 {
+		var err error
+		ttl, err = strconv.ParseInt(ttlStr, 10, 64)
+		if err != nil {
+			return nil, errors.New("Non integer ttl: "+err.Error())
+		}
+		ttl *= 1000
+	} else if op == OP_EXPIRE || op == OP_TOUCH {
+		return nil, errors.New("Ttl param is not set")
+	}
+	var params []string
+	if len(pathParams) > 3 {
+		params = pathParams[3:]
+	}
+	req, err := h.composeRequest(op, key, params, r.Body, ttl)
+	if err != nil {
+		return nil, err
+	}
+	if ifMatch := r.Header.Get("If-Match"); len(ifMatch) > 0 && !READ_OPERATIONS[op] {
+		version, err := eTagToVersion(ifMatch)
+		if err != nil {
+			return nil, errors.New("Wrong If-Match header: "+err.Error())
+		}
+		req.checkVersion = true
+		req.ifVersion = version
+	}
+	return req, nil
+}
+
+// creates request for operation on key, params are path params after key, ttl is in milliseconds
+func (h *HttpHandler) composeRequest(op int, key string, params []string, body io.Reader, ttl int64) (*innerRequest, error) {
 	var idx string
-	if op == OP_LGETI || op == OP_LSETI || op == OP_DSETI || op == OP_DGETI {
-		if len(pathParams) < 3 || len(pathParams[3]) == 0 {
+	var args []string
+	paramsNum := OPERATION_PARAMS[op]
+	if len(params) < paramsNum {
+		return nil, errors.New("Index param is not set")
+	}
+	for _, p := range params[:paramsNum] {
+		if len(p) == 0 {
 			return nil, errors.New("Index param is not set")
 		}
-		idx = pathParams[3]
+	}
+	if len(params) > 0 {
+		idx = params[0]
+		args = params[1:]
 	}
 	f := h.opBodyParsers[op]
 	var val interface{}
 	if f != nil {
-		f(r.Body, &val)
+		f(body, &val)
 	}
-	ttlStr := r.URL.Query().Get("ttl")
-	var ttl int64
-	if len(ttlStr) > 0 {
+	req := h.storage.newInnerRequest(op, key, idx, val, 0)
+	req.ttl = ttl
+	req.args = args
+	return req, nil
+}
+
+// converts transaction from body to requests
+func (h *HttpHandler) parseTransaction(body io.Reader) (*transaction, error) {
+	v, err := h.bodyParser.GetTransactionValue(body)
+	if err != nil {
+		return nil, errors.New("Wrong transaction: "+err.Error())
+	}
+	tx := new(transaction)
+	for k, version := range v.Watch {
+		req := h.storage.newInnerRequest(OP_GET, k, ``, nil, 0)
+		req.ifVersion = version
+		tx.watch = append(tx.watch, req)
+	}
+	for i, txOp := range v.Ops {
+		op, ok := OPERATIONS[txOp.Op]
+		if !ok || KEYLESS_OPERATIONS[op] || RAW_BODY_OPERATIONS[op] {
+			return nil, errors.New("Operation #"+strconv.Itoa(i)+" is not supported in transaction")
+		}
+		if len(txOp.Key) == 0 {
+			return nil, errors.New("Key of operation #"+strconv.Itoa(i)+" is not set or is empty")
+		}
+		params := txOp.Args
+		if len(txOp.Idx) > 0 {
+			params = append([]string{txOp.Idx}, params...)
+		}
+		req, err := h.composeRequest(op, txOp.Key, params, bytes.NewReader(txOp.Body), txOp.Ttl*1000)
+		if err != nil {
+			return nil, errors.New("Operation #"+strconv.Itoa(i)+": "+err.Error())
+		}
+		tx.reqs = append(tx.reqs, req)
+	}
+	return tx, nil
+}
+
+// encodes result of each transaction operation with body parser
+func (h *HttpHandler) composeTxResults(vals []interface{}) []alaredis_lib.TxResult {
+	res := make([]alaredis_lib.TxResult, len(vals))
+	for i, v := range vals {
+		if err, ok := v.(error); ok {
+			res[i].Err = err.Error()
+		} else if v != nil {
+			buf, err := h.bodyParser.ComposeBody(toResponseValue(v))
+			if err != nil {
+				res[i].Err = err.Error()
+			} else {
+				res[i].Body = buf.Bytes()
+			}
+		}
+	}
+	return res
+}
+
+// converts stream entries returned by storage to types, which body parsers can encode
+func toResponseValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case []streamEntry:
+		entries := make([]alaredis_lib.StreamEntry, len(v))
+		for i, e := range v {
+			entries[i] = alaredis_lib.StreamEntry{Id: e.id.String(), Fields: e.fields}
+		}
+		return entries
+	case []pendingEntry:
+		now := nowMillis()
+		entries := make([]alaredis_lib.PendingEntry, len(v))
+		for i, p := range v {
+			entries[i] = alaredis_lib.PendingEntry{Id: p.id.String(), Consumer: p.consumer, Idle: now-p.deliveredAt, Deliveries: p.deliveries}
+		}
+		return entries
+	}
+	return val
+}
+
+// scan params are passed as query - ?cursor=<cursor>&match=<pattern>&type=<type>&count=<count>
+func parseScanQuery(query url.Values) (*scanQuery, error) {
+	q := &scanQuery{cursor: query.Get("cursor"), match: query.Get("match"), count: SCAN_DEFAULT_COUNT}
+	if t := query.Get("type"); len(t) > 0 {
+		for typeId, name := range TYPE_NAMES {
+			if name == t {
+				q.t = typeId
+			}
+		}
+		if q.t == TYPE_NULL {
+			return nil, errors.New("Unknown type '"+t+"'")
+		}
+	}
+	if countStr := query.Get("count"); len(countStr) > 0 {
 		var err error
-		ttl, err = strconv.ParseInt(ttlStr, 10, 64)
+		q.count, err = strconv.Atoi(countStr)
 		if err != nil {
-			return nil, errors.New("Non integer ttl: "+err.Error())
+			return nil, errors.New("Non integer count: "+err.Error())
 		}
 	}
-	return (*h.storage).newInnerRequest(op, key, idx, val, ttl), nil
+	return q, nil
+}
+
+// ETag is quoted object version
+func versionToETag(version uint64) string {
+	return `"`+strconv.FormatUint(version, 10)+`"`
+}
+
+func eTagToVersion(etag string) (uint64, error) {
+	return strconv.ParseUint(strings.Trim(etag, `"`), 10, 64)
 }
 
 func isMethodSupported(method string, operation int) bool {
 	switch operation {
-	case OP_GET, OP_LGETI, OP_LGET, OP_DGETI, OP_DGET:
+	case OP_GET, OP_LGETI, OP_LGET, OP_DGETI, OP_DGET, OP_DKEYS, OP_LLEN, OP_LRANGE, OP_LPOS,
+		OP_DLEN, OP_DEXISTS, OP_DVALS, OP_SMEMBERS, OP_SISMEMBER, OP_SCARD,
+		OP_ZSCORE, OP_ZRANK, OP_ZCARD, OP_ZRANGE, OP_ZRANGEBYSCORE, OP_SCAN, OP_DBSIZE, OP_TTL, OP_PTTL,
+		OP_XRANGE, OP_XREVRANGE, OP_XLEN, OP_XPENDING, OP_BGET, OP_JGET:
 		return strings.ToUpper(method) == http.MethodGet
 	default:
 		return strings.ToUpper(method) == http.MethodPost
@@ -26,20 +26,32 @@ func main() {
 	var logFile string
 	var bucketsNum int
 	var listenPort int
+	var respPort int
+	var memcachedPort int
 	var cpuprofile = ``
 	var threads = 0
 	var persist = false
 	var persistDir = ``
 	var restoreFile = ``
+	var maxMemory int64
+	var evictionPolicy = ``
+	var namespacesNum int
+	var adminToken = ``
 
 	flag.StringVar(&logFile, "log", ``, `path to log file`)
 	flag.IntVar(&bucketsNum, "b", 4, `number of buckets used by storage`)
 	flag.IntVar(&listenPort, "p", 8080, `port to be listen by http server`)
+	flag.IntVar(&respPort, "rp", 0, `port to be listen by redis protocol (RESP) server, 0 to disable it`)
+	flag.IntVar(&memcachedPort, "mp", 0, `port to be listen by memcached text protocol server, 0 to disable it`)
 	flag.StringVar(&cpuprofile, "cpuprofile", "", "write cpu profile to file")
 	flag.IntVar(&threads, "thr", 0, `sets GOMAXPROCS value`)
 	flag.BoolVar(&persist, "persist", false, "whether to use data persistence to file")
 	flag.StringVar(&persistDir, "pdir", "", "dir for persisted data")
 	flag.StringVar(&restoreFile, "restore", "", "file with persisted data to be restored from")
+	flag.Int64Var(&maxMemory, "maxmemory", 0, `approximate memory limit for stored data in bytes, 0 to disable it. It is split evenly between max number of namespaces`)
+	flag.StringVar(&evictionPolicy, "eviction", `noeviction`, `policy of keys eviction, when memory limit is reached: noeviction, allkeys-lru, allkeys-lfu, volatile-lru, volatile-ttl or random`)
+	flag.IntVar(&namespacesNum, "dbs", 16, `max number of namespaces (logical databases) including default one`)
+	flag.StringVar(&adminToken, "admintoken", ``, `token required by admin operations (flushdb, flushall) in Authorization header, empty one disables them`)
 	flag.Parse()
 
 	/**
@@ -68,15 +80,26 @@ func main() {
 		runtime.GOMAXPROCS(threads)
 	}
 
+	policy, ok := EVICTION_POLICIES[evictionPolicy]
+	if !ok {
+		log.Fatalf("Unknown eviction policy '%s'", evictionPolicy)
+	}
+	if namespacesNum < 1 {
+		log.Fatalf("Max number of namespaces must be positive, got %d", namespacesNum)
+	}
+
 
 	/**
 	 * Working with storage and http server
 	 */
 
 	storage := NewStorage(bucketsNum)
+	// each namespace gets the same share of memory limit, so all of them together fit into it
+	storage.setMemoryLimit(maxMemory/int64(namespacesNum), policy)
+	namespaces := NewNamespaces(storage, namespacesNum)
 	var persister *Persister
 	if persist {
-		persister = &Persister{memStorage: storage, dir: persistDir}
+		persister = &Persister{memStorage: storage, namespaces: namespaces, dir: persistDir}
 	}
 	storage.run()
 	if len(restoreFile) > 0 {
@@ -94,7 +117,36 @@ func main() {
 		}()
 	}
 	httpHandler := NewHttpHandler(storage, alaredis_lib.BodyParserJson{})
+	httpHandler.namespaces = namespaces
+	httpHandler.adminToken = adminToken
 	http.HandleFunc("/", (*httpHandler).HandleRequest)
+	http.HandleFunc("/db/", (*httpHandler).HandleNamespaceRequest)
+	http.HandleFunc("/admin/", (*httpHandler).HandleAdminRequest)
+	http.HandleFunc("/events", (*httpHandler).HandleEvents)
+	http.HandleFunc("/subscribe", (*httpHandler).HandleSubscribe)
+
+	var respServer *RespServer
+	if respPort > 0 {
+		respServer = NewRespServer(storage)
+		respServer.namespaces = namespaces
+		go func() {
+			log.Printf("Listening port %d for RESP connections", respPort)
+			if err := respServer.ListenAndServe(fmt.Sprintf(":%d", respPort)); err != nil {
+				log.Printf("Got RESP serve error '%v'", err)
+			}
+		}()
+	}
+
+	var memcachedServer *MemcachedServer
+	if memcachedPort > 0 {
+		memcachedServer = NewMemcachedServer(storage)
+		go func() {
+			log.Printf("Listening port %d for memcached connections", memcachedPort)
+			if err := memcachedServer.ListenAndServe(fmt.Sprintf(":%d", memcachedPort)); err != nil {
+				log.Printf("Got memcached serve error '%v'", err)
+			}
+		}()
+	}
 
 
 
@@ -109,7 +161,18 @@ func main() {
 		sig := <-signals
 		gracefulShutdown = true
 		log.Printf("Got signal %v, shutting down...\n", sig)
+		// event and subscription streams are finished, so they do not block http server shutdown
+		for _, s := range namespaces.all() {
+			s.events.close()
+		}
+		storage.pubsub.close()
 		graceful.Close()
+		if respServer != nil {
+			respServer.Close()
+		}
+		if memcachedServer != nil {
+			memcachedServer.Close()
+		}
 		persister.wait()
 		gracefulShutdownFinished <- struct {}{}
 	}()
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"net"
+	"bufio"
+	"io"
+	"strings"
+	"strconv"
+	"time"
+	"log"
+)
+
+const (
+	// memcached treats exptime bigger than 30 days as unix timestamp
+	MEMCACHED_MAX_RELATIVE_EXPTIME = 60*60*24*30
+	MEMCACHED_MAX_KEY_LENGTH = 250
+	MEMCACHED_MAX_ITEM_SIZE = 1024*1024
+	// max length of command line, which is far longer than any valid command
+	MEMCACHED_MAX_LINE_LENGTH = 8*1024
+	MEMCACHED_VERSION = `1.4.0-alaredis`
+)
+
+// MemcachedServer serves string objects of storage over memcached text protocol.
+// Item flags are not stored, so they are always returned as 0
+type MemcachedServer struct {
+	storage  *Storage
+	listener net.Listener
+	closed   bool
+}
+
+type memcachedError struct {
+	msg string
+}
+
+func (e *memcachedError) Error() string {
+	return e.msg
+}
+
+func NewMemcachedServer(storage *Storage) *MemcachedServer {
+	srv := new(MemcachedServer)
+	srv.storage = storage
+	return srv
+}
+
+func (srv *MemcachedServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil { return err }
+	srv.listener = l
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if srv.closed { return nil }
+			return err
+		}
+		go srv.serveConn(conn)
+	}
+}
+
+// stops accepting new connections
+func (srv *MemcachedServer) Close() {
+	srv.closed = true
+	if srv.listener != nil {
+		srv.listener.Close()
+	}
+}
+
+func (srv *MemcachedServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		line, err := readLimitedLine(r, MEMCACHED_MAX_LINE_LENGTH)
+		if err == io.EOF { return }
+		if err == errLineTooLong {
+			// the rest of line is not read, so connection can not be used anymore
+			w.WriteString("CLIENT_ERROR line is too long\r\n")
+			w.Flush()
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to read memcached command: %v", err)
+			return
+		}
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			w.WriteString("ERROR\r\n")
+			w.Flush()
+			continue
+		}
+		cmd := args[0]
+		if cmd == `quit` { return }
+
+		var reply string
+		noreply := false
+		switch cmd {
+		case `get`, `gets`:
+			reply, err = srv.get(args[1:], cmd == `gets`)
+		case `set`, `add`, `replace`:
+			noreply = len(args) > 5 && args[5] == `noreply`
+			reply, err = srv.store(cmd, args[1:], r)
+		case `cas`:
+			noreply = len(args) > 6 && args[6] == `noreply`
+			reply, err = srv.store(cmd, args[1:], r)
+		case `delete`:
+			noreply = len(args) > 2 && args[len(args)-1] == `noreply`
+			reply, err = srv.delete(args[1:])
+		case `touch`:
+			noreply = len(args) > 3 && args[3] == `noreply`
+			reply, err = srv.touch(args[1:])
+		case `incr`, `decr`:
+			noreply = len(args) > 3 && args[3] == `noreply`
+			reply, err = srv.incr(args[1:], cmd == `decr`)
+		case `version`:
+			reply = "VERSION " + MEMCACHED_VERSION + "\r\n"
+		default:
+			reply = "ERROR\r\n"
+		}
+
+		if err != nil {
+			switch err.(type) {
+			case *memcachedError:
+				reply = err.Error() + "\r\n"
+			default:
+				reply = "SERVER_ERROR " + err.Error() + "\r\n"
+			}
+		} else if noreply {
+			continue
+		}
+		w.WriteString(reply)
+		// pipelined commands are answered at once
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil { return }
+		}
+	}
+}
+
+func (srv *MemcachedServer) call(op int, key string, idx string, val interface{}, ttl int64) (interface{}, error) {
+	return srv.storage.doRequest(srv.storage.newInnerRequest(op, key, idx, val, ttl))
+}
+
+/**
+ * Commands
+ */
+
+// get <key>*
+func (srv *MemcachedServer) get(keys []string, withCas bool) (string, error) {
+	if len(keys) == 0 {
+		return "ERROR\r\n", nil
+	}
+	reply := ``
+	for _, k := range keys {
+		req := srv.storage.newInnerRequest(OP_GET, k, ``, nil, 0)
+		v, err := srv.storage.doRequest(req)
+		if err != nil {
+			switch err.(type) {
+			case *ObjectNotFound, *BadRequest:
+				// missing or non string objects are just not returned
+				continue
+			default:
+				return ``, err
+			}
+		}
+		val := v.(string)
+		reply += "VALUE " + k + " 0 " + strconv.Itoa(len(val))
+		if withCas {
+			reply += " " + strconv.FormatUint(req.version, 10)
+		}
+		reply += "\r\n" + val + "\r\n"
+	}
+	return reply + "END\r\n", nil
+}
+
+// <command> <key> <flags> <exptime> <bytes> [<cas unique>] [noreply]
+func (srv *MemcachedServer) store(cmd string, args []string, r *bufio.Reader) (string, error) {
+	if len(args) < 4 || (cmd == `cas` && len(args) < 5) {
+		return "ERROR\r\n", nil
+	}
+	size, err := strconv.Atoi(args[3])
+	if err != nil || size < 0 {
+		return ``, &memcachedError{"CLIENT_ERROR bad data chunk"}
+	}
+	if size > MEMCACHED_MAX_ITEM_SIZE {
+		// data block is skipped without buffering, so connection stays usable
+		if _, err := r.Discard(size); err != nil { return ``, err }
+		if _, err := r.Discard(2); err != nil { return ``, err }
+		return ``, &memcachedError{"SERVER_ERROR object too large for cache"}
+	}
+	buf := make([]byte, size+2)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return ``, err
+	}
+	if string(buf[size:]) != "\r\n" {
+		return ``, &memcachedError{"CLIENT_ERROR bad data chunk"}
+	}
+	k := args[0]
+	if err := validateMemcachedKey(k); err != nil {
+		return ``, err
+	}
+	if _, err := strconv.ParseUint(args[1], 10, 32); err != nil {
+		return ``, &memcachedError{"CLIENT_ERROR bad command line format"}
+	}
+	ttl, expired, err := parseMemcachedExptime(args[2])
+	if err != nil { return ``, err }
+	if expired {
+		// item is stored expired, so storing only removes previous one in the same operation
+		ttl = -1
+	}
+
+	op := OP_SET
+	switch cmd {
+	case `add`:
+		op = OP_ADD
+	case `replace`:
+		op = OP_REPLACE
+	}
+	req := srv.storage.newInnerRequest(op, k, ``, string(buf[:size]), ttl)
+	if cmd == `cas` {
+		req.checkVersion = true
+		req.ifVersion, err = strconv.ParseUint(args[4], 10, 64)
+		if err != nil || req.ifVersion == 0 {
+			return ``, &memcachedError{"CLIENT_ERROR bad command line format"}
+		}
+	}
+	_, err = srv.storage.doRequest(req)
+	if err != nil {
+		switch err.(type) {
+		case *ObjectNotFound, *ObjectExists:
+			return "NOT_STORED\r\n", nil
+		case *VersionMismatch:
+			// zero version means that object does not exist
+			if req.version == 0 {
+				return "NOT_FOUND\r\n", nil
+			}
+			return "EXISTS\r\n", nil
+		default:
+			return ``, err
+		}
+	}
+	return "STORED\r\n", nil
+}
+
+// delete <key> [noreply]
+func (srv *MemcachedServer) delete(args []string) (string, error) {
+	if len(args) < 1 {
+		return "ERROR\r\n", nil
+	}
+	req := srv.storage.newInnerRequest(OP_DELETE, args[0], ``, nil, 0)
+	req.mustExist = true
+	_, err := srv.storage.doRequest(req)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return "NOT_FOUND\r\n", nil
+	}
+	if err != nil { return ``, err }
+	return "DELETED\r\n", nil
+}
+
+// touch <key> <exptime> [noreply]
+func (srv *MemcachedServer) touch(args []string) (string, error) {
+	if len(args) < 2 {
+		return "ERROR\r\n", nil
+	}
+	ttl, expired, err := parseMemcachedExptime(args[1])
+	if err != nil { return ``, err }
+	if expired {
+		ttl = -1
+	}
+	_, err = srv.call(OP_TOUCH, args[0], ``, nil, ttl)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return "NOT_FOUND\r\n", nil
+	}
+	if err != nil { return ``, err }
+	return "TOUCHED\r\n", nil
+}
+
+// incr|decr <key> <value> [noreply]
+func (srv *MemcachedServer) incr(args []string, decr bool) (string, error) {
+	if len(args) < 2 {
+		return "ERROR\r\n", nil
+	}
+	delta, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || delta < 0 {
+		return ``, &memcachedError{"CLIENT_ERROR invalid numeric delta argument"}
+	}
+	if decr {
+		delta = -delta
+	}
+	// unlike incrby operation memcached does not create missing keys and does not decrement below zero
+	req := srv.storage.newInnerRequest(OP_INCRBY, args[0], strconv.FormatInt(delta, 10), nil, 0)
+	req.args = []string{`0`}
+	req.mustExist = true
+	v, err := srv.storage.doRequest(req)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return "NOT_FOUND\r\n", nil
+	}
+	if _, ok := err.(*BadRequest); ok {
+		if req.meta.t != TYPE_STRING {
+			// non string objects are not visible for memcached
+			return "NOT_FOUND\r\n", nil
+		}
+		return ``, &memcachedError{"CLIENT_ERROR cannot increment or decrement non-numeric value"}
+	}
+	if err != nil { return ``, err }
+	return v.(string) + "\r\n", nil
+}
+
+func validateMemcachedKey(k string) error {
+	if len(k) > MEMCACHED_MAX_KEY_LENGTH {
+		return &memcachedError{"CLIENT_ERROR key is too long"}
+	}
+	return nil
+}
+
+// converts memcached exptime to ttl, negative exptime means item is expired immediately
+func parseMemcachedExptime(s string) (ttl int64, expired bool, err error) {
+	exptime, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, false, &memcachedError{"CLIENT_ERROR bad command line format"}
+	}
+	if exptime > MEMCACHED_MAX_RELATIVE_EXPTIME {
+		exptime -= time.Now().Unix()
+		if exptime <= 0 {
+			return 0, true, nil
+		}
+	}
+	if exptime < 0 {
+		return 0, true, nil
+	}
+	return exptime, false, nil
+}
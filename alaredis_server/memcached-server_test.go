@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"net"
+	"bufio"
+	"strings"
+)
+
+func TestMemcachedServer_Commands(t *testing.T) {
+	s := NewStorage(2)
+	s.run()
+	defer s.stop()
+	srv := NewMemcachedServer(s)
+	client, server := net.Pipe()
+	defer client.Close()
+	go srv.serveConn(server)
+	r := bufio.NewReader(client)
+
+	cases := []struct {
+		cmd      string
+		expected []string
+	}{
+		{"set foo 0 0 3\r\nbar\r\n", []string{"STORED\r\n"}},
+		{"get foo missing\r\n", []string{"VALUE foo 0 3\r\n", "bar\r\n", "END\r\n"}},
+		{"gets foo\r\n", []string{"VALUE foo 0 3 1\r\n", "bar\r\n", "END\r\n"}},
+		{"cas foo 0 0 3 2\r\nbaz\r\n", []string{"EXISTS\r\n"}},
+		{"cas foo 0 0 3 1\r\nbaz\r\n", []string{"STORED\r\n"}},
+		{"cas missing 0 0 3 1\r\nbaz\r\n", []string{"NOT_FOUND\r\n"}},
+		{"add foo 0 0 3\r\nbaz\r\n", []string{"NOT_STORED\r\n"}},
+		{"replace missing 0 0 3\r\nbaz\r\n", []string{"NOT_STORED\r\n"}},
+		{"set counter 0 100 2\r\n10\r\n", []string{"STORED\r\n"}},
+		{"incr counter 5\r\n", []string{"15\r\n"}},
+		{"decr counter 3\r\n", []string{"12\r\n"}},
+		{"decr counter 100\r\n", []string{"0\r\n"}},
+		{"incr foo 1\r\n", []string{"CLIENT_ERROR cannot increment or decrement non-numeric value\r\n"}},
+		{"incr missing 1\r\n", []string{"NOT_FOUND\r\n"}},
+		{"touch counter 0\r\n", []string{"TOUCHED\r\n"}},
+		{"delete foo\r\n", []string{"DELETED\r\n"}},
+		{"delete foo\r\n", []string{"NOT_FOUND\r\n"}},
+		{"set counter 0 -1 1\r\n1\r\n", []string{"STORED\r\n"}},
+		{"get counter\r\n", []string{"END\r\n"}},
+		{"set big 0 0 1048577\r\n"+strings.Repeat("x", 1048577)+"\r\n", []string{"SERVER_ERROR object too large for cache\r\n"}},
+		{"get big\r\n", []string{"END\r\n"}},
+		{"unknown\r\n", []string{"ERROR\r\n"}},
+		{strings.Repeat("x", MEMCACHED_MAX_LINE_LENGTH)+"\r\n", []string{"CLIENT_ERROR line is too long\r\n"}},
+	}
+	for _, c := range cases {
+		if _, err := client.Write([]byte(c.cmd)); err != nil {
+			t.Fatalf("Failed to write command %q: %v", c.cmd, err)
+		}
+		for _, expected := range c.expected {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("Failed to read reply for %q: %v", c.cmd, err)
+			}
+			if line != expected {
+				t.Errorf("Wrong reply for %q: expected %q, got %q", c.cmd, expected, line)
+			}
+		}
+	}
+}
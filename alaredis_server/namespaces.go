@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+)
+
+// namespace of requests, which do not select one
+const DEFAULT_NAMESPACE = `0`
+
+// max length of namespace name
+const NAMESPACE_NAME_MAX_LEN = 64
+
+// Namespaces are logical databases, so services sharing one server do not collide by keys. Each namespace is separate
+// storage with its own keys meta, buckets and ttl monitor, so it is scanned, sized and flushed independently.
+// Pub/sub channels are shared by all namespaces. Namespace is created on first access.
+// Memory limit of each namespace is a share of server memory limit, so all namespaces together fit into it
+type Namespaces struct {
+	defaultStorage *Storage
+	storages       map[string]*Storage
+	lock           sync.RWMutex
+	// max count of namespaces including default one
+	maxNum int
+}
+
+func NewNamespaces(defaultStorage *Storage, maxNum int) *Namespaces {
+	n := new(Namespaces)
+	n.defaultStorage = defaultStorage
+	n.storages = map[string]*Storage{DEFAULT_NAMESPACE: defaultStorage}
+	n.maxNum = maxNum
+	return n
+}
+
+// returns storage of namespace, creating and running it if needed. Empty name means default namespace
+func (n *Namespaces) get(name string) (*Storage, error) {
+	if name == `` {
+		return n.defaultStorage, nil
+	}
+	n.lock.RLock()
+	s, ok := n.storages[name]
+	n.lock.RUnlock()
+	if ok {
+		return s, nil
+	}
+	if err := validateNamespaceName(name); err != nil {
+		return nil, err
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if s, ok := n.storages[name]; ok {
+		return s, nil
+	}
+	if len(n.storages) >= n.maxNum {
+		return nil, errors.New("Namespaces limit "+strconv.Itoa(n.maxNum)+" is reached")
+	}
+	// new namespace is configured as default one, so it gets the same share of memory limit
+	s = newStorage(n.defaultStorage.bucketsNum, n.defaultStorage.pubsub)
+	s.setMemoryLimit(n.defaultStorage.maxMemory, n.defaultStorage.evictionPolicy)
+	s.run()
+	n.storages[name] = s
+	return s, nil
+}
+
+// returns storage of existing namespace without creating it
+func (n *Namespaces) find(name string) (*Storage, bool) {
+	if name == `` {
+		return n.defaultStorage, true
+	}
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	s, ok := n.storages[name]
+	return s, ok
+}
+
+// returns storages of all existing namespaces by their names
+func (n *Namespaces) all() map[string]*Storage {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	storages := make(map[string]*Storage, len(n.storages))
+	for name, s := range n.storages {
+		storages[name] = s
+	}
+	return storages
+}
+
+// namespace name is used in urls and persisted files, so it consists of latin letters, digits, '_', '-' and '.'
+func validateNamespaceName(name string) error {
+	if name == `.` || name == `..` {
+		return errors.New("Namespace name '"+name+"' is not allowed")
+	}
+	if len(name) > NAMESPACE_NAME_MAX_LEN {
+		return errors.New("Namespace name is longer than "+strconv.Itoa(NAMESPACE_NAME_MAX_LEN))
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-' || c == '.') {
+			return errors.New("Namespace name '"+name+"' contains not allowed character")
+		}
+	}
+	return nil
+}
+
+// clears storages of all namespaces one by one
+func (n *Namespaces) clear() {
+	for _, s := range n.all() {
+		s.clear()
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNamespaces_Get(t *testing.T) {
+	def := NewStorage(1)
+	def.setMemoryLimit(1000, EVICTION_ALLKEYS_LRU)
+	def.run()
+	defer def.stop()
+	n := NewNamespaces(def, 2)
+	if s, err := n.get(``); err != nil || s != def {
+		t.Errorf("Default storage expected for empty name, got %p, error %v", s, err)
+	}
+	if s, err := n.get(DEFAULT_NAMESPACE); err != nil || s != def {
+		t.Errorf("Default storage expected for default name, got %p, error %v", s, err)
+	}
+	other, err := n.get(`other`)
+	if err != nil || other == def {
+		t.Fatalf("New storage expected for namespace, got %p, error %v", other, err)
+	}
+	defer other.stop()
+	if s, _ := n.get(`other`); s != other {
+		t.Errorf("The same storage expected for namespace on the second access")
+	}
+	if other.pubsub != def.pubsub {
+		t.Errorf("Pub/sub expected to be shared by namespaces")
+	}
+	if other.maxMemory != def.maxMemory || other.evictionPolicy != def.evictionPolicy {
+		t.Errorf("Namespace expected to get memory limit share of default one, got %d", other.maxMemory)
+	}
+	if _, err := n.get(`third`); err == nil || err.Error() != `Namespaces limit 2 is reached` {
+		t.Errorf("Namespaces limit error expected, got %v", err)
+	}
+	for _, name := range []string{`a/b`, `..`, `name with spaces`} {
+		if _, err := n.get(name); err == nil {
+			t.Errorf("Error expected for namespace name '%s'", name)
+		}
+	}
+	if len(n.all()) != 2 {
+		t.Errorf("Two namespaces expected, got %v", n.all())
+	}
+}
+
+func TestNamespaces_Isolation(t *testing.T) {
+	def := NewStorage(1)
+	def.run()
+	defer def.stop()
+	n := NewNamespaces(def, 4)
+	other, _ := n.get(`other`)
+	defer other.stop()
+	k := `test key`
+	def.testOperation(t, operation{op:OP_SET, key:k, val:`default`, ttl:100})
+	def.testOperation(t, operation{op:OP_SET, key:`another key`, val:`default`})
+	other.testOperation(t, operation{op:OP_GET, key:k, expectedErr:`Object not found for key 'test key'`})
+	other.testOperation(t, operation{op:OP_LSET, key:k, val:[]string{`other`}})
+	def.testOperation(t, operation{op:OP_GET, key:k, expectedValue:`default`})
+	other.testOperation(t, operation{op:OP_LGET, key:k, expectedValue:[]string{`other`}})
+	def.testOperation(t, operation{op:OP_DBSIZE, expectedValue:2})
+	other.testOperation(t, operation{op:OP_DBSIZE, expectedValue:1})
+	v, err := other.doRequest(other.newInnerRequest(OP_SCAN, ``, ``, &scanQuery{cursor: SCAN_CURSOR_START, count: 10}, 0))
+	if err != nil || !testListEq(v.([]string), []string{SCAN_CURSOR_START, k}) {
+		t.Errorf("Only namespace key expected to be scanned, got %v, error %v", v, err)
+	}
+}
@@ -5,14 +5,18 @@ import (
 	"log"
 	"os"
 	"encoding/gob"
+	"encoding/json"
 	"bytes"
 	"fmt"
 	"time"
 	"io"
+	"errors"
 )
 
 type Persister struct {
 	memStorage *Storage
+	// if set, storages of all namespaces are persisted, memStorage is the default one
+	namespaces *Namespaces
 	dir string
 	process *os.Process
 }
@@ -20,7 +24,135 @@ type Persister struct {
 type storedItem struct {
 	K string
 	V interface{}
+	// unix time in seconds, when item expires. Is kept for snapshots made before millisecond precision
 	E int64
+	// unix time in milliseconds, when item expires
+	EM int64
+	// unix time in milliseconds, when dict fields or list items expire
+	F map[string]int64
+	// namespace of item, empty for snapshots made before namespaces
+	N string
+}
+
+// sets are stored as list of members, as gob can not encode empty structs
+type storedSet []string
+
+// sorted sets are stored as scores of members
+type storedZSet map[string]float64
+
+// streams are stored with entry ids formatted as strings, as gob does not encode unexported fields
+type storedStream struct {
+	Entries []storedStreamEntry
+	LastId  string
+	Groups  map[string]storedStreamGroup
+}
+
+type storedStreamEntry struct {
+	Id     string
+	Fields map[string]string
+}
+
+type storedStreamGroup struct {
+	LastDelivered string
+	Pending       []storedPendingEntry
+}
+
+type storedPendingEntry struct {
+	Id          string
+	Consumer    string
+	DeliveredAt int64
+	Deliveries  int
+}
+
+// json documents are stored encoded, as gob does not encode numbers kept as json.Number in interfaces
+type storedJSON []byte
+
+func init() {
+	gob.Register(map[string]string{})
+	gob.Register(storedSet{})
+	gob.Register(storedZSet{})
+	gob.Register(storedStream{})
+	gob.Register(storedJSON{})
+}
+
+func toStoredValue(v interface{}) interface{} {
+	if set, ok := v.(map[string]struct{}); ok {
+		members := make(storedSet, 0, len(set))
+		for member := range set {
+			members = append(members, member)
+		}
+		return members
+	}
+	if z, ok := v.(*zset); ok {
+		scores := make(storedZSet, z.len())
+		for member, score := range z.scores {
+			scores[member] = score
+		}
+		return scores
+	}
+	if st, ok := v.(*stream); ok {
+		stored := storedStream{
+			Entries: make([]storedStreamEntry, 0, len(st.entries)),
+			LastId: st.lastId.String(),
+			Groups: make(map[string]storedStreamGroup, len(st.groups)),
+		}
+		for _, e := range st.entries {
+			stored.Entries = append(stored.Entries, storedStreamEntry{e.id.String(), e.fields})
+		}
+		for name, g := range st.groups {
+			group := storedStreamGroup{g.lastDelivered.String(), make([]storedPendingEntry, 0, len(g.pending))}
+			for _, p := range g.pending {
+				group.Pending = append(group.Pending, storedPendingEntry{p.id.String(), p.consumer, p.deliveredAt, p.deliveries})
+			}
+			stored.Groups[name] = group
+		}
+		return stored
+	}
+	if doc, ok := v.(*jsonDoc); ok {
+		data, _ := json.Marshal(doc.root)
+		return storedJSON(data)
+	}
+	return v
+}
+
+func fromStoredValue(v interface{}) interface{} {
+	if members, ok := v.(storedSet); ok {
+		set := make(map[string]struct{}, len(members))
+		for _, member := range members {
+			set[member] = struct{}{}
+		}
+		return set
+	}
+	if scores, ok := v.(storedZSet); ok {
+		z := newZSet()
+		for member, score := range scores {
+			z.add(member, score)
+		}
+		return z
+	}
+	if stored, ok := v.(storedStream); ok {
+		st := newStream()
+		st.lastId, _ = parseStreamId(stored.LastId)
+		for _, e := range stored.Entries {
+			id, _ := parseStreamId(e.Id)
+			st.entries = append(st.entries, streamEntry{id, e.Fields})
+		}
+		for name, group := range stored.Groups {
+			g := &streamGroup{pending: make(map[streamId]*pendingEntry, len(group.Pending))}
+			g.lastDelivered, _ = parseStreamId(group.LastDelivered)
+			for _, p := range group.Pending {
+				id, _ := parseStreamId(p.Id)
+				g.pending[id] = &pendingEntry{id, p.Consumer, p.DeliveredAt, p.Deliveries}
+			}
+			st.groups[name] = g
+		}
+		return st
+	}
+	if data, ok := v.(storedJSON); ok {
+		root, _ := decodeJson(data)
+		return &jsonDoc{root}
+	}
+	return v
 }
 
 func (p *Persister) restore(filePath string) error {
@@ -30,11 +162,8 @@ func (p *Persister) restore(filePath string) error {
 
 	buf := new(bytes.Buffer)
 	dec := gob.NewDecoder(buf)
-	s := p.memStorage
 	cnt := 0
 
-	// first read ttl data
-	var item storedItem
 	for {
 		buf.Reset()
 		_, err := readSizedData(f, buf)
@@ -42,11 +171,30 @@ func (p *Persister) restore(filePath string) error {
 		if err != nil {
 			return err
 		}
-		dec.Decode(&item)
-		ttl := item.E-time.Now().Unix()
-		if item.E == 0 || ttl > 0 {
+		// gob does not transmit zero fields, so item must be new for each record
+		var item storedItem
+		if err := dec.Decode(&item); err != nil { return err }
+		expireAt := item.EM
+		if expireAt == 0 {
+			expireAt = item.E*1000
+		}
+		ttl := expireAt-nowMillis()
+		if expireAt == 0 || ttl > 0 {
+			s, err := p.storage(item.N)
+			if err != nil {
+				log.Printf("Failed to restore item '%s': %v", item.K, err)
+				continue
+			}
+			req := s.newInnerRequest(OP_RESTORE, item.K, ``, fromStoredValue(item.V), 0)
+			if expireAt > 0 {
+				req.ttl = ttl
+			}
+			req.fieldExpireAt = item.F
+			if _, err := s.doRequest(req); err != nil {
+				log.Printf("Failed to restore item '%s': %v", item.K, err)
+				continue
+			}
 			cnt++
-			s.processInnerRequest(s.newInnerRequest(OP_SET, item.K, ``, item.V, ttl))
 		}
 	}
 	log.Printf("Restored %d items from file %s", cnt, filePath)
@@ -61,27 +209,46 @@ func (p *Persister) persist() error {
 
 	buf := new(bytes.Buffer)
 	enc := gob.NewEncoder(buf)
-	s := p.memStorage
 	cnt := 0
 
-	for _, m := range s.keyMetaMap {
-		bnum := m.hash%uint32(s.bucketsNum)
-		item := storedItem{
-			K: m.key,
-			V: s.buckets[bnum].data[m.key],
-			E: s.ttlMonitor.keyExpireAtMap[m],
+	storages := map[string]*Storage{DEFAULT_NAMESPACE: p.memStorage}
+	if p.namespaces != nil {
+		storages = p.namespaces.all()
+	}
+	for namespace, s := range storages {
+		for _, m := range s.keyMetaMap {
+			bnum := m.hash%uint32(s.bucketsNum)
+			item := storedItem{
+				K: m.key,
+				V: toStoredValue(s.buckets[bnum].data[m.key]),
+				E: m.expireAt/1000,
+				EM: m.expireAt,
+				F: m.fieldExpireAt,
+				N: namespace,
+			}
+			buf.Reset()
+			if err := enc.Encode(item); err != nil { return err }
+			n, err := writeSizedData(f, buf.Bytes())
+			if err != nil { return err }
+			cnt += n
 		}
-		buf.Reset()
-		enc.Encode(item)
-		n, err := writeSizedData(f, buf.Bytes())
-		if err != nil { return err }
-		cnt += n
 	}
 	f.Close()
 	log.Printf("Written %d bytes", cnt)
 	return nil
 }
 
+// returns storage, to which item of namespace is restored
+func (p *Persister) storage(namespace string) (*Storage, error) {
+	if namespace == `` || namespace == DEFAULT_NAMESPACE {
+		return p.memStorage, nil
+	}
+	if p.namespaces == nil {
+		return nil, errors.New("Namespaces are not supported")
+	}
+	return p.namespaces.get(namespace)
+}
+
 func (p *Persister) forkPersist() {
 	if p.process != nil {
 		log.Printf("ERROR: Can not start persistency child process - previous one (pid %d) did not finish yet.", p.process.Pid)
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"encoding/json"
+)
+
+func TestPersister_PersistRestore(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `alaredis-persister`)
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := *NewStorage(2)
+	s.run()
+	s.testOperation(t, operation{op:OP_SET, key:`string`, val:`value`})
+	s.testOperation(t, operation{op:OP_LSET, key:`list`, val:[]string{`a`, `b`}})
+	s.testOperation(t, operation{op:OP_DSET, key:`dict`, val:map[string]string{`k`:`v`}})
+	s.testOperation(t, operation{op:OP_DSETI, key:`dict`, idx:`tmp`, val:`v`, ttl:100})
+	s.testOperation(t, operation{op:OP_SADD, key:`set`, val:[]string{`a`, `b`}, expectedValue:2})
+	s.testOperation(t, operation{op:OP_ZADD, key:`zset`, val:map[string]string{`a`:`2`, `b`:`1`}, expectedValue:2})
+	s.testOperation(t, operation{op:OP_XADD, key:`stream`, idx:`1-1`, val:map[string]string{`f`:`v`}, expectedValue:`1-1`})
+	s.testOperation(t, operation{op:OP_XADD, key:`stream`, idx:`1-2`, val:map[string]string{`f`:`v`}, expectedValue:`1-2`})
+	s.testOperation(t, operation{op:OP_XGROUPCREATE, key:`stream`, idx:`g`, args:[]string{`0`}})
+	s.testOperation(t, operation{op:OP_XREADGROUP, key:`stream`, idx:`g`, args:[]string{`c`, `1`}, expectedValue:[]string{`1-1`}})
+	s.testOperation(t, operation{op:OP_BSET, key:`bytes`, val:[]byte{0, 0xff}})
+	s.testOperation(t, operation{op:OP_JSET, key:`json`, val:json.RawMessage(`{"a":[1,"b",null],"n":12345678901234567890}`)})
+	namespaces := NewNamespaces(&s, 2)
+	other, _ := namespaces.get(`other`)
+	other.testOperation(t, operation{op:OP_SET, key:`string`, val:`other value`})
+	p := &Persister{memStorage: &s, namespaces: namespaces, dir: dir}
+	if err := p.persist(); err != nil {
+		t.Fatalf("Failed to persist data: %v", err)
+	}
+	s.stop()
+	other.stop()
+
+	files, _ := filepath.Glob(dir+"/*.gob")
+	if len(files) != 1 {
+		t.Fatalf("Expected one persisted file, got %v", files)
+	}
+	restored := *NewStorage(2)
+	restored.run()
+	restoredNamespaces := NewNamespaces(&restored, 2)
+	p = &Persister{memStorage: &restored, namespaces: restoredNamespaces, dir: dir}
+	if err := p.restore(files[0]); err != nil {
+		t.Fatalf("Failed to restore data: %v", err)
+	}
+	restored.testOperation(t, operation{op:OP_GET, key:`string`, expectedValue:`value`})
+	restored.testOperation(t, operation{op:OP_LGET, key:`list`, expectedValue:[]string{`a`, `b`}})
+	restored.testOperation(t, operation{op:OP_DGET, key:`dict`, expectedValue:map[string]string{`k`:`v`, `tmp`:`v`}})
+	restored.testOperation(t, operation{op:OP_TTL, key:`dict`, idx:`tmp`, expectedValue:100})
+	restored.testOperation(t, operation{op:OP_SMEMBERS, key:`set`, expectedValue:[]string{`a`, `b`}})
+	restored.testOperation(t, operation{op:OP_ZRANGE, key:`zset`, idx:`0`, args:[]string{`-1`}, expectedValue:[]string{`b`, `a`}})
+	restored.testOperation(t, operation{op:OP_XRANGE, key:`stream`, idx:`-`, args:[]string{`+`}, expectedValue:[]string{`1-1`, `1-2`}})
+	restored.testOperation(t, operation{op:OP_XPENDING, key:`stream`, idx:`g`, expectedValue:[]string{`1-1`}})
+	restored.testOperation(t, operation{op:OP_XREADGROUP, key:`stream`, idx:`g`, args:[]string{`c`}, expectedValue:[]string{`1-2`}})
+	restored.testOperation(t, operation{op:OP_XADD, key:`stream`, idx:`1-2`, val:map[string]string{`f`:`v`}, expectedErr:`BadRequest: Entry id must be greater than 1-2`})
+	restored.testOperation(t, operation{op:OP_BGET, key:`bytes`, expectedValue:[]byte{0, 0xff}})
+	restored.testOperation(t, operation{op:OP_JGET, key:`json`, expectedValue:`{"a":[1,"b",null],"n":12345678901234567890}`})
+	restoredOther, _ := restoredNamespaces.get(`other`)
+	restoredOther.testOperation(t, operation{op:OP_GET, key:`string`, expectedValue:`other value`})
+	restoredOther.testOperation(t, operation{op:OP_DBSIZE, expectedValue:1})
+	restoredOther.stop()
+	restored.stop()
+}
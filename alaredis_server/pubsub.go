@@ -0,0 +1,138 @@
+package main
+
+import (
+	"path"
+	"sync"
+	"sync/atomic"
+)
+
+/**
+ * Publish/subscribe channels. They are not stored in buckets, so messages are not kept anywhere
+ * and are received only by subscribers, listening at publishing time
+ */
+
+// count of messages, buffered for each subscriber. Messages are dropped, while subscriber buffer is full
+const PUBSUB_BUFFER_SIZE = 256
+
+type pubSubMessage struct {
+	Channel string `json:"channel"`
+	// pattern, which matched channel, set for pattern subscriptions only
+	Pattern string `json:"pattern,omitempty"`
+	Message string `json:"message"`
+}
+
+type pubSubscriber struct {
+	channels []string
+	// glob patterns for channels
+	patterns []string
+	messages chan *pubSubMessage
+	// count of messages dropped since the last read
+	dropped  uint64
+	// set when subscriber is unsubscribed, guarded by pubSub lock
+	closed   bool
+}
+
+type pubSub struct {
+	// subscribers of exact channels
+	channels map[string]map[*pubSubscriber]struct{}
+	// subscribers having pattern subscriptions
+	patternSubscribers map[*pubSubscriber]struct{}
+	lock     sync.RWMutex
+}
+
+func newPubSub() *pubSub {
+	ps := new(pubSub)
+	ps.channels = make(map[string]map[*pubSubscriber]struct{})
+	ps.patternSubscribers = make(map[*pubSubscriber]struct{})
+	return ps
+}
+
+func (ps *pubSub) subscribe(channels []string, patterns []string) *pubSubscriber {
+	sub := &pubSubscriber{channels: channels, patterns: patterns, messages: make(chan *pubSubMessage, PUBSUB_BUFFER_SIZE)}
+	ps.lock.Lock()
+	for _, ch := range channels {
+		subs, ok := ps.channels[ch]
+		if !ok {
+			subs = make(map[*pubSubscriber]struct{})
+			ps.channels[ch] = subs
+		}
+		subs[sub] = struct{}{}
+	}
+	if len(patterns) > 0 {
+		ps.patternSubscribers[sub] = struct{}{}
+	}
+	ps.lock.Unlock()
+	return sub
+}
+
+// removes all subscriptions of subscriber and closes its messages channel
+func (ps *pubSub) unsubscribe(sub *pubSubscriber) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	if sub.closed {
+		return
+	}
+	for _, ch := range sub.channels {
+		delete(ps.channels[ch], sub)
+		if len(ps.channels[ch]) == 0 {
+			delete(ps.channels, ch)
+		}
+	}
+	delete(ps.patternSubscribers, sub)
+	close(sub.messages)
+	sub.closed = true
+}
+
+// unsubscribes everybody, so their messages channels are closed
+func (ps *pubSub) close() {
+	ps.lock.Lock()
+	subs := make(map[*pubSubscriber]struct{})
+	for _, chSubs := range ps.channels {
+		for sub := range chSubs {
+			subs[sub] = struct{}{}
+		}
+	}
+	for sub := range ps.patternSubscribers {
+		subs[sub] = struct{}{}
+	}
+	ps.lock.Unlock()
+	for sub := range subs {
+		ps.unsubscribe(sub)
+	}
+}
+
+// sends message to subscribers of channel without blocking, returns count of subscribers, which received it.
+// Subscriber receives message once for each matching subscription
+func (ps *pubSub) publish(channel string, message string) int {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+	cnt := 0
+	for sub := range ps.channels[channel] {
+		if sub.send(&pubSubMessage{Channel: channel, Message: message}) {
+			cnt++
+		}
+	}
+	for sub := range ps.patternSubscribers {
+		for _, p := range sub.patterns {
+			if ok, _ := path.Match(p, channel); ok && sub.send(&pubSubMessage{channel, p, message}) {
+				cnt++
+			}
+		}
+	}
+	return cnt
+}
+
+func (sub *pubSubscriber) send(msg *pubSubMessage) bool {
+	select {
+	case sub.messages <- msg:
+		return true
+	default:
+		atomic.AddUint64(&sub.dropped, 1)
+		return false
+	}
+}
+
+// returns count of dropped messages and resets it
+func (sub *pubSubscriber) takeDropped() uint64 {
+	return atomic.SwapUint64(&sub.dropped, 0)
+}
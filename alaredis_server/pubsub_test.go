@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPubSub_Publish(t *testing.T) {
+	ps := newPubSub()
+	exact := ps.subscribe([]string{`news`}, nil)
+	pattern := ps.subscribe(nil, []string{`news*`, `*`})
+
+	if cnt := ps.publish(`news`, `hello`); cnt != 3 {
+		t.Errorf("Expected 3 receivers, got %d", cnt)
+	}
+	if msg := <-exact.messages; *msg != (pubSubMessage{`news`, ``, `hello`}) {
+		t.Errorf("Wrong message %+v", *msg)
+	}
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		msg := <-pattern.messages
+		got[msg.Pattern] = msg.Message == `hello`
+	}
+	if !got[`news*`] || !got[`*`] {
+		t.Errorf("Pattern messages were not received: %v", got)
+	}
+	if cnt := ps.publish(`weather`, `rain`); cnt != 1 {
+		t.Errorf("Expected 1 receiver, got %d", cnt)
+	}
+	<-pattern.messages
+
+	// messages are dropped, while subscriber buffer is full
+	for i := 0; i < PUBSUB_BUFFER_SIZE+5; i++ {
+		ps.publish(`news`, `spam`)
+	}
+	if dropped := exact.takeDropped(); dropped != 5 {
+		t.Errorf("Expected 5 dropped messages, got %d", dropped)
+	}
+
+	ps.unsubscribe(exact)
+	if len(ps.channels) != 0 {
+		t.Errorf("Channel subscribers were not removed: %v", ps.channels)
+	}
+	ps.close()
+	if len(ps.patternSubscribers) != 0 {
+		t.Errorf("Pattern subscribers were not removed: %v", ps.patternSubscribers)
+	}
+	if cnt := ps.publish(`news`, `hello`); cnt != 0 {
+		t.Errorf("Expected no receivers, got %d", cnt)
+	}
+}
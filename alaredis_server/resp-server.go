@@ -0,0 +1,1237 @@
+package main
+
+import (
+	"net"
+	"bufio"
+	"io"
+	"strings"
+	"strconv"
+	"errors"
+	"log"
+	"fmt"
+	"context"
+	"bytes"
+	"sort"
+	"encoding/json"
+)
+
+// limits of incoming command, lengths are checked before buffers are allocated
+const (
+	RESP_MAX_MULTIBULK_LEN = 1024*1024
+	RESP_MAX_BULK_LEN      = 512*1024*1024
+	RESP_MAX_INLINE_LEN    = 64*1024
+	// count of commands read ahead of the performed one
+	RESP_READ_AHEAD        = 64
+)
+
+// RespServer serves storage over RESP2 (redis protocol), so redis-cli and redis client libraries
+// can work with the same buckets, as http server does
+type RespServer struct {
+	storage  *Storage
+	listener net.Listener
+	closed   bool
+	// storages of namespaces, selected by SELECT command for connection
+	namespaces *Namespaces
+	// context of served connection, it is cancelled when client disconnects, so blocking commands stop waiting
+	ctx context.Context
+}
+
+// command read from connection or reading error
+type respIncoming struct {
+	args []string
+	err  error
+}
+
+type respCommand struct {
+	minArgs int
+	handler func(srv *RespServer, args []string) (interface{}, error)
+}
+
+// simple string reply, like +OK
+type respStatus string
+
+var respOK = respStatus(`OK`)
+
+type respError struct {
+	msg string
+}
+
+func (e *respError) Error() string {
+	return e.msg
+}
+
+// commands are built once for all servers, handler gets server of connection
+var respCommands map[string]respCommand
+
+func init() {
+	respCommands = map[string]respCommand{
+		`PING`:          {0, (*RespServer).ping},
+		`ECHO`:          {1, (*RespServer).echo},
+		`COMMAND`:       {0, (*RespServer).command},
+		`GET`:           {1, (*RespServer).get},
+		`SET`:           {2, (*RespServer).set},
+		`DEL`:           {1, (*RespServer).del},
+		`MGET`:          {1, (*RespServer).mget},
+		`MSET`:          {2, (*RespServer).mset},
+		`SCAN`:          {1, (*RespServer).scan},
+		`DBSIZE`:        {0, (*RespServer).dbsize},
+		`INCR`:          {1, (*RespServer).incr},
+		`DECR`:          {1, (*RespServer).decr},
+		`INCRBY`:        {2, (*RespServer).incrby},
+		`DECRBY`:        {2, (*RespServer).decrby},
+		`INCRBYFLOAT`:   {2, (*RespServer).incrbyfloat},
+		`EXPIRE`:        {2, (*RespServer).expire},
+		`EXPIREAT`:      {2, (*RespServer).expireat},
+		`TTL`:           {1, (*RespServer).ttl},
+		`PTTL`:          {1, (*RespServer).pttl},
+		`PEXPIRE`:       {2, (*RespServer).pexpire},
+		`PERSIST`:       {1, (*RespServer).persist},
+		`PUBLISH`:       {2, (*RespServer).publish},
+		`LPUSH`:         {2, (*RespServer).lpush},
+		`RPUSH`:         {2, (*RespServer).rpush},
+		`LPOP`:          {1, (*RespServer).lpop},
+		`RPOP`:          {1, (*RespServer).rpop},
+		`BLPOP`:         {2, (*RespServer).blpop},
+		`BRPOP`:         {2, (*RespServer).brpop},
+		`LLEN`:          {1, (*RespServer).llen},
+		`LRANGE`:        {3, (*RespServer).lrange},
+		`LTRIM`:         {3, (*RespServer).ltrim},
+		`LINSERT`:       {4, (*RespServer).linsert},
+		`LREM`:          {3, (*RespServer).lrem},
+		`LPOS`:          {2, (*RespServer).lpos},
+		`LSET`:          {3, (*RespServer).lset},
+		`LINDEX`:        {2, (*RespServer).lindex},
+		`HSET`:          {3, (*RespServer).hset},
+		`HGET`:          {2, (*RespServer).hget},
+		`HGETALL`:       {1, (*RespServer).hgetall},
+		`HKEYS`:         {1, (*RespServer).hkeys},
+		`HDEL`:          {2, (*RespServer).hdel},
+		`HLEN`:          {1, (*RespServer).hlen},
+		`HEXISTS`:       {2, (*RespServer).hexists},
+		`HVALS`:         {1, (*RespServer).hvals},
+		`HMGET`:         {2, (*RespServer).hmget},
+		`SADD`:          {2, (*RespServer).sadd},
+		`SREM`:          {2, (*RespServer).srem},
+		`SMEMBERS`:      {1, (*RespServer).smembers},
+		`SISMEMBER`:     {2, (*RespServer).sismember},
+		`SCARD`:         {1, (*RespServer).scard},
+		`SPOP`:          {1, (*RespServer).spop},
+		`SINTER`:        {1, (*RespServer).sinter},
+		`SUNION`:        {1, (*RespServer).sunion},
+		`SDIFF`:         {1, (*RespServer).sdiff},
+		`ZADD`:          {3, (*RespServer).zadd},
+		`ZINCRBY`:       {3, (*RespServer).zincrby},
+		`ZREM`:          {2, (*RespServer).zrem},
+		`ZSCORE`:        {2, (*RespServer).zscore},
+		`ZRANK`:         {2, (*RespServer).zrank},
+		`ZCARD`:         {1, (*RespServer).zcard},
+		`ZRANGE`:        {3, (*RespServer).zrange},
+		`ZRANGEBYSCORE`: {3, (*RespServer).zrangebyscore},
+		`XADD`:          {4, (*RespServer).xadd},
+		`XRANGE`:        {3, (*RespServer).xrange},
+		`XREVRANGE`:     {3, (*RespServer).xrevrange},
+		`XLEN`:          {1, (*RespServer).xlen},
+		`XTRIM`:         {3, (*RespServer).xtrim},
+		`XGROUP`:        {4, (*RespServer).xgroup},
+		`XREADGROUP`:    {6, (*RespServer).xreadgroup},
+		`XACK`:          {3, (*RespServer).xack},
+		`XPENDING`:      {2, (*RespServer).xpending},
+		`JSON.SET`:      {3, (*RespServer).jsonSet},
+		`JSON.GET`:      {1, (*RespServer).jsonGet},
+		`JSON.DEL`:      {1, (*RespServer).jsonDel},
+		`JSON.ARRAPPEND`: {3, (*RespServer).jsonArrAppend},
+		`JSON.NUMINCRBY`: {3, (*RespServer).jsonNumIncrBy},
+	}
+}
+
+func NewRespServer(storage *Storage) *RespServer {
+	srv := new(RespServer)
+	srv.storage = storage
+	srv.ctx = context.Background()
+	return srv
+}
+
+func (srv *RespServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil { return err }
+	srv.listener = l
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if srv.closed { return nil }
+			return err
+		}
+		go srv.serveConn(conn)
+	}
+}
+
+// stops accepting new connections
+func (srv *RespServer) Close() {
+	srv.closed = true
+	if srv.listener != nil {
+		srv.listener.Close()
+	}
+}
+
+func (srv *RespServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	defer close(done)
+	// commands are read ahead, so disconnect is noticed while blocking command waits
+	incoming := make(chan respIncoming, RESP_READ_AHEAD)
+	go readRespCommands(bufio.NewReader(conn), incoming, cancel, done)
+	w := bufio.NewWriter(conn)
+	nsSrv := srv.connServer(ctx, srv.storage)
+	for in := range incoming {
+		if in.err == io.EOF { return }
+		if in.err != nil {
+			writeRespValue(w, &respError{"ERR Protocol error: " + in.err.Error()})
+			w.Flush()
+			return
+		}
+		args := in.args
+		if len(args) == 0 { continue }
+		name := strings.ToUpper(args[0])
+		if name == `QUIT` {
+			writeRespValue(w, respOK)
+			w.Flush()
+			return
+		}
+		if name == `SELECT` {
+			// the rest commands of connection are performed on selected namespace
+			selected, err := nsSrv.selectNamespace(args[1:])
+			if err == nil {
+				nsSrv = selected
+				writeRespValue(w, respOK)
+			} else {
+				writeRespValue(w, err)
+			}
+		} else {
+			writeRespValue(w, nsSrv.execute(name, args[1:]))
+		}
+		// pipelined commands are answered at once
+		if len(incoming) == 0 {
+			if err := w.Flush(); err != nil { return }
+		}
+	}
+}
+
+// reads commands of connection until reading fails, the failure cancels connection context
+func readRespCommands(r *bufio.Reader, incoming chan respIncoming, cancel context.CancelFunc, done chan struct{}) {
+	defer close(incoming)
+	for {
+		args, err := readRespCommand(r)
+		if err != nil {
+			cancel()
+		}
+		select {
+		case incoming <- respIncoming{args, err}:
+		case <-done:
+			return
+		}
+		if err != nil { return }
+	}
+}
+
+// returns server performing commands of one connection on storage
+func (srv *RespServer) connServer(ctx context.Context, storage *Storage) *RespServer {
+	connSrv := NewRespServer(storage)
+	connSrv.namespaces = srv.namespaces
+	connSrv.ctx = ctx
+	return connSrv
+}
+
+func (srv *RespServer) execute(name string, args []string) interface{} {
+	cmd, ok := respCommands[name]
+	if !ok {
+		return &respError{fmt.Sprintf("ERR unknown command '%s'", name)}
+	}
+	if len(args) < cmd.minArgs {
+		return &respError{fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name))}
+	}
+	val, err := cmd.handler(srv, args)
+	if err != nil {
+		switch err.(type) {
+		case *respError:
+			return err
+		case *OutOfMemory:
+			return &respError{"OOM " + err.Error()}
+		default:
+			return &respError{"ERR " + err.Error()}
+		}
+	}
+	return val
+}
+
+// SELECT namespace, returns server performing commands on namespace storage
+func (srv *RespServer) selectNamespace(args []string) (*RespServer, error) {
+	if len(args) != 1 {
+		return nil, &respError{"ERR wrong number of arguments for 'select' command"}
+	}
+	if srv.namespaces == nil {
+		if args[0] == DEFAULT_NAMESPACE {
+			return srv, nil
+		}
+		return nil, &respError{"ERR DB index is out of range"}
+	}
+	storage, err := srv.namespaces.get(args[0])
+	if err != nil {
+		return nil, &respError{"ERR "+err.Error()}
+	}
+	return srv.connServer(srv.ctx, storage), nil
+}
+
+func (srv *RespServer) call(op int, key string, idx string, val interface{}, ttl int64) (interface{}, error) {
+	return srv.storage.doRequest(srv.storage.newInnerRequest(op, key, idx, val, ttl))
+}
+
+/**
+ * Commands
+ */
+
+func (srv *RespServer) ping(args []string) (interface{}, error) {
+	if len(args) > 0 {
+		return args[0], nil
+	}
+	return respStatus(`PONG`), nil
+}
+
+func (srv *RespServer) echo(args []string) (interface{}, error) {
+	return args[0], nil
+}
+
+// redis-cli asks for commands docs on start, empty list is enough for it
+func (srv *RespServer) command(args []string) (interface{}, error) {
+	return []string{}, nil
+}
+
+func (srv *RespServer) get(args []string) (interface{}, error) {
+	v, err := srv.call(OP_GET, args[0], ``, nil, 0)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return nil, nil
+	}
+	return v, err
+}
+
+// SET key value [EX seconds|PX milliseconds]
+func (srv *RespServer) set(args []string) (interface{}, error) {
+	// in milliseconds
+	var ttl int64
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); opt {
+		case `EX`, `PX`:
+			if i+1 >= len(args) {
+				return nil, &respError{"ERR syntax error"}
+			}
+			var err error
+			ttl, err = parseRespInt(args[i+1])
+			if err != nil { return nil, err }
+			if ttl <= 0 {
+				return nil, &respError{"ERR invalid expire time in 'set' command"}
+			}
+			if opt == `EX` {
+				ttl *= 1000
+			}
+			i++
+		default:
+			return nil, &respError{"ERR syntax error"}
+		}
+	}
+	req := srv.storage.newInnerRequest(OP_SET, args[0], ``, args[1], 0)
+	req.ttl = ttl
+	if _, err := srv.storage.doRequest(req); err != nil { return nil, err }
+	return respOK, nil
+}
+
+func (srv *RespServer) del(args []string) (interface{}, error) {
+	return srv.storage.doRequest(srv.storage.newInnerRequest(OP_MDEL, ``, ``, args, 0))
+}
+
+func (srv *RespServer) mget(args []string) (interface{}, error) {
+	return srv.storage.doRequest(srv.storage.newInnerRequest(OP_MGET, ``, ``, args, 0))
+}
+
+// MSET key value [key value ...]
+func (srv *RespServer) mset(args []string) (interface{}, error) {
+	if len(args)%2 != 0 {
+		return nil, &respError{"ERR wrong number of arguments for 'mset' command"}
+	}
+	v := make(map[string]string, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		v[args[i]] = args[i+1]
+	}
+	_, err := srv.storage.doRequest(srv.storage.newInnerRequest(OP_MSET, ``, ``, v, 0))
+	if err != nil { return nil, err }
+	return respOK, nil
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+func (srv *RespServer) scan(args []string) (interface{}, error) {
+	q := &scanQuery{cursor: args[0], count: SCAN_DEFAULT_COUNT}
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return nil, &respError{"ERR syntax error"}
+		}
+		switch strings.ToUpper(args[i]) {
+		case `MATCH`:
+			q.match = args[i+1]
+		case `COUNT`:
+			count, err := parseRespInt(args[i+1])
+			if err != nil { return nil, err }
+			q.count = int(count)
+		case `TYPE`:
+			t := strings.ToLower(args[i+1])
+			if t == `hash` {
+				// dicts are hashes for redis clients
+				t = TYPE_NAMES[TYPE_DICT]
+			}
+			for typeId, name := range TYPE_NAMES {
+				if name == t {
+					q.t = typeId
+				}
+			}
+			if q.t == TYPE_NULL {
+				return nil, &respError{"ERR unknown type '"+args[i+1]+"'"}
+			}
+		default:
+			return nil, &respError{"ERR syntax error"}
+		}
+	}
+	v, err := srv.storage.doRequest(srv.storage.newInnerRequest(OP_SCAN, ``, ``, q, 0))
+	if err != nil { return nil, err }
+	res := v.([]string)
+	return []interface{}{res[0], res[1:]}, nil
+}
+
+func (srv *RespServer) dbsize(args []string) (interface{}, error) {
+	return srv.call(OP_DBSIZE, ``, ``, nil, 0)
+}
+
+func (srv *RespServer) incr(args []string) (interface{}, error) {
+	return srv.counter(OP_INCR, args[0], ``)
+}
+
+func (srv *RespServer) decr(args []string) (interface{}, error) {
+	return srv.counter(OP_DECR, args[0], ``)
+}
+
+func (srv *RespServer) incrby(args []string) (interface{}, error) {
+	if _, err := parseRespInt(args[1]); err != nil {
+		return nil, err
+	}
+	return srv.counter(OP_INCRBY, args[0], args[1])
+}
+
+func (srv *RespServer) decrby(args []string) (interface{}, error) {
+	delta, err := parseRespInt(args[1])
+	if err != nil { return nil, err }
+	return srv.counter(OP_INCRBY, args[0], strconv.FormatInt(-delta, 10))
+}
+
+func (srv *RespServer) incrbyfloat(args []string) (interface{}, error) {
+	return srv.call(OP_INCRBYFLOAT, args[0], args[1], nil, 0)
+}
+
+// replies with integer value of counter
+func (srv *RespServer) counter(op int, key string, idx string) (interface{}, error) {
+	v, err := srv.call(op, key, idx, nil, 0)
+	if err != nil { return nil, err }
+	return strconv.ParseInt(v.(string), 10, 64)
+}
+
+func (srv *RespServer) expire(args []string) (interface{}, error) {
+	ttl, err := parseRespInt(args[1])
+	if err != nil { return nil, err }
+	_, err = srv.call(OP_EXPIRE, args[0], ``, nil, ttl)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return 0, nil
+	}
+	if err != nil { return nil, err }
+	return 1, nil
+}
+
+func (srv *RespServer) expireat(args []string) (interface{}, error) {
+	if _, err := parseRespInt(args[1]); err != nil { return nil, err }
+	_, err := srv.call(OP_EXPIREAT, args[0], args[1], nil, 0)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return 0, nil
+	}
+	if err != nil { return nil, err }
+	return 1, nil
+}
+
+func (srv *RespServer) ttl(args []string) (interface{}, error) {
+	return srv.call(OP_TTL, args[0], ``, nil, 0)
+}
+
+func (srv *RespServer) pttl(args []string) (interface{}, error) {
+	return srv.call(OP_PTTL, args[0], ``, nil, 0)
+}
+
+func (srv *RespServer) pexpire(args []string) (interface{}, error) {
+	ttl, err := parseRespInt(args[1])
+	if err != nil { return nil, err }
+	req := srv.storage.newInnerRequest(OP_EXPIRE, args[0], ``, nil, 0)
+	req.ttl = ttl
+	_, err = srv.storage.doRequest(req)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return 0, nil
+	}
+	if err != nil { return nil, err }
+	return 1, nil
+}
+
+func (srv *RespServer) publish(args []string) (interface{}, error) {
+	return srv.call(OP_PUBLISH, args[0], ``, args[1], 0)
+}
+
+func (srv *RespServer) persist(args []string) (interface{}, error) {
+	v, err := srv.call(OP_PERSIST, args[0], ``, nil, 0)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return 0, nil
+	}
+	return v, err
+}
+
+func (srv *RespServer) lpush(args []string) (interface{}, error) {
+	return srv.call(OP_LPUSH, args[0], ``, args[1:], 0)
+}
+
+func (srv *RespServer) rpush(args []string) (interface{}, error) {
+	return srv.call(OP_RPUSH, args[0], ``, args[1:], 0)
+}
+
+func (srv *RespServer) lpop(args []string) (interface{}, error) {
+	return srv.pop(OP_LPOP, args[0])
+}
+
+func (srv *RespServer) rpop(args []string) (interface{}, error) {
+	return srv.pop(OP_RPOP, args[0])
+}
+
+func (srv *RespServer) blpop(args []string) (interface{}, error) {
+	return srv.blockingPop(OP_BLPOP, args)
+}
+
+func (srv *RespServer) brpop(args []string) (interface{}, error) {
+	return srv.blockingPop(OP_BRPOP, args)
+}
+
+// only one key is supported, reply is list with key and popped value
+func (srv *RespServer) blockingPop(op int, args []string) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, &respError{"ERR only one key is supported"}
+	}
+	req := srv.storage.newInnerRequest(op, args[0], args[1], nil, 0)
+	v, err := srv.storage.doBlockingRequest(srv.ctx, req)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return nil, nil
+	}
+	if err != nil { return nil, err }
+	return []string{args[0], v.(string)}, nil
+}
+
+func (srv *RespServer) pop(op int, key string) (interface{}, error) {
+	v, err := srv.call(op, key, ``, nil, 0)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return nil, nil
+	}
+	return v, err
+}
+
+func (srv *RespServer) llen(args []string) (interface{}, error) {
+	v, err := srv.call(OP_LLEN, args[0], ``, nil, 0)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return 0, nil
+	}
+	return v, err
+}
+
+func (srv *RespServer) lrange(args []string) (interface{}, error) {
+	v, err := srv.callRange(OP_LRANGE, args)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return []string{}, nil
+	}
+	return v, err
+}
+
+func (srv *RespServer) ltrim(args []string) (interface{}, error) {
+	_, err := srv.callRange(OP_LTRIM, args)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return respOK, nil
+	}
+	if err != nil { return nil, err }
+	return respOK, nil
+}
+
+// performs operation with <key> <start> <stop> arguments
+func (srv *RespServer) callRange(op int, args []string) (interface{}, error) {
+	for _, a := range args[1:3] {
+		if _, err := parseRespInt(a); err != nil {
+			return nil, err
+		}
+	}
+	req := srv.storage.newInnerRequest(op, args[0], args[1], nil, 0)
+	req.args = args[2:3]
+	return srv.storage.doRequest(req)
+}
+
+// LINSERT key BEFORE|AFTER pivot value
+func (srv *RespServer) linsert(args []string) (interface{}, error) {
+	where := strings.ToLower(args[1])
+	if where != `before` && where != `after` {
+		return nil, &respError{"ERR syntax error"}
+	}
+	req := srv.storage.newInnerRequest(OP_LINSERT, args[0], where, args[3], 0)
+	req.args = args[2:3]
+	v, err := srv.storage.doRequest(req)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return 0, nil
+	}
+	if _, ok := err.(*BadRequest); ok && req.meta.t == TYPE_LIST {
+		// pivot is not found
+		return -1, nil
+	}
+	return v, err
+}
+
+func (srv *RespServer) lrem(args []string) (interface{}, error) {
+	if _, err := parseRespInt(args[1]); err != nil {
+		return nil, err
+	}
+	v, err := srv.call(OP_LREM, args[0], args[1], args[2], 0)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return 0, nil
+	}
+	return v, err
+}
+
+func (srv *RespServer) lpos(args []string) (interface{}, error) {
+	req := srv.storage.newInnerRequest(OP_LPOS, args[0], args[1], nil, 0)
+	v, err := srv.storage.doRequest(req)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return nil, nil
+	}
+	if _, ok := err.(*BadRequest); ok && req.meta.t == TYPE_LIST {
+		// value is not found
+		return nil, nil
+	}
+	return v, err
+}
+
+func (srv *RespServer) lset(args []string) (interface{}, error) {
+	if _, err := parseRespInt(args[1]); err != nil {
+		return nil, err
+	}
+	_, err := srv.call(OP_LSETI, args[0], args[1], args[2], 0)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return nil, &respError{"ERR no such key"}
+	}
+	if err != nil { return nil, err }
+	return respOK, nil
+}
+
+func (srv *RespServer) lindex(args []string) (interface{}, error) {
+	idx, err := parseRespInt(args[1])
+	if err != nil { return nil, err }
+	v, err := srv.call(OP_LGET, args[0], ``, nil, 0)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return nil, nil
+	}
+	if err != nil { return nil, err }
+	list := v.([]string)
+	if idx < 0 { idx += int64(len(list)) }
+	if idx < 0 || idx >= int64(len(list)) {
+		return nil, nil
+	}
+	return list[idx], nil
+}
+
+// HSET key field value [field value ...], fields are set atomically. Replies with number of added fields
+func (srv *RespServer) hset(args []string) (interface{}, error) {
+	if len(args) % 2 == 0 {
+		return nil, &respError{"ERR wrong number of arguments for 'hset' command"}
+	}
+	patch := make(map[string]*string, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		patch[args[i]] = &args[i+1]
+	}
+	return srv.call(OP_DMSET, args[0], ``, patch, 0)
+}
+
+func (srv *RespServer) hget(args []string) (interface{}, error) {
+	req := srv.storage.newInnerRequest(OP_DGETI, args[0], args[1], nil, 0)
+	v, err := srv.storage.doRequest(req)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return nil, nil
+	}
+	if _, ok := err.(*BadRequest); ok && req.meta.t == TYPE_DICT {
+		// field is not found
+		return nil, nil
+	}
+	return v, err
+}
+
+func (srv *RespServer) hgetall(args []string) (interface{}, error) {
+	v, err := srv.call(OP_DGET, args[0], ``, nil, 0)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return []string{}, nil
+	}
+	if err != nil { return nil, err }
+	dict := v.(map[string]string)
+	res := make([]string, 0, len(dict)*2)
+	for k, val := range dict {
+		res = append(res, k, val)
+	}
+	return res, nil
+}
+
+func (srv *RespServer) hkeys(args []string) (interface{}, error) {
+	v, err := srv.call(OP_DKEYS, args[0], ``, nil, 0)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return []string{}, nil
+	}
+	return v, err
+}
+
+// HDEL key field [field ...], fields are removed atomically
+func (srv *RespServer) hdel(args []string) (interface{}, error) {
+	req := srv.storage.newInnerRequest(OP_DDELI, args[0], args[1], nil, 0)
+	req.args = args[2:]
+	return srv.storage.doRequest(req)
+}
+
+func (srv *RespServer) hlen(args []string) (interface{}, error) {
+	v, err := srv.call(OP_DLEN, args[0], ``, nil, 0)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return 0, nil
+	}
+	return v, err
+}
+
+func (srv *RespServer) hexists(args []string) (interface{}, error) {
+	return srv.call(OP_DEXISTS, args[0], args[1], nil, 0)
+}
+
+func (srv *RespServer) hvals(args []string) (interface{}, error) {
+	v, err := srv.call(OP_DVALS, args[0], ``, nil, 0)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return []string{}, nil
+	}
+	return v, err
+}
+
+// replies with array of values in order of fields, missing ones are nil
+func (srv *RespServer) hmget(args []string) (interface{}, error) {
+	fields := args[1:]
+	res := make([]interface{}, len(fields))
+	v, err := srv.call(OP_DMGET, args[0], ``, fields, 0)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return res, nil
+	}
+	if err != nil { return nil, err }
+	dict := v.(map[string]string)
+	for i, f := range fields {
+		if val, ok := dict[f]; ok {
+			res[i] = val
+		}
+	}
+	return res, nil
+}
+
+func (srv *RespServer) sadd(args []string) (interface{}, error) {
+	return srv.call(OP_SADD, args[0], ``, args[1:], 0)
+}
+
+func (srv *RespServer) srem(args []string) (interface{}, error) {
+	v, err := srv.call(OP_SREM, args[0], ``, args[1:], 0)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return 0, nil
+	}
+	return v, err
+}
+
+func (srv *RespServer) smembers(args []string) (interface{}, error) {
+	v, err := srv.call(OP_SMEMBERS, args[0], ``, nil, 0)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return []string{}, nil
+	}
+	return v, err
+}
+
+func (srv *RespServer) sismember(args []string) (interface{}, error) {
+	return srv.call(OP_SISMEMBER, args[0], args[1], nil, 0)
+}
+
+func (srv *RespServer) scard(args []string) (interface{}, error) {
+	v, err := srv.call(OP_SCARD, args[0], ``, nil, 0)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return 0, nil
+	}
+	return v, err
+}
+
+func (srv *RespServer) spop(args []string) (interface{}, error) {
+	v, err := srv.call(OP_SPOP, args[0], ``, nil, 0)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return nil, nil
+	}
+	return v, err
+}
+
+func (srv *RespServer) sinter(args []string) (interface{}, error) {
+	return srv.call(OP_SINTER, args[0], ``, args[1:], 0)
+}
+
+func (srv *RespServer) sunion(args []string) (interface{}, error) {
+	return srv.call(OP_SUNION, args[0], ``, args[1:], 0)
+}
+
+func (srv *RespServer) sdiff(args []string) (interface{}, error) {
+	return srv.call(OP_SDIFF, args[0], ``, args[1:], 0)
+}
+
+// ZADD key score member [score member ...]
+func (srv *RespServer) zadd(args []string) (interface{}, error) {
+	if len(args) % 2 == 0 {
+		return nil, &respError{"ERR syntax error"}
+	}
+	scores := make(map[string]string, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		if _, err := parseScore(args[i]); err != nil {
+			return nil, &respError{"ERR value is not a valid float"}
+		}
+		scores[args[i+1]] = args[i]
+	}
+	return srv.call(OP_ZADD, args[0], ``, scores, 0)
+}
+
+func (srv *RespServer) zincrby(args []string) (interface{}, error) {
+	return srv.call(OP_ZINCRBY, args[0], args[1], args[2], 0)
+}
+
+func (srv *RespServer) zrem(args []string) (interface{}, error) {
+	v, err := srv.call(OP_ZREM, args[0], ``, args[1:], 0)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return 0, nil
+	}
+	return v, err
+}
+
+func (srv *RespServer) zscore(args []string) (interface{}, error) {
+	return srv.zmember(OP_ZSCORE, args[0], args[1])
+}
+
+func (srv *RespServer) zrank(args []string) (interface{}, error) {
+	return srv.zmember(OP_ZRANK, args[0], args[1])
+}
+
+// replies with nil for missing sorted set or member
+func (srv *RespServer) zmember(op int, key string, member string) (interface{}, error) {
+	req := srv.storage.newInnerRequest(op, key, member, nil, 0)
+	v, err := srv.storage.doRequest(req)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return nil, nil
+	}
+	if _, ok := err.(*BadRequest); ok && req.meta.t == TYPE_ZSET {
+		return nil, nil
+	}
+	return v, err
+}
+
+func (srv *RespServer) zcard(args []string) (interface{}, error) {
+	v, err := srv.call(OP_ZCARD, args[0], ``, nil, 0)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return 0, nil
+	}
+	return v, err
+}
+
+func (srv *RespServer) zrange(args []string) (interface{}, error) {
+	v, err := srv.callRange(OP_ZRANGE, args)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return []string{}, nil
+	}
+	return v, err
+}
+
+// ZRANGEBYSCORE key min max [LIMIT offset count]
+func (srv *RespServer) zrangebyscore(args []string) (interface{}, error) {
+	req := srv.storage.newInnerRequest(OP_ZRANGEBYSCORE, args[0], args[1], nil, 0)
+	req.args = args[2:3]
+	if len(args) > 3 {
+		if len(args) != 6 || strings.ToUpper(args[3]) != `LIMIT` {
+			return nil, &respError{"ERR syntax error"}
+		}
+		req.args = append(req.args, args[4:6]...)
+	}
+	v, err := srv.storage.doRequest(req)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return []string{}, nil
+	}
+	return v, err
+}
+
+// XADD key [MAXLEN [=|~] count] id|* field value [field value ...]
+func (srv *RespServer) xadd(args []string) (interface{}, error) {
+	req := srv.storage.newInnerRequest(OP_XADD, args[0], ``, nil, 0)
+	i := 1
+	if strings.ToUpper(args[i]) == `MAXLEN` {
+		maxLen, n, err := parseRespMaxLen(args[i:])
+		if err != nil { return nil, err }
+		req.args = []string{maxLen}
+		i += n
+	}
+	if i >= len(args) || (len(args)-i)%2 == 0 {
+		return nil, &respError{"ERR wrong number of arguments for 'xadd' command"}
+	}
+	req.idx = args[i]
+	fields := make(map[string]string, (len(args)-i)/2)
+	for i++; i < len(args); i += 2 {
+		fields[args[i]] = args[i+1]
+	}
+	req.val = fields
+	return srv.storage.doRequest(req)
+}
+
+// parses MAXLEN [=|~] count arguments, approximate trimming is exact one. Returns count and number of parsed arguments
+func parseRespMaxLen(args []string) (string, int, error) {
+	n := 1
+	if len(args) > 1 && (args[1] == `=` || args[1] == `~`) {
+		n++
+	}
+	if len(args) <= n {
+		return ``, 0, &respError{"ERR syntax error"}
+	}
+	if _, err := parseRespInt(args[n]); err != nil {
+		return ``, 0, err
+	}
+	return args[n], n+1, nil
+}
+
+func (srv *RespServer) xrange(args []string) (interface{}, error) {
+	return srv.streamRange(OP_XRANGE, args)
+}
+
+func (srv *RespServer) xrevrange(args []string) (interface{}, error) {
+	return srv.streamRange(OP_XREVRANGE, args)
+}
+
+// XRANGE key start end [COUNT count], XREVRANGE key end start [COUNT count]
+func (srv *RespServer) streamRange(op int, args []string) (interface{}, error) {
+	req := srv.storage.newInnerRequest(op, args[0], args[1], nil, 0)
+	req.args = args[2:3]
+	if len(args) > 3 {
+		if len(args) != 5 || strings.ToUpper(args[3]) != `COUNT` {
+			return nil, &respError{"ERR syntax error"}
+		}
+		if _, err := parseRespInt(args[4]); err != nil { return nil, err }
+		req.args = append(req.args, args[4])
+	}
+	v, err := srv.storage.doRequest(req)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return []interface{}{}, nil
+	}
+	if err != nil { return nil, err }
+	return respStreamEntries(v.([]streamEntry)), nil
+}
+
+func (srv *RespServer) xlen(args []string) (interface{}, error) {
+	v, err := srv.call(OP_XLEN, args[0], ``, nil, 0)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return 0, nil
+	}
+	return v, err
+}
+
+// XTRIM key MAXLEN [=|~] count
+func (srv *RespServer) xtrim(args []string) (interface{}, error) {
+	if strings.ToUpper(args[1]) != `MAXLEN` {
+		return nil, &respError{"ERR syntax error"}
+	}
+	maxLen, n, err := parseRespMaxLen(args[1:])
+	if err != nil { return nil, err }
+	if n+1 != len(args) {
+		return nil, &respError{"ERR syntax error"}
+	}
+	v, err := srv.call(OP_XTRIM, args[0], maxLen, nil, 0)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return 0, nil
+	}
+	return v, err
+}
+
+// XGROUP CREATE key group id|$ [MKSTREAM], missing stream is always created
+func (srv *RespServer) xgroup(args []string) (interface{}, error) {
+	if strings.ToUpper(args[0]) != `CREATE` {
+		return nil, &respError{"ERR unknown subcommand '"+args[0]+"'"}
+	}
+	if len(args) > 5 || (len(args) == 5 && strings.ToUpper(args[4]) != `MKSTREAM`) {
+		return nil, &respError{"ERR syntax error"}
+	}
+	req := srv.storage.newInnerRequest(OP_XGROUPCREATE, args[1], args[2], nil, 0)
+	req.args = args[3:4]
+	if _, err := srv.storage.doRequest(req); err != nil { return nil, err }
+	return respOK, nil
+}
+
+// XREADGROUP GROUP group consumer [COUNT count] STREAMS key id, only one stream is supported.
+// Replies with nil, if nothing was delivered
+func (srv *RespServer) xreadgroup(args []string) (interface{}, error) {
+	if strings.ToUpper(args[0]) != `GROUP` {
+		return nil, &respError{"ERR syntax error"}
+	}
+	count := `-1`
+	i := 3
+	if strings.ToUpper(args[i]) == `COUNT` {
+		if _, err := parseRespInt(args[i+1]); err != nil { return nil, err }
+		count = args[i+1]
+		i += 2
+	}
+	if i+3 != len(args) || strings.ToUpper(args[i]) != `STREAMS` {
+		return nil, &respError{"ERR only one stream is supported"}
+	}
+	key := args[i+1]
+	req := srv.storage.newInnerRequest(OP_XREADGROUP, key, args[1], nil, 0)
+	req.args = []string{args[2], count, args[i+2]}
+	v, err := srv.storage.doRequest(req)
+	if err != nil { return nil, err }
+	entries := v.([]streamEntry)
+	if len(entries) == 0 && args[i+2] == `>` {
+		return nil, nil
+	}
+	return []interface{}{[]interface{}{key, respStreamEntries(entries)}}, nil
+}
+
+// XACK key group id [id ...]
+func (srv *RespServer) xack(args []string) (interface{}, error) {
+	v, err := srv.call(OP_XACK, args[0], args[1], args[2:], 0)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return 0, nil
+	}
+	return v, err
+}
+
+// XPENDING key group [start end count [consumer]]. Without range summary is replied: count of pending entries,
+// the lowest and the highest ids and counts of entries pending for each consumer
+func (srv *RespServer) xpending(args []string) (interface{}, error) {
+	if len(args) != 2 && len(args) != 5 && len(args) != 6 {
+		return nil, &respError{"ERR syntax error"}
+	}
+	req := srv.storage.newInnerRequest(OP_XPENDING, args[0], args[1], nil, 0)
+	if len(args) == 6 {
+		req.args = args[5:]
+	}
+	v, err := srv.storage.doRequest(req)
+	if err != nil { return nil, err }
+	pending := v.([]pendingEntry)
+	if len(args) == 2 {
+		if len(pending) == 0 {
+			return []interface{}{0, nil, nil, nil}, nil
+		}
+		consumers := make([]string, 0)
+		counts := make(map[string]int)
+		for _, p := range pending {
+			if counts[p.consumer] == 0 {
+				consumers = append(consumers, p.consumer)
+			}
+			counts[p.consumer]++
+		}
+		sort.Strings(consumers)
+		consumersRes := make([]interface{}, 0, len(consumers))
+		for _, c := range consumers {
+			consumersRes = append(consumersRes, []string{c, strconv.Itoa(counts[c])})
+		}
+		return []interface{}{len(pending), pending[0].id.String(), pending[len(pending)-1].id.String(), consumersRes}, nil
+	}
+	start, err := parseStreamBound(args[2], false)
+	if err != nil { return nil, &respError{"ERR Invalid stream ID specified as stream command argument"} }
+	end, err := parseStreamBound(args[3], true)
+	if err != nil { return nil, &respError{"ERR Invalid stream ID specified as stream command argument"} }
+	count, err := parseRespInt(args[4])
+	if err != nil { return nil, err }
+	now := nowMillis()
+	res := make([]interface{}, 0)
+	for _, p := range pending {
+		if int64(len(res)) >= count { break }
+		if p.id.less(start) || end.less(p.id) { continue }
+		res = append(res, []interface{}{p.id.String(), p.consumer, now-p.deliveredAt, p.deliveries})
+	}
+	return res, nil
+}
+
+// JSON.SET key path value
+func (srv *RespServer) jsonSet(args []string) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, &respError{"ERR syntax error"}
+	}
+	_, err := srv.call(OP_JSET, args[0], args[1], json.RawMessage(args[2]), 0)
+	if err != nil { return nil, err }
+	return respOK, nil
+}
+
+// JSON.GET key [path]
+func (srv *RespServer) jsonGet(args []string) (interface{}, error) {
+	path := ``
+	if len(args) > 1 {
+		path = args[1]
+	}
+	v, err := srv.call(OP_JGET, args[0], path, nil, 0)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return nil, nil
+	}
+	if err != nil { return nil, err }
+	return string(v.(json.RawMessage)), nil
+}
+
+// JSON.DEL key [path]
+func (srv *RespServer) jsonDel(args []string) (interface{}, error) {
+	path := ``
+	if len(args) > 1 {
+		path = args[1]
+	}
+	v, err := srv.call(OP_JDEL, args[0], path, nil, 0)
+	if _, ok := err.(*ObjectNotFound); ok {
+		return 0, nil
+	}
+	return v, err
+}
+
+// JSON.ARRAPPEND key path value [value ...]
+func (srv *RespServer) jsonArrAppend(args []string) (interface{}, error) {
+	for _, v := range args[2:] {
+		if !json.Valid([]byte(v)) {
+			return nil, &respError{"ERR wrong json value '"+v+"'"}
+		}
+	}
+	values := json.RawMessage(`[`+strings.Join(args[2:], `,`)+`]`)
+	return srv.call(OP_JARRAPPEND, args[0], args[1], values, 0)
+}
+
+// JSON.NUMINCRBY key path value
+func (srv *RespServer) jsonNumIncrBy(args []string) (interface{}, error) {
+	req := srv.storage.newInnerRequest(OP_JNUMINCRBY, args[0], args[1], nil, 0)
+	req.args = args[2:]
+	v, err := srv.storage.doRequest(req)
+	if err != nil { return nil, err }
+	return string(v.(json.RawMessage)), nil
+}
+
+// each entry is replied as its id and list of fields with values, trimmed entry has nil fields
+func respStreamEntries(entries []streamEntry) []interface{} {
+	res := make([]interface{}, 0, len(entries))
+	for _, e := range entries {
+		if e.fields == nil {
+			res = append(res, []interface{}{e.id.String(), nil})
+			continue
+		}
+		fields := make([]string, 0, len(e.fields))
+		for f := range e.fields {
+			fields = append(fields, f)
+		}
+		// stream keeps fields as dict, so they are sorted to be replied in stable order
+		sort.Strings(fields)
+		values := make([]string, 0, len(fields)*2)
+		for _, f := range fields {
+			values = append(values, f, e.fields[f])
+		}
+		res = append(res, []interface{}{e.id.String(), values})
+	}
+	return res
+}
+
+func parseRespInt(s string) (int64, error) {
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, &respError{"ERR value is not an integer or out of range"}
+	}
+	return i, nil
+}
+
+/**
+ * Protocol
+ */
+
+// reads command either as RESP array of bulk strings or as inline command
+func readRespCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readRespLine(r)
+	if err != nil { return nil, err }
+	if len(line) == 0 || line[0] != '*' {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 || n > RESP_MAX_MULTIBULK_LEN {
+		return nil, errors.New("invalid multibulk length")
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err = readRespLine(r)
+		if err != nil { return nil, err }
+		if len(line) == 0 || line[0] != '$' {
+			return nil, errors.New("expected '$', got '" + line + "'")
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > RESP_MAX_BULK_LEN {
+			return nil, errors.New("invalid bulk length")
+		}
+		// buffer grows while data is read, so declared length does not allocate memory by itself
+		var buf bytes.Buffer
+		if _, err := io.CopyN(&buf, r, int64(size)+2); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf.Bytes()[:size]))
+	}
+	return args, nil
+}
+
+func readRespLine(r *bufio.Reader) (string, error) {
+	line, err := readLimitedLine(r, RESP_MAX_INLINE_LEN)
+	if err != nil { return ``, err }
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func writeRespValue(w *bufio.Writer, val interface{}) {
+	switch v := val.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case respStatus:
+		w.WriteString("+" + string(v) + "\r\n")
+	case error:
+		w.WriteString("-" + v.Error() + "\r\n")
+	case int:
+		w.WriteString(":" + strconv.Itoa(v) + "\r\n")
+	case int64:
+		w.WriteString(":" + strconv.FormatInt(v, 10) + "\r\n")
+	case string:
+		w.WriteString("$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n")
+	case []string:
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, s := range v {
+			writeRespValue(w, s)
+		}
+	case []*string:
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, s := range v {
+			if s == nil {
+				w.WriteString("$-1\r\n")
+			} else {
+				writeRespValue(w, *s)
+			}
+		}
+	case []interface{}:
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, item := range v {
+			writeRespValue(w, item)
+		}
+	case map[string]struct{}:
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for member := range v {
+			writeRespValue(w, member)
+		}
+	default:
+		log.Printf("Unsupported RESP reply type %T", val)
+		w.WriteString("-ERR unsupported reply type\r\n")
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"testing"
+	"net"
+	"bufio"
+	"strings"
+	"strconv"
+	"time"
+)
+
+func TestRespServer_ReadCommand(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$7\r\nbar baz\r\nPING hello\r\n"))
+	args, err := readRespCommand(r)
+	if err != nil || !testListEq(args, []string{`SET`, `foo`, `bar baz`}) {
+		t.Errorf("Wrong multibulk command parsed: %v, error %v", args, err)
+	}
+	args, err = readRespCommand(r)
+	if err != nil || !testListEq(args, []string{`PING`, `hello`}) {
+		t.Errorf("Wrong inline command parsed: %v, error %v", args, err)
+	}
+	for _, cmd := range []string{"*-1\r\n", "*1048577\r\n", "*1\r\n$-1\r\n", "*1\r\n$536870913\r\n", strings.Repeat("a", RESP_MAX_INLINE_LEN+1)} {
+		if args, err := readRespCommand(bufio.NewReader(strings.NewReader(cmd))); err == nil {
+			t.Errorf("Command %q with wrong length is parsed: %v", cmd, args)
+		}
+	}
+}
+
+func TestRespServer_Commands(t *testing.T) {
+	s := NewStorage(2)
+	s.run()
+	defer s.stop()
+	srv := NewRespServer(s)
+	srv.namespaces = NewNamespaces(s, 2)
+	client, server := net.Pipe()
+	defer client.Close()
+	go srv.serveConn(server)
+	r := bufio.NewReader(client)
+
+	cases := []struct {
+		cmd      string
+		expected string
+	}{
+		{"PING\r\n", "+PONG\r\n"},
+		{"*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$3\r\nbar\r\n", "+OK\r\n"},
+		{"GET foo\r\n", "$3\r\nbar\r\n"},
+		{"GET missing\r\n", "$-1\r\n"},
+		{"HSET dict k1 v1 k2 v2\r\n", ":2\r\n"},
+		{"HGET dict k2\r\n", "$2\r\nv2\r\n"},
+		{"HGET dict k3\r\n", "$-1\r\n"},
+		{"HGET missing k1\r\n", "$-1\r\n"},
+		{"HGET foo k1\r\n", "-ERR BadRequest: Stored object is not dict\r\n"},
+		{"HSET dict k2 v3 k3 v3\r\n", ":1\r\n"},
+		{"HLEN dict\r\n", ":3\r\n"},
+		{"HEXISTS dict k1\r\n", ":1\r\n"},
+		{"HDEL dict k1 k3 k4\r\n", ":2\r\n"},
+		{"INCR counter\r\n", ":1\r\n"},
+		{"INCRBY counter 10\r\n", ":11\r\n"},
+		{"DECRBY counter 2\r\n", ":9\r\n"},
+		{"INCRBYFLOAT counter 0.5\r\n", "$3\r\n9.5\r\n"},
+		{"RPUSH list a b c\r\n", ":3\r\n"},
+		{"LPUSH list z\r\n", ":4\r\n"},
+		{"LPOP list\r\n", "$1\r\nz\r\n"},
+		{"LLEN list\r\n", ":3\r\n"},
+		{"LTRIM list 1 -1\r\n", "+OK\r\n"},
+		{"RPOP list\r\n", "$1\r\nc\r\n"},
+		{"LSET list -1 b\r\n", "+OK\r\n"},
+		{"LSET list -5 b\r\n", "-ERR BadRequest: List index out of range\r\n"},
+		{"LINDEX list -1\r\n", "$1\r\nb\r\n"},
+		{"LINSERT list BEFORE b a\r\n", ":2\r\n"},
+		{"LINSERT list AFTER x y\r\n", ":-1\r\n"},
+		{"LPOS list b\r\n", ":1\r\n"},
+		{"BRPOP list 1\r\n", "*2\r\n$4\r\nlist\r\n$1\r\nb\r\n"},
+		{"BLPOP empty 0.05\r\n", "$-1\r\n"},
+		{"RPUSH list b\r\n", ":2\r\n"},
+		{"LPOS list x\r\n", "$-1\r\n"},
+		{"LREM list 0 a\r\n", ":1\r\n"},
+		{"SADD set a b a\r\n", ":2\r\n"},
+		{"SISMEMBER set b\r\n", ":1\r\n"},
+		{"SCARD set\r\n", ":2\r\n"},
+		{"SREM set a c\r\n", ":1\r\n"},
+		{"ZADD zset 2 a 1 b\r\n", ":2\r\n"},
+		{"ZINCRBY zset 2.5 b\r\n", "$3\r\n3.5\r\n"},
+		{"ZRANK zset b\r\n", ":1\r\n"},
+		{"ZSCORE zset x\r\n", "$-1\r\n"},
+		{"XADD stream 1-1 f v\r\n", "$3\r\n1-1\r\n"},
+		{"XADD stream MAXLEN ~ 1 2-1 a 1 b 2\r\n", "$3\r\n2-1\r\n"},
+		{"XLEN stream\r\n", ":1\r\n"},
+		{"XRANGE stream - + COUNT 1\r\n", "*1\r\n*2\r\n$3\r\n2-1\r\n*4\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n"},
+		{"XGROUP CREATE stream g 0\r\n", "+OK\r\n"},
+		{"XREADGROUP GROUP g c STREAMS stream >\r\n", "*1\r\n*2\r\n$6\r\nstream\r\n*1\r\n*2\r\n$3\r\n2-1\r\n*4\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n"},
+		{"XREADGROUP GROUP g c COUNT 1 STREAMS stream >\r\n", "$-1\r\n"},
+		{"XPENDING stream g\r\n", "*4\r\n:1\r\n$3\r\n2-1\r\n$3\r\n2-1\r\n*1\r\n*2\r\n$1\r\nc\r\n$1\r\n1\r\n"},
+		{"XACK stream g 2-1 3-1\r\n", ":1\r\n"},
+		{"XTRIM stream MAXLEN 0\r\n", ":1\r\n"},
+		{"JSON.SET doc $ {\"a\":{\"n\":1,\"l\":[]}}\r\n", "+OK\r\n"},
+		{"JSON.NUMINCRBY doc $.a.n 2\r\n", "$1\r\n3\r\n"},
+		{"JSON.ARRAPPEND doc $.a.l 1 \"x\"\r\n", ":2\r\n"},
+		{"JSON.ARRAPPEND doc $.a.l {\r\n", "-ERR wrong json value '{'\r\n"},
+		{"JSON.GET doc $.a.l[1]\r\n", "$3\r\n\"x\"\r\n"},
+		{"JSON.DEL doc $.a.l\r\n", ":1\r\n"},
+		{"JSON.GET doc\r\n", "$13\r\n{\"a\":{\"n\":3}}\r\n"},
+		{"JSON.DEL doc\r\n", ":1\r\n"},
+		{"JSON.GET doc\r\n", "$-1\r\n"},
+		{"MSET m1 a m2 b\r\n", "+OK\r\n"},
+		{"MGET m1 missing m2\r\n", "*3\r\n$1\r\na\r\n$-1\r\n$1\r\nb\r\n"},
+		{"SCAN 0 MATCH m* TYPE string\r\n", "*2\r\n$1\r\n0\r\n*2\r\n$2\r\nm1\r\n$2\r\nm2\r\n"},
+		{"DEL foo missing m1 m2\r\n", ":3\r\n"},
+		{"EXPIRE foo 10\r\n", ":0\r\n"},
+		{"TTL foo\r\n", ":-2\r\n"},
+		{"TTL list\r\n", ":-1\r\n"},
+		{"EXPIRE list 100\r\n", ":1\r\n"},
+		{"PEXPIRE list 100000\r\n", ":1\r\n"},
+		{"PERSIST list\r\n", ":1\r\n"},
+		{"PERSIST list\r\n", ":0\r\n"},
+		{"EXPIREAT list 1\r\n", ":1\r\n"},
+		{"TTL list\r\n", ":-2\r\n"},
+		{"SET nskey default\r\n", "+OK\r\n"},
+		{"SELECT other\r\n", "+OK\r\n"},
+		{"GET nskey\r\n", "$-1\r\n"},
+		{"SET nskey other\r\n", "+OK\r\n"},
+		{"DBSIZE\r\n", ":1\r\n"},
+		{"SELECT third\r\n", "-ERR Namespaces limit 2 is reached\r\n"},
+		{"GET nskey\r\n", "$5\r\nother\r\n"},
+		{"SELECT 0\r\n", "+OK\r\n"},
+		{"GET nskey\r\n", "$7\r\ndefault\r\n"},
+		{"UNKNOWN\r\n", "-ERR unknown command 'UNKNOWN'\r\n"},
+	}
+	for _, c := range cases {
+		if _, err := client.Write([]byte(c.cmd)); err != nil {
+			t.Fatalf("Failed to write command %q: %v", c.cmd, err)
+		}
+		reply := readRespTestReply(t, r)
+		if reply != c.expected {
+			t.Errorf("Wrong reply for %q: expected %q, got %q", c.cmd, c.expected, reply)
+		}
+	}
+}
+
+func TestRespServer_BlockingDisconnect(t *testing.T) {
+	s := NewStorage(1)
+	s.run()
+	defer s.stop()
+	client, server := net.Pipe()
+	go NewRespServer(s).serveConn(server)
+	if _, err := client.Write([]byte("BLPOP list 0\r\n")); err != nil {
+		t.Fatalf("Failed to write command: %v", err)
+	}
+	time.Sleep(50*time.Millisecond)
+	client.Close()
+	time.Sleep(50*time.Millisecond)
+	// value is not popped for disconnected client
+	s.testOperation(t, operation{op:OP_RPUSH, key:`list`, val:[]string{`a`}, expectedValue:1})
+	s.testOperation(t, operation{op:OP_LLEN, key:`list`, expectedValue:1})
+}
+
+// reads one non-array reply
+func readRespTestReply(t *testing.T, r *bufio.Reader) string {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Failed to read reply: %v", err)
+	}
+	if line[0] == '$' && line != "$-1\r\n" {
+		data, _ := r.ReadString('\n')
+		line += data
+	}
+	if line[0] == '*' {
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		for i := 0; i < n; i++ {
+			line += readRespTestReply(t, r)
+		}
+	}
+	return line
+}
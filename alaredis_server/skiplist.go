@@ -0,0 +1,161 @@
+package main
+
+import (
+	"math/rand"
+)
+
+/**
+ * Ordered list with logarithmic inserts, removals and lookups by item or by position
+ */
+
+const (
+	SKIPLIST_MAX_LEVEL = 32
+	// node of level n gets level n+1 with probability 1/SKIPLIST_LEVEL_RATIO
+	SKIPLIST_LEVEL_RATIO = 4
+)
+
+// skiplist keeps distinct items ordered by less function. Each link keeps its span - count of nodes it passes,
+// so position of item is counted while it is searched
+type skiplist struct {
+	head   *skiplistNode
+	level  int
+	length int
+	less   func(a, b interface{}) bool
+}
+
+type skiplistNode struct {
+	item  interface{}
+	links []skiplistLink
+}
+
+type skiplistLink struct {
+	node *skiplistNode
+	span int
+}
+
+func newSkiplist(less func(a, b interface{}) bool) *skiplist {
+	l := new(skiplist)
+	l.head = &skiplistNode{links: make([]skiplistLink, SKIPLIST_MAX_LEVEL)}
+	l.level = 1
+	l.less = less
+	return l
+}
+
+func randomSkiplistLevel() int {
+	level := 1
+	for level < SKIPLIST_MAX_LEVEL && rand.Intn(SKIPLIST_LEVEL_RATIO) == 0 {
+		level++
+	}
+	return level
+}
+
+// adds item, which must not be in list already
+func (l *skiplist) insert(item interface{}) {
+	var update [SKIPLIST_MAX_LEVEL]*skiplistNode
+	// positions of update nodes, head position is zero and positions of nodes start from 1
+	var pos [SKIPLIST_MAX_LEVEL]int
+	x := l.head
+	for i := l.level-1; i >= 0; i-- {
+		if i < l.level-1 {
+			pos[i] = pos[i+1]
+		}
+		for x.links[i].node != nil && l.less(x.links[i].node.item, item) {
+			pos[i] += x.links[i].span
+			x = x.links[i].node
+		}
+		update[i] = x
+	}
+	level := randomSkiplistLevel()
+	if level > l.level {
+		for i := l.level; i < level; i++ {
+			pos[i] = 0
+			update[i] = l.head
+			l.head.links[i].span = l.length
+		}
+		l.level = level
+	}
+	n := &skiplistNode{item: item, links: make([]skiplistLink, level)}
+	for i := 0; i < level; i++ {
+		n.links[i].node = update[i].links[i].node
+		n.links[i].span = update[i].links[i].span-(pos[0]-pos[i])
+		update[i].links[i].node = n
+		update[i].links[i].span = pos[0]-pos[i]+1
+	}
+	// higher links pass new node
+	for i := level; i < l.level; i++ {
+		update[i].links[i].span++
+	}
+	l.length++
+}
+
+// removes item, returns false if there is no such item
+func (l *skiplist) remove(item interface{}) bool {
+	var update [SKIPLIST_MAX_LEVEL]*skiplistNode
+	x := l.head
+	for i := l.level-1; i >= 0; i-- {
+		for x.links[i].node != nil && l.less(x.links[i].node.item, item) {
+			x = x.links[i].node
+		}
+		update[i] = x
+	}
+	n := x.links[0].node
+	if n == nil || l.less(item, n.item) {
+		return false
+	}
+	for i := 0; i < l.level; i++ {
+		if update[i].links[i].node == n {
+			update[i].links[i].span += n.links[i].span-1
+			update[i].links[i].node = n.links[i].node
+		} else {
+			update[i].links[i].span--
+		}
+	}
+	for l.level > 1 && l.head.links[l.level-1].node == nil {
+		l.level--
+	}
+	l.length--
+	return true
+}
+
+// returns the first node, for which f is true, and its position. f must be false for some first items
+// and true for the rest ones as for sort.Search. Nil node and list length are returned if f is always false
+func (l *skiplist) search(f func(item interface{}) bool) (*skiplistNode, int) {
+	x := l.head
+	pos := 0
+	for i := l.level-1; i >= 0; i-- {
+		for x.links[i].node != nil && !f(x.links[i].node.item) {
+			pos += x.links[i].span
+			x = x.links[i].node
+		}
+	}
+	return x.links[0].node, pos
+}
+
+// returns node at position counted from zero, nil is returned for position out of list
+func (l *skiplist) at(pos int) *skiplistNode {
+	if pos < 0 || pos >= l.length {
+		return nil
+	}
+	x := l.head
+	passed := 0
+	for i := l.level-1; i >= 0; i-- {
+		for x.links[i].node != nil && passed+x.links[i].span <= pos+1 {
+			passed += x.links[i].span
+			x = x.links[i].node
+		}
+		if passed == pos+1 {
+			return x
+		}
+	}
+	return nil
+}
+
+// returns the first node of list, nil for empty list
+func (l *skiplist) first() *skiplistNode {
+	return l.head.links[0].node
+}
+
+// returns the next node of list, nil for the last one
+func (n *skiplistNode) next() *skiplistNode {
+	return n.links[0].node
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestSkiplist_Operations(t *testing.T) {
+	l := newSkiplist(func(a, b interface{}) bool {
+		return a.(int) < b.(int)
+	})
+	expected := make([]int, 0)
+	for _, i := range rand.Perm(1000) {
+		l.insert(i*2)
+		expected = append(expected, i*2)
+	}
+	for _, i := range rand.Perm(1000)[:300] {
+		if !l.remove(i*2) {
+			t.Errorf("Item %d was not removed", i*2)
+		}
+		for j, v := range expected {
+			if v == i*2 {
+				expected = append(expected[:j], expected[j+1:]...)
+				break
+			}
+		}
+	}
+	if l.remove(1) {
+		t.Error("Missing item was removed")
+	}
+	sort.Ints(expected)
+	if l.length != len(expected) {
+		t.Fatalf("Wrong length %d, expected %d", l.length, len(expected))
+	}
+
+	i := 0
+	for n := l.first(); n != nil; n = n.next() {
+		if n.item.(int) != expected[i] {
+			t.Fatalf("Wrong item %v at %d, expected %d", n.item, i, expected[i])
+		}
+		i++
+	}
+	for pos, v := range expected {
+		if n := l.at(pos); n == nil || n.item.(int) != v {
+			t.Fatalf("Wrong node at %d, expected %d", pos, v)
+		}
+		// odd value is missing, so the next even one is found
+		n, found := l.search(func(item interface{}) bool {
+			return item.(int) >= v-1
+		})
+		if n == nil || n.item.(int) != v || found != pos {
+			t.Fatalf("Wrong search result for %d at %d, position %d", v, pos, found)
+		}
+	}
+	if n := l.at(len(expected)); n != nil {
+		t.Errorf("Node out of list is returned: %v", n.item)
+	}
+	if n, pos := l.search(func(item interface{}) bool { return false }); n != nil || pos != len(expected) {
+		t.Errorf("Search without result returned %v at %d", n, pos)
+	}
+}
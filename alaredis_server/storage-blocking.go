@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+/**
+ * Blocking pops. Request for empty list is parked in bucket, until value is pushed to the list.
+ * Caller waits for parked request outside of bucket worker, so worker keeps serving other keys
+ */
+
+// operations, which wait for value, if there is nothing to return
+var BLOCKING_OPERATIONS = map[int]bool {
+	OP_BLPOP: true,
+	OP_BRPOP: true,
+}
+
+// returned by handler, when request is parked, so worker does not reply to it
+var errRequestBlocked = errors.New("Request is blocked")
+
+// pops value from the head or from the tail of list, request is parked if there is no list
+func (s *Storage) blpop(req *innerRequest) (interface{}, error) {
+	return s.blockingPop(req, true)
+}
+
+func (s *Storage) brpop(req *innerRequest) (interface{}, error) {
+	return s.blockingPop(req, false)
+}
+
+func (s *Storage) blockingPop(req *innerRequest, head bool) (interface{}, error) {
+	if req.meta.t == TYPE_NULL {
+		b := s.buckets[req.bucket]
+		b.waiters[req.key] = append(b.waiters[req.key], req)
+		return nil, errRequestBlocked
+	}
+	return s.pop(req, head)
+}
+
+// removes parked request passed as value, returns false if it was already served
+func (s *Storage) unblock(req *innerRequest) (interface{}, error) {
+	parked := req.val.(*innerRequest)
+	b := s.buckets[req.bucket]
+	waiters := b.waiters[req.key]
+	for i, w := range waiters {
+		if w == parked {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			if len(waiters) == 0 {
+				delete(b.waiters, req.key)
+			} else {
+				b.waiters[req.key] = waiters
+			}
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// pops values from list of request for parked requests in order of their arrival, while list is not empty
+func (s *Storage) serveWaiters(req *innerRequest) {
+	b := s.buckets[req.bucket]
+	for len(b.waiters[req.key]) > 0 && req.meta.t == TYPE_LIST {
+		if _, ok := s.getKeyMeta(req.key); !ok {
+			// the last value was popped
+			return
+		}
+		waiters := b.waiters[req.key]
+		w := waiters[0]
+		if len(waiters) == 1 {
+			delete(b.waiters, req.key)
+		} else {
+			b.waiters[req.key] = waiters[1:]
+		}
+		v, err := s.pop(req, w.op == OP_BLPOP)
+		if err != nil {
+			w.errChan <- err
+			continue
+		}
+		// popped list is changed, unless it is removed as the last value was popped
+		req.meta.version = atomic.AddUint64(&s.lastVersion, 1)
+		w.version = req.meta.version
+		s.events.notify(req.key, eventOpNames[w.op], ``)
+		w.outCh <- v
+	}
+}
+
+// sends blocking request to bucket worker, its result is passed to request channels, when value is popped,
+// timeout passed in index (in seconds, zero or empty one means no timeout) is over, or context is done.
+// ObjectNotFound error is returned if nothing was popped
+func (s *Storage) processBlockingRequest(ctx context.Context, req *innerRequest) {
+	var timeout <-chan time.Time
+	if req.idx != `` {
+		seconds, err := strconv.ParseFloat(req.idx, 64)
+		if err != nil || seconds < 0 {
+			req.errChan <- &BadRequest{req, "Timeout must be non negative number"}
+			return
+		}
+		if seconds > 0 {
+			timeout = time.After(time.Duration(seconds*float64(time.Second)))
+		}
+	}
+	// parked request has its own channels, as result could be taken back from it after cancellation
+	parked := *req
+	parked.outCh = make(chan interface{}, 1)
+	parked.errChan = make(chan error, 1)
+	s.processInnerRequest(&parked)
+
+	go func() {
+		select {
+		case v := <-parked.outCh:
+			req.version = parked.version
+			req.outCh <- v
+			return
+		case err := <-parked.errChan:
+			req.errChan <- err
+			return
+		case <-timeout:
+		case <-ctx.Done():
+		}
+		removed, err := s.doRequest(s.newInnerRequest(OP_UNBLOCK, req.key, ``, &parked, 0))
+		if err != nil {
+			req.errChan <- err
+			return
+		}
+		if removed.(bool) {
+			req.errChan <- &ObjectNotFound{req}
+			return
+		}
+		// value was popped before parked request was removed
+		select {
+		case v := <-parked.outCh:
+			req.version = parked.version
+			req.outCh <- v
+		case err := <-parked.errChan:
+			req.errChan <- err
+		}
+	}()
+}
+
+// sends blocking request to bucket worker and waits for its result
+func (s *Storage) doBlockingRequest(ctx context.Context, req *innerRequest) (interface{}, error) {
+	s.processBlockingRequest(ctx, req)
+	select {
+	case val := <-req.outCh:
+		return val, nil
+	case err := <-req.errChan:
+		return nil, err
+	}
+}
@@ -2,23 +2,46 @@ package main
 
 type StorageBucket struct {
 	data   map[string]interface{}
+	// keys of data in lexicographical order, so they are scanned page by page without sorting
+	keys   *skiplist
 	requestChan chan *innerRequest
+	// approximate sizes of objects in bytes, tracked only if storage has memory limit
+	sizes  map[string]int64
+	size   int64
+	// parked blocking requests for list keys in order of their arrival, changed by bucket worker only
+	waiters map[string][]*innerRequest
 }
 
 func newStorageBucket() *StorageBucket {
 	b := new(StorageBucket)
 	b.data = make(map[string]interface{})
+	b.keys = newBucketKeys()
 	b.requestChan = make(chan *innerRequest, 100)
+	b.sizes = make(map[string]int64)
+	b.waiters = make(map[string][]*innerRequest)
 	return b
 }
 
+func newBucketKeys() *skiplist {
+	return newSkiplist(func(a, b interface{}) bool {
+		return a.(string) < b.(string)
+	})
+}
+
 func (b *StorageBucket) delete(k string) {
+	if _, ok := b.data[k]; ok {
+		b.keys.remove(k)
+	}
 	delete(b.data, k)
+	b.size -= b.sizes[k]
+	delete(b.sizes, k)
 }
 
 func (b *StorageBucket) set(k string, v interface{}) {
+	if _, ok := b.data[k]; !ok {
+		b.keys.insert(k)
+	}
 	b.data[k] = v
-
 }
 
 func (b *StorageBucket) get(k string) (*interface{}, bool) {
@@ -26,6 +49,28 @@ func (b *StorageBucket) get(k string) (*interface{}, bool) {
 	return &v, ok
 }
 
+// sets size of object, which is replaced as a whole
+func (b *StorageBucket) setSize(k string, size int64) {
+	b.size += size-b.sizes[k]
+	b.sizes[k] = size
+}
+
+// changes size of object, which is changed in place. Size of new object starts from its overhead,
+// change of missing object is ignored, as it was deleted
+func (b *StorageBucket) addSize(k string, delta int64) {
+	if _, ok := b.data[k]; !ok {
+		return
+	}
+	if _, ok := b.sizes[k]; !ok {
+		b.setSize(k, objectSize(k, nil))
+	}
+	b.sizes[k] += delta
+	b.size += delta
+}
+
 func (b *StorageBucket) clear() {
 	b.data = make(map[string]interface{})
-}
\ No newline at end of file
+	b.keys = newBucketKeys()
+	b.sizes = make(map[string]int64)
+	b.size = 0
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"path"
+	"sync"
+	"sync/atomic"
+)
+
+/**
+ * Keyspace events, which are sent to subscribers by bucket workers
+ */
+
+const (
+	// count of events, buffered for each subscriber. Events are dropped, while subscriber buffer is full
+	EVENTS_BUFFER_SIZE = 256
+	EVENT_EXPIRED = `expired`
+	EVENT_EXPIREDI = `expiredi`
+	EVENT_EVICTED = `evicted`
+)
+
+// operations, which change expiration of object only. They do not change object version, but notify about changes
+var EXPIRATION_OPERATIONS = map[int]bool {
+	OP_EXPIRE: true,
+	OP_EXPIREAT: true,
+	OP_PERSIST: true,
+	OP_TOUCH: true,
+}
+
+// event names are names of operations, which changed keys, and names of internal removals
+var EVENT_NAMES = func() map[string]bool {
+	names := map[string]bool{EVENT_EXPIRED: true, EVENT_EXPIREDI: true, EVENT_EVICTED: true}
+	for name, op := range OPERATIONS {
+		if (!READ_OPERATIONS[op] || EXPIRATION_OPERATIONS[op]) && !KEYLESS_OPERATIONS[op] {
+			names[name] = true
+		}
+	}
+	return names
+}()
+
+var eventOpNames = func() map[int]string {
+	names := make(map[int]string)
+	for name, op := range OPERATIONS {
+		names[op] = name
+	}
+	return names
+}()
+
+type keyEvent struct {
+	Key  string `json:"key"`
+	Op   string `json:"op"`
+	// field of dict or index of list item, set for expiredi event only
+	Idx  string `json:"idx,omitempty"`
+	// unix time in milliseconds
+	Time int64  `json:"time"`
+}
+
+type eventSubscriber struct {
+	// glob pattern for keys, empty one matches all keys
+	match   string
+	// event names, empty set matches all events
+	ops     map[string]bool
+	events  chan *keyEvent
+	// count of events dropped since the last read
+	dropped uint64
+}
+
+type eventNotifier struct {
+	subscribers map[*eventSubscriber]struct{}
+	// count of subscribers, so events are not composed, while nobody listens
+	count       int32
+	lock        sync.RWMutex
+}
+
+func newEventNotifier() *eventNotifier {
+	n := new(eventNotifier)
+	n.subscribers = make(map[*eventSubscriber]struct{})
+	return n
+}
+
+func (n *eventNotifier) subscribe(match string, ops map[string]bool) *eventSubscriber {
+	sub := &eventSubscriber{match: match, ops: ops, events: make(chan *keyEvent, EVENTS_BUFFER_SIZE)}
+	n.lock.Lock()
+	n.subscribers[sub] = struct{}{}
+	atomic.AddInt32(&n.count, 1)
+	n.lock.Unlock()
+	return sub
+}
+
+func (n *eventNotifier) unsubscribe(sub *eventSubscriber) {
+	n.lock.Lock()
+	if _, ok := n.subscribers[sub]; ok {
+		delete(n.subscribers, sub)
+		atomic.AddInt32(&n.count, -1)
+		close(sub.events)
+	}
+	n.lock.Unlock()
+}
+
+// unsubscribes everybody, so their event channels are closed
+func (n *eventNotifier) close() {
+	n.lock.Lock()
+	for sub := range n.subscribers {
+		delete(n.subscribers, sub)
+		close(sub.events)
+	}
+	atomic.StoreInt32(&n.count, 0)
+	n.lock.Unlock()
+}
+
+// sends event to matching subscribers without blocking
+func (n *eventNotifier) notify(key string, op string, idx string) {
+	if atomic.LoadInt32(&n.count) == 0 {
+		return
+	}
+	e := &keyEvent{key, op, idx, nowMillis()}
+	n.lock.RLock()
+	for sub := range n.subscribers {
+		if !sub.matches(e) {
+			continue
+		}
+		select {
+		case sub.events <- e:
+		default:
+			atomic.AddUint64(&sub.dropped, 1)
+		}
+	}
+	n.lock.RUnlock()
+}
+
+func (sub *eventSubscriber) matches(e *keyEvent) bool {
+	if len(sub.ops) > 0 && !sub.ops[e.Op] {
+		return false
+	}
+	if sub.match != `` {
+		if ok, _ := path.Match(sub.match, e.Key); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// returns count of dropped events and resets it
+func (sub *eventSubscriber) takeDropped() uint64 {
+	return atomic.SwapUint64(&sub.dropped, 0)
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+/**
+ * Memory limit and eviction of keys. Limit is split evenly between buckets, so each bucket worker
+ * evicts keys of its own bucket only
+ */
+
+const (
+	EVICTION_NO = iota
+	EVICTION_ALLKEYS_LRU
+	EVICTION_ALLKEYS_LFU
+	EVICTION_VOLATILE_LRU
+	EVICTION_VOLATILE_TTL
+	EVICTION_RANDOM
+)
+
+var EVICTION_POLICIES = map[string]int {
+	`noeviction`: EVICTION_NO,
+	`allkeys-lru`: EVICTION_ALLKEYS_LRU,
+	`allkeys-lfu`: EVICTION_ALLKEYS_LFU,
+	`volatile-lru`: EVICTION_VOLATILE_LRU,
+	`volatile-ttl`: EVICTION_VOLATILE_TTL,
+	`random`: EVICTION_RANDOM,
+}
+
+const (
+	// count of keys, from which eviction candidate is chosen
+	EVICTION_SAMPLES = 5
+	// max count of keys checked for candidates, as volatile policies skip keys without ttl
+	EVICTION_MAX_CHECKED = 20*EVICTION_SAMPLES
+	// approximate memory overhead of each object and each value of collection
+	OBJECT_OVERHEAD = 64
+	VALUE_OVERHEAD = 16
+	PENDING_ENTRY_SIZE = 4*VALUE_OVERHEAD
+	// access frequency is halved for each period passed since the last access
+	LFU_DECAY_PERIOD = 60000
+)
+
+// operations, which could increase used memory, they are rejected if memory limit is reached and nothing can be evicted
+var GROWING_OPERATIONS = map[int]bool {
+	OP_SET: true,
+	OP_LSET: true,
+	OP_LSETI: true,
+	OP_DSET: true,
+	OP_DSETI: true,
+	OP_ADD: true,
+	OP_REPLACE: true,
+	OP_INCRBY: true,
+	OP_INCR: true,
+	OP_DECR: true,
+	OP_INCRBYFLOAT: true,
+	OP_LPUSH: true,
+	OP_RPUSH: true,
+	OP_LINSERT: true,
+	OP_DMSET: true,
+	OP_SADD: true,
+	OP_ZADD: true,
+	OP_ZINCRBY: true,
+	OP_XADD: true,
+	OP_XGROUPCREATE: true,
+	OP_BSET: true,
+	OP_JSET: true,
+	OP_JARRAPPEND: true,
+	OP_JNUMINCRBY: true,
+	OP_RESTORE: true,
+}
+
+// operations replacing the whole object, so its current size is freed by them
+var REPLACING_OPERATIONS = map[int]bool {
+	OP_SET: true,
+	OP_LSET: true,
+	OP_DSET: true,
+	OP_REPLACE: true,
+	OP_BSET: true,
+	OP_RESTORE: true,
+}
+
+// sets memory limit in bytes and eviction policy, zero limit disables sizes tracking. Must be called before run
+func (s *Storage) setMemoryLimit(maxMemory int64, policy int) {
+	s.maxMemory = maxMemory
+	s.evictionPolicy = policy
+}
+
+// evicts keys of bucket, until it fits into its share of memory limit together with data added by request.
+// Key of request is never evicted. Returns false, if bucket still does not fit
+func (s *Storage) evict(req *innerRequest) bool {
+	b := s.buckets[req.bucket]
+	limit := s.maxMemory/int64(s.bucketsNum)
+	growth := s.requestSize(req)
+	for b.size+growth > limit {
+		if s.evictionPolicy == EVICTION_NO {
+			return false
+		}
+		m := s.evictionCandidate(b, req.key)
+		if m == nil {
+			return false
+		}
+		evicted := s.newInnerRequest(OP_DELETE, m.key, ``, nil, 0)
+		evicted.meta = m
+		s.delete(evicted)
+		s.events.notify(m.key, EVENT_EVICTED, ``)
+	}
+	return true
+}
+
+// chooses the best key to evict by policy from several keys of bucket, map iteration order makes them random.
+// Count of checked keys is limited, so nil could be returned, even if bucket has keys to evict
+func (s *Storage) evictionCandidate(b *StorageBucket, skip string) *keyMeta {
+	var best *keyMeta
+	sampled := 0
+	checked := 0
+	volatile := s.evictionPolicy == EVICTION_VOLATILE_LRU || s.evictionPolicy == EVICTION_VOLATILE_TTL
+	for k := range b.data {
+		checked++
+		if checked > EVICTION_MAX_CHECKED {
+			break
+		}
+		if k == skip {
+			continue
+		}
+		m, ok := s.getKeyMeta(k)
+		if !ok {
+			continue
+		}
+		if volatile && m.expireAt == 0 {
+			continue
+		}
+		if best == nil || s.evictsBefore(m, best) {
+			best = m
+		}
+		sampled++
+		if sampled >= EVICTION_SAMPLES {
+			break
+		}
+	}
+	return best
+}
+
+func (s *Storage) evictsBefore(a, b *keyMeta) bool {
+	switch s.evictionPolicy {
+	case EVICTION_ALLKEYS_LRU, EVICTION_VOLATILE_LRU:
+		return a.accessedAt < b.accessedAt
+	case EVICTION_ALLKEYS_LFU:
+		fa, fb := a.decayedFrequency(nowMillis()), b.decayedFrequency(nowMillis())
+		return fa < fb || (fa == fb && a.accessedAt < b.accessedAt)
+	case EVICTION_VOLATILE_TTL:
+		return a.expireAt < b.expireAt
+	}
+	return false
+}
+
+// records access to object for eviction policies
+func (m *keyMeta) touchAccess() {
+	now := nowMillis()
+	m.frequency = m.decayedFrequency(now)
+	if m.frequency < ^uint32(0) {
+		m.frequency++
+	}
+	m.accessedAt = now
+}
+
+func (m *keyMeta) decayedFrequency(now int64) uint32 {
+	periods := (now-m.accessedAt)/LFU_DECAY_PERIOD
+	if periods >= 32 {
+		return 0
+	}
+	return m.frequency >> uint(periods)
+}
+
+// sets size of object of request, which is replaced as a whole. Sizes are tracked only if storage has memory limit
+func (s *Storage) setSize(req *innerRequest, v interface{}) {
+	if s.maxMemory > 0 {
+		s.buckets[req.bucket].setSize(req.key, objectSize(req.key, v))
+	}
+}
+
+// changes size of object of request, which is changed in place, by size of added or removed values
+func (s *Storage) addSize(req *innerRequest, delta int64) {
+	if s.maxMemory > 0 {
+		s.buckets[req.bucket].addSize(req.key, delta)
+	}
+}
+
+// returns approximate memory, which request adds to bucket, before it is performed. Estimation is an upper bound,
+// as values replaced in place are not subtracted
+func (s *Storage) requestSize(req *innerRequest) int64 {
+	size := int64(0)
+	if req.meta.t == TYPE_NULL {
+		size += int64(OBJECT_OVERHEAD+len(req.key))
+	} else if REPLACING_OPERATIONS[req.op] {
+		size -= s.buckets[req.bucket].sizes[req.key]-int64(OBJECT_OVERHEAD+len(req.key))
+	}
+	switch val := req.val.(type) {
+	case string:
+		size += valueSize(val)
+	case []byte:
+		size += int64(VALUE_OVERHEAD+len(val))
+	case json.RawMessage:
+		size += int64(VALUE_OVERHEAD+len(val))
+	case []string:
+		for _, item := range val {
+			size += valueSize(item)
+		}
+	case map[string]string:
+		for f, item := range val {
+			if req.op == OP_ZADD {
+				size += zsetMemberSize(f)
+			} else {
+				size += fieldSize(f, item)
+			}
+		}
+	case map[string]*string:
+		for f, item := range val {
+			if item != nil {
+				size += fieldSize(f, *item)
+			}
+		}
+	default:
+		// counters, stream groups and restored objects
+		size += VALUE_OVERHEAD
+	}
+	return size
+}
+
+// returns approximate memory used by object with its key
+func objectSize(k string, v interface{}) int64 {
+	size := int64(OBJECT_OVERHEAD+len(k))
+	switch val := v.(type) {
+	case string:
+		size += int64(len(val))
+	case []byte:
+		size += int64(len(val))
+	case []string:
+		for _, item := range val {
+			size += valueSize(item)
+		}
+	case map[string]string:
+		for f, item := range val {
+			size += fieldSize(f, item)
+		}
+	case map[string]struct{}:
+		for member := range val {
+			size += valueSize(member)
+		}
+	case *zset:
+		for member := range val.scores {
+			size += zsetMemberSize(member)
+		}
+	case *stream:
+		size += streamEntriesSize(val.entries)
+		for name, g := range val.groups {
+			size += streamGroupSize(name)+int64(len(g.pending))*PENDING_ENTRY_SIZE
+		}
+	case *jsonDoc:
+		size += jsonSize(val.root)
+	}
+	return size
+}
+
+// size of list item or set member
+func valueSize(v string) int64 {
+	return int64(VALUE_OVERHEAD+len(v))
+}
+
+// size of dict field with its value
+func fieldSize(f string, v string) int64 {
+	return int64(2*VALUE_OVERHEAD+len(f)+len(v))
+}
+
+// member is kept both in scores map and in sorted items
+func zsetMemberSize(member string) int64 {
+	return int64(4*VALUE_OVERHEAD+2*len(member))
+}
+
+func streamEntriesSize(entries []streamEntry) int64 {
+	size := int64(0)
+	for _, e := range entries {
+		size += VALUE_OVERHEAD
+		for f, item := range e.fields {
+			size += fieldSize(f, item)
+		}
+	}
+	return size
+}
+
+func streamGroupSize(name string) int64 {
+	return int64(VALUE_OVERHEAD+len(name))
+}
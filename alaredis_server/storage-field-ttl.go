@@ -0,0 +1,165 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+)
+
+/**
+ * Expiration of dict fields and list items. List items are tracked by their indexes,
+ * so operations shifting list values move expirations along with them
+ */
+
+func (s *Storage) onFieldExpire(m *keyMeta, field string) {
+	s.processInnerRequest(s.newInnerRequest(OP_EXPIREDI, m.key, field, m, 0))
+}
+
+// removes field from index, if key meta is still the same as expired one and field expiration was not changed meanwhile
+func (s *Storage) expiredi(req *innerRequest) (interface{}, error) {
+	m := req.val.(*keyMeta)
+	expireAt, ok := m.fieldExpireAt[req.idx]
+	if req.meta != m || !ok || expireAt > nowMillis() {
+		return nil, nil
+	}
+	s.deleteField(req, req.idx)
+	s.events.notify(req.key, EVENT_EXPIREDI, req.idx)
+	return nil, nil
+}
+
+// sets expiration of dict field or list item from request index, zero ttl removes expiration
+func (s *Storage) setFieldTTL(req *innerRequest) {
+	if req.ttl > 0 {
+		s.ttlMonitor.monitorField(req.meta, req.idx, req.ttl)
+	} else if _, ok := req.meta.fieldExpireAt[req.idx]; ok {
+		s.ttlMonitor.unmonitorField(req.meta, req.idx)
+	}
+}
+
+// removes dict field or list item, object is deleted when nothing is left
+func (s *Storage) deleteField(req *innerRequest, field string) {
+	if _, ok := req.meta.fieldExpireAt[field]; ok {
+		s.ttlMonitor.unmonitorField(req.meta, field)
+	}
+	valPtr, ok := s.buckets[req.bucket].get(req.key)
+	if !ok {
+		return
+	}
+	switch v := (*valPtr).(type) {
+	case map[string]string:
+		if cur, ok := v[field]; ok {
+			s.addSize(req, -fieldSize(field, cur))
+		}
+		delete(v, field)
+		if len(v) == 0 {
+			s.delete(req)
+		}
+	case []string:
+		idx, err := strconv.Atoi(field)
+		if err != nil || idx < 0 || idx >= len(v) {
+			return
+		}
+		if len(v) == 1 {
+			s.delete(req)
+			return
+		}
+		s.addSize(req, -valueSize(v[idx]))
+		list := make([]string, 0, len(v)-1)
+		list = append(list, v[:idx]...)
+		s.buckets[req.bucket].set(req.key, append(list, v[idx+1:]...))
+		s.remapItemExpirations(req, func(i int) int {
+			if i > idx {
+				return i-1
+			}
+			return i
+		})
+	}
+}
+
+// removes expired fields, which were not removed by ttl monitor yet. Request meta is reset if object is deleted
+func (s *Storage) deleteExpiredFields(req *innerRequest) {
+	now := nowMillis()
+	expired := make([]string, 0)
+	for f, expireAt := range req.meta.fieldExpireAt {
+		if expireAt <= now {
+			expired = append(expired, f)
+		}
+	}
+	if len(expired) == 0 {
+		return
+	}
+	if req.meta.t == TYPE_LIST {
+		// items are removed from the tail, so indexes of remaining expired items are not shifted
+		sort.Slice(expired, func(i, j int) bool {
+			a, _ := strconv.Atoi(expired[i])
+			b, _ := strconv.Atoi(expired[j])
+			return a > b
+		})
+	}
+	for _, f := range expired {
+		s.deleteField(req, f)
+		s.events.notify(req.key, EVENT_EXPIREDI, f)
+	}
+	if _, ok := s.getKeyMeta(req.key); !ok {
+		req.meta = newKeyMeta(req.key)
+	}
+}
+
+// removes expirations of all fields, when object is replaced or deleted
+func (s *Storage) clearFieldExpirations(m *keyMeta) {
+	for f := range m.fieldExpireAt {
+		s.ttlMonitor.unmonitorField(m, f)
+	}
+}
+
+// moves expirations of list items to their new indexes, negative new index removes expiration
+func (s *Storage) remapItemExpirations(req *innerRequest, newIdx func(i int) int) {
+	if len(req.meta.fieldExpireAt) == 0 {
+		return
+	}
+	old := make(map[int]int64, len(req.meta.fieldExpireAt))
+	for f, expireAt := range req.meta.fieldExpireAt {
+		i, _ := strconv.Atoi(f)
+		old[i] = expireAt
+	}
+	for i := range old {
+		if newIdx(i) != i {
+			s.ttlMonitor.unmonitorField(req.meta, strconv.Itoa(i))
+		}
+	}
+	for i, expireAt := range old {
+		if j := newIdx(i); j >= 0 && j != i {
+			s.ttlMonitor.monitorFieldAt(req.meta, strconv.Itoa(j), expireAt)
+		}
+	}
+}
+
+// returns remaining ttl of dict field or list item from request index in milliseconds,
+// -1 if it does not expire and -2 if there is no such field
+func (s *Storage) fieldPTTL(req *innerRequest) (interface{}, error) {
+	valPtr, _ := s.buckets[req.bucket].get(req.key)
+	switch v := (*valPtr).(type) {
+	case map[string]string:
+		if _, ok := v[req.idx]; !ok {
+			return -2, nil
+		}
+	case []string:
+		idx, err := strconv.Atoi(req.idx)
+		if err != nil {
+			return nil, &BadRequest{req, "Non integer index: "+err.Error()}
+		}
+		if idx < 0 || idx >= len(v) {
+			return -2, nil
+		}
+	default:
+		return nil, &BadRequest{req, "Stored object is not list or dict"}
+	}
+	expireAt, ok := req.meta.fieldExpireAt[req.idx]
+	if !ok {
+		return -1, nil
+	}
+	ttl := expireAt-nowMillis()
+	if ttl < 0 {
+		ttl = 0
+	}
+	return int(ttl), nil
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/**
+ * Operations on json documents. Document is kept decoded, so its parts are selected by path and changed in place
+ * by bucket worker. Path is like $.user.addresses[0].city, where $ is the whole document
+ */
+
+// decoded document: map[string]interface{}, []interface{}, string, json.Number, bool or nil
+type jsonDoc struct {
+	root interface{}
+}
+
+// step of path, either field of object or index of array, negative index is counted from the end
+type jsonPathStep struct {
+	field   string
+	index   int
+	isIndex bool
+}
+
+var errJsonPathNotFound = errors.New("Path does not exist")
+
+// parses path in form $.field[index]['field with dots'], empty path means the whole document
+func parseJsonPath(path string) ([]jsonPathStep, error) {
+	if path == `` || path == `$` {
+		return nil, nil
+	}
+	if path[0] != '$' {
+		return nil, errors.New("Path must start with '$'")
+	}
+	steps := make([]jsonPathStep, 0)
+	for i := 1; i < len(path); {
+		switch path[i] {
+		case '.':
+			end := i+1
+			for end < len(path) && path[end] != '.' && path[end] != '[' {
+				end++
+			}
+			if end == i+1 {
+				return nil, errors.New("Empty field name at position "+strconv.Itoa(i))
+			}
+			steps = append(steps, jsonPathStep{field: path[i+1:end]})
+			i = end
+		case '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, errors.New("Unclosed bracket at position "+strconv.Itoa(i))
+			}
+			inner := path[i+1:i+end]
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				steps = append(steps, jsonPathStep{field: inner[1:len(inner)-1]})
+			} else {
+				idx, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, errors.New("Non integer array index '"+inner+"'")
+				}
+				steps = append(steps, jsonPathStep{index: idx, isIndex: true})
+			}
+			i += end+1
+		default:
+			return nil, errors.New("Unexpected character '"+path[i:i+1]+"' at position "+strconv.Itoa(i))
+		}
+	}
+	return steps, nil
+}
+
+func decodeJson(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	// numbers are kept as is, so big integers do not lose precision
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("Unexpected data after json value")
+	}
+	return v, nil
+}
+
+// returns position in array for index step
+func (step jsonPathStep) arrayIndex(arr []interface{}) (int, bool) {
+	i := step.index
+	if i < 0 {
+		i += len(arr)
+	}
+	return i, i >= 0 && i < len(arr)
+}
+
+// returns value selected by path
+func jsonGet(node interface{}, steps []jsonPathStep) (interface{}, error) {
+	for _, step := range steps {
+		if step.isIndex {
+			arr, ok := node.([]interface{})
+			if !ok {
+				return nil, errJsonPathNotFound
+			}
+			i, ok := step.arrayIndex(arr)
+			if !ok {
+				return nil, errJsonPathNotFound
+			}
+			node = arr[i]
+		} else {
+			obj, ok := node.(map[string]interface{})
+			if !ok {
+				return nil, errJsonPathNotFound
+			}
+			if node, ok = obj[step.field]; !ok {
+				return nil, errJsonPathNotFound
+			}
+		}
+	}
+	return node, nil
+}
+
+// replaces value selected by path with result of update, which gets current value. Missing field of object is created,
+// if path to object exists. Returns updated node, as arrays could be reallocated
+func jsonUpdate(node interface{}, steps []jsonPathStep, update func(v interface{}, exists bool) (interface{}, error)) (interface{}, error) {
+	if len(steps) == 0 {
+		return update(node, true)
+	}
+	step := steps[0]
+	if step.isIndex {
+		arr, ok := node.([]interface{})
+		if !ok {
+			return nil, errJsonPathNotFound
+		}
+		i, ok := step.arrayIndex(arr)
+		if !ok {
+			return nil, errJsonPathNotFound
+		}
+		v, err := jsonUpdate(arr[i], steps[1:], update)
+		if err != nil { return nil, err }
+		arr[i] = v
+		return arr, nil
+	}
+	obj, ok := node.(map[string]interface{})
+	if !ok {
+		return nil, errJsonPathNotFound
+	}
+	child, exists := obj[step.field]
+	var v interface{}
+	var err error
+	if exists {
+		v, err = jsonUpdate(child, steps[1:], update)
+	} else if len(steps) == 1 {
+		v, err = update(nil, false)
+	} else {
+		err = errJsonPathNotFound
+	}
+	if err != nil { return nil, err }
+	obj[step.field] = v
+	return obj, nil
+}
+
+// removes value selected by path, returns updated node and false if there was nothing to remove
+func jsonDelete(node interface{}, steps []jsonPathStep) (interface{}, bool) {
+	step := steps[0]
+	if step.isIndex {
+		arr, ok := node.([]interface{})
+		if !ok {
+			return node, false
+		}
+		i, ok := step.arrayIndex(arr)
+		if !ok {
+			return node, false
+		}
+		if len(steps) == 1 {
+			return append(arr[:i], arr[i+1:]...), true
+		}
+		v, deleted := jsonDelete(arr[i], steps[1:])
+		arr[i] = v
+		return arr, deleted
+	}
+	obj, ok := node.(map[string]interface{})
+	if !ok {
+		return node, false
+	}
+	child, ok := obj[step.field]
+	if !ok {
+		return node, false
+	}
+	if len(steps) == 1 {
+		delete(obj, step.field)
+		return obj, true
+	}
+	v, deleted := jsonDelete(child, steps[1:])
+	obj[step.field] = v
+	return obj, deleted
+}
+
+// adds delta to number, integers stay integers, if delta is integer too
+func addJsonNumber(n json.Number, delta string) (json.Number, error) {
+	if i, err := n.Int64(); err == nil {
+		if d, err := strconv.ParseInt(delta, 10, 64); err == nil {
+			if (d > 0 && i > math.MaxInt64-d) || (d < 0 && i < math.MinInt64-d) {
+				return ``, errors.New("Increment would overflow")
+			}
+			return json.Number(strconv.FormatInt(i+d, 10)), nil
+		}
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return ``, errors.New("Stored number is not float")
+	}
+	d, err := strconv.ParseFloat(delta, 64)
+	if err != nil {
+		return ``, errors.New("Non float increment: "+err.Error())
+	}
+	res := f+d
+	if math.IsInf(res, 0) || math.IsNaN(res) {
+		return ``, errors.New("Increment would produce NaN or Infinity")
+	}
+	return json.Number(strconv.FormatFloat(res, 'f', -1, 64)), nil
+}
+
+// sets json value from body by path passed as index. Setting the whole document creates or overwrites object
+// and applies ttl, other paths require existing document
+func (s *Storage) jset(req *innerRequest) (interface{}, error) {
+	k := req.key
+	m := req.meta
+	data, ok := req.val.(json.RawMessage)
+	if !ok {
+		return nil, &BadRequest{req, "Incoming object is not json"}
+	}
+	v, err := decodeJson(data)
+	if err != nil {
+		return nil, &BadRequest{req, "Wrong json: "+err.Error()}
+	}
+	steps, err := parseJsonPath(req.idx)
+	if err != nil {
+		return nil, &BadRequest{req, "Wrong path: "+err.Error()}
+	}
+	if len(steps) == 0 {
+		s.ttlMonitor.monitor(m, req.ttl)
+		s.clearFieldExpirations(m)
+		m.t = TYPE_JSON
+		s.setKeyMeta(k, m)
+		doc := &jsonDoc{v}
+		s.buckets[req.bucket].set(k, doc)
+		s.setSize(req, doc)
+		return nil, nil
+	}
+	doc, err := s.getJsonDoc(req)
+	if err != nil { return nil, err }
+	var size int64
+	root, err := jsonUpdate(doc.root, steps, func(cur interface{}, exists bool) (interface{}, error) {
+		if exists {
+			size = jsonSize(v)-jsonSize(cur)
+		} else {
+			size = jsonStepSize(steps[len(steps)-1], v)
+		}
+		return v, nil
+	})
+	if err != nil {
+		return nil, &BadRequest{req, err.Error()}
+	}
+	doc.root = root
+	s.addSize(req, size)
+	return nil, nil
+}
+
+// returns json value selected by path passed as index, the whole document by default
+func (s *Storage) jget(req *innerRequest) (interface{}, error) {
+	doc, err := s.getJsonDoc(req)
+	if err != nil { return nil, err }
+	steps, err := parseJsonPath(req.idx)
+	if err != nil {
+		return nil, &BadRequest{req, "Wrong path: "+err.Error()}
+	}
+	v, err := jsonGet(doc.root, steps)
+	if err != nil {
+		return nil, &BadRequest{req, err.Error()}
+	}
+	// value is encoded by worker, as document could be changed after it is returned
+	data, err := json.Marshal(v)
+	if err != nil { return nil, err }
+	return json.RawMessage(data), nil
+}
+
+// removes json value selected by path passed as index, the whole document is removed by default.
+// Returns count of removed values
+func (s *Storage) jdel(req *innerRequest) (interface{}, error) {
+	doc, err := s.getJsonDoc(req)
+	if err != nil { return nil, err }
+	steps, err := parseJsonPath(req.idx)
+	if err != nil {
+		return nil, &BadRequest{req, "Wrong path: "+err.Error()}
+	}
+	if len(steps) == 0 {
+		s.delete(req)
+		return 1, nil
+	}
+	var size int64
+	if v, err := jsonGet(doc.root, steps); err == nil {
+		size = jsonStepSize(steps[len(steps)-1], v)
+	}
+	root, deleted := jsonDelete(doc.root, steps)
+	doc.root = root
+	if !deleted {
+		req.unchanged = true
+		return 0, nil
+	}
+	s.addSize(req, -size)
+	return 1, nil
+}
+
+// appends values from json array in body to array selected by path passed as index. Returns new length of array
+func (s *Storage) jarrappend(req *innerRequest) (interface{}, error) {
+	data, ok := req.val.(json.RawMessage)
+	if !ok {
+		return nil, &BadRequest{req, "Incoming object is not json"}
+	}
+	v, err := decodeJson(data)
+	if err != nil {
+		return nil, &BadRequest{req, "Wrong json: "+err.Error()}
+	}
+	values, ok := v.([]interface{})
+	if !ok {
+		return nil, &BadRequest{req, "Incoming json is not array"}
+	}
+	doc, err := s.getJsonDoc(req)
+	if err != nil { return nil, err }
+	steps, err := parseJsonPath(req.idx)
+	if err != nil {
+		return nil, &BadRequest{req, "Wrong path: "+err.Error()}
+	}
+	length := 0
+	root, err := jsonUpdate(doc.root, steps, func(cur interface{}, exists bool) (interface{}, error) {
+		arr, ok := cur.([]interface{})
+		if !exists || !ok {
+			return nil, errors.New("Value by path is not array")
+		}
+		arr = append(arr, values...)
+		length = len(arr)
+		return arr, nil
+	})
+	if err != nil {
+		return nil, &BadRequest{req, err.Error()}
+	}
+	doc.root = root
+	// appended values are counted without overhead of incoming array
+	s.addSize(req, jsonSize(values)-VALUE_OVERHEAD)
+	req.unchanged = len(values) == 0
+	return length, nil
+}
+
+// increments number selected by path passed as index by the first arg. Returns new number
+func (s *Storage) jnumincrby(req *innerRequest) (interface{}, error) {
+	doc, err := s.getJsonDoc(req)
+	if err != nil { return nil, err }
+	if len(req.args) < 1 {
+		return nil, &BadRequest{req, "Increment is not set"}
+	}
+	steps, err := parseJsonPath(req.idx)
+	if err != nil {
+		return nil, &BadRequest{req, "Wrong path: "+err.Error()}
+	}
+	var res json.Number
+	var size int64
+	root, err := jsonUpdate(doc.root, steps, func(cur interface{}, exists bool) (interface{}, error) {
+		n, ok := cur.(json.Number)
+		if !exists || !ok {
+			return nil, errors.New("Value by path is not number")
+		}
+		var err error
+		res, err = addJsonNumber(n, req.args[0])
+		size = int64(len(res)-len(n))
+		return res, err
+	})
+	if err != nil {
+		return nil, &BadRequest{req, err.Error()}
+	}
+	doc.root = root
+	s.addSize(req, size)
+	return json.RawMessage(res), nil
+}
+
+func (s *Storage) getJsonDoc(req *innerRequest) (*jsonDoc, error) {
+	if req.meta.t == TYPE_NULL {
+		return nil, &ObjectNotFound{req}
+	} else if req.meta.t != TYPE_JSON {
+		return nil, &BadRequest{req, "Stored object is not json"}
+	}
+	docPtr, _ := s.buckets[req.bucket].get(req.key)
+	return (*docPtr).(*jsonDoc), nil
+}
+
+// approximate size of value selected by the last step of path, field of object is counted with its name
+func jsonStepSize(step jsonPathStep, v interface{}) int64 {
+	size := jsonSize(v)
+	if !step.isIndex {
+		size += int64(VALUE_OVERHEAD+len(step.field))
+	}
+	return size
+}
+
+// approximate size of decoded json value
+func jsonSize(v interface{}) int64 {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		size := int64(VALUE_OVERHEAD)
+		for f, item := range val {
+			size += int64(VALUE_OVERHEAD+len(f))+jsonSize(item)
+		}
+		return size
+	case []interface{}:
+		size := int64(VALUE_OVERHEAD)
+		for _, item := range val {
+			size += jsonSize(item)
+		}
+		return size
+	case string:
+		return int64(VALUE_OVERHEAD+len(val))
+	case json.Number:
+		return int64(VALUE_OVERHEAD+len(val))
+	}
+	return VALUE_OVERHEAD
+}
@@ -0,0 +1,93 @@
+package main
+
+/**
+ * Operations on string objects for several keys
+ */
+
+// returns list of values for keys from incoming list in the same order, nil marks missing or non string object
+func (s *Storage) mget(req *innerRequest) (interface{}, error) {
+	keys, ok := req.val.([]string)
+	if !ok {
+		return nil, &BadRequest{req, "Incoming object is not list"}
+	}
+	reqs := make([]*innerRequest, len(keys))
+	for i, k := range keys {
+		reqs[i] = s.newInnerRequest(OP_GET, k, ``, nil, 0)
+	}
+	vals, errs := s.doRequests(reqs)
+	res := make([]*string, len(keys))
+	for i, v := range vals {
+		if errs[i] != nil {
+			switch errs[i].(type) {
+			case *ObjectNotFound, *BadRequest:
+				continue
+			default:
+				return nil, errs[i]
+			}
+		}
+		str := v.(string)
+		res[i] = &str
+	}
+	return res, nil
+}
+
+// sets string objects from incoming dict, ttl is applied to each of them
+func (s *Storage) mset(req *innerRequest) (interface{}, error) {
+	v, ok := req.val.(map[string]string)
+	if !ok {
+		return nil, &BadRequest{req, "Incoming object is not dict"}
+	}
+	reqs := make([]*innerRequest, 0, len(v))
+	for k, val := range v {
+		r := s.newInnerRequest(OP_SET, k, ``, val, 0)
+		r.ttl = req.ttl
+		reqs = append(reqs, r)
+	}
+	_, errs := s.doRequests(reqs)
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// deletes objects for keys from incoming list, returns count of deleted objects
+func (s *Storage) mdel(req *innerRequest) (interface{}, error) {
+	keys, ok := req.val.([]string)
+	if !ok {
+		return nil, &BadRequest{req, "Incoming object is not list"}
+	}
+	reqs := make([]*innerRequest, len(keys))
+	for i, k := range keys {
+		reqs[i] = s.newInnerRequest(OP_DELETE, k, ``, nil, 0)
+	}
+	_, errs := s.doRequests(reqs)
+	cnt := 0
+	for i, r := range reqs {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		// meta keeps type of deleted object
+		if r.meta.t != TYPE_NULL {
+			cnt++
+		}
+	}
+	return cnt, nil
+}
+
+// sends all requests to their buckets at once and waits for their results, which are returned in the same order
+func (s *Storage) doRequests(reqs []*innerRequest) ([]interface{}, []error) {
+	for _, r := range reqs {
+		s.processInnerRequest(r)
+	}
+	vals := make([]interface{}, len(reqs))
+	errs := make([]error, len(reqs))
+	for i, r := range reqs {
+		select {
+		case vals[i] = <-r.outCh:
+		case errs[i] = <-r.errChan:
+		}
+	}
+	return vals, errs
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"path"
+	"strconv"
+	"strings"
+)
+
+/**
+ * Keys scanning
+ */
+
+const (
+	SCAN_DEFAULT_COUNT = 10
+	// cursor, which starts scanning and is returned when scanning is finished
+	SCAN_CURSOR_START = `0`
+)
+
+type scanQuery struct {
+	// position after which scanning is continued - <bucket>:<last returned key>
+	cursor string
+	// glob pattern for keys, empty one matches all keys
+	match string
+	// type of objects, TYPE_NULL matches all types
+	t uint8
+	// max count of keys returned
+	count int
+}
+
+// keys of one bucket after cursor key, done is set if there are no more keys in bucket
+type scanPage struct {
+	keys []string
+	done bool
+}
+
+// returns list with next cursor followed by page of keys. Keys are returned bucket by bucket in lexicographical
+// order, so each key existing during the whole scan is returned exactly once
+func (s *Storage) scan(req *innerRequest) (interface{}, error) {
+	q, ok := req.val.(*scanQuery)
+	if !ok {
+		return nil, &BadRequest{req, "Incoming object is not scan query"}
+	}
+	if _, err := path.Match(q.match, ``); err != nil {
+		return nil, &BadRequest{req, "Wrong match pattern: "+err.Error()}
+	}
+	if q.count <= 0 {
+		return nil, &BadRequest{req, "Count must be positive"}
+	}
+	bucket, last, err := parseScanCursor(q.cursor, s.bucketsNum)
+	if err != nil {
+		return nil, &BadRequest{req, err.Error()}
+	}
+	res := []string{SCAN_CURSOR_START}
+	for ; bucket < s.bucketsNum; bucket++ {
+		pageReq := s.newInnerRequest(OP_SCANBUCKET, ``, last, &scanQuery{match: q.match, t: q.t, count: q.count-len(res)+1}, 0)
+		pageReq.bucket = uint8(bucket)
+		v, err := s.doRequest(pageReq)
+		if err != nil { return nil, err }
+		page := v.(*scanPage)
+		res = append(res, page.keys...)
+		if !page.done {
+			res[0] = strconv.Itoa(bucket)+`:`+res[len(res)-1]
+			return res, nil
+		}
+		last = ``
+		if len(res) > q.count {
+			break
+		}
+	}
+	if bucket+1 < s.bucketsNum {
+		res[0] = strconv.Itoa(bucket+1)+`:`
+	}
+	return res, nil
+}
+
+// returns sorted keys of request bucket, which are greater than index and match query.
+// Bucket keeps its keys sorted, so page is read starting from cursor key without walking preceding keys
+func (s *Storage) scanbucket(req *innerRequest) (interface{}, error) {
+	q := req.val.(*scanQuery)
+	keys := make([]string, 0)
+	from, _ := s.buckets[req.bucket].keys.search(func(item interface{}) bool {
+		return item.(string) > req.idx
+	})
+	for n := from; n != nil; n = n.next() {
+		k := n.item.(string)
+		if q.match != `` {
+			if ok, _ := path.Match(q.match, k); !ok {
+				continue
+			}
+		}
+		if q.t != TYPE_NULL {
+			if m, ok := s.getKeyMeta(k); !ok || m.t != q.t {
+				continue
+			}
+		}
+		if len(keys) == q.count {
+			return &scanPage{keys, false}, nil
+		}
+		keys = append(keys, k)
+	}
+	return &scanPage{keys, true}, nil
+}
+
+func parseScanCursor(cursor string, bucketsNum int) (int, string, error) {
+	if cursor == `` || cursor == SCAN_CURSOR_START {
+		return 0, ``, nil
+	}
+	i := strings.Index(cursor, `:`)
+	if i < 0 {
+		return 0, ``, errors.New("Wrong cursor '"+cursor+"'")
+	}
+	bucket, err := strconv.Atoi(cursor[:i])
+	if err != nil || bucket < 0 || bucket >= bucketsNum {
+		return 0, ``, errors.New("Wrong cursor '"+cursor+"'")
+	}
+	return bucket, cursor[i+1:], nil
+}
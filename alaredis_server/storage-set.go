@@ -0,0 +1,192 @@
+package main
+
+/**
+ * Operations on set objects, stored as map[string]struct{}
+ */
+
+// adds members from incoming list to set, missing set is created. Returns count of added members
+func (s *Storage) sadd(req *innerRequest) (interface{}, error) {
+	k := req.key
+	m := req.meta
+	members, ok := req.val.([]string)
+	if !ok {
+		return nil, &BadRequest{req, "Incoming object is not list"}
+	}
+	if m.t != TYPE_NULL && m.t != TYPE_SET {
+		return nil, &BadRequest{req, "Stored object is not set"}
+	}
+	var set map[string]struct{}
+	if m.t == TYPE_SET {
+		setPtr, _ := s.buckets[req.bucket].get(k)
+		set = (*setPtr).(map[string]struct{})
+	} else {
+		if len(members) == 0 {
+			req.unchanged = true
+			return 0, nil
+		}
+		set = make(map[string]struct{}, len(members))
+		m.t = TYPE_SET
+		s.setKeyMeta(k, m)
+		s.buckets[req.bucket].set(k, set)
+	}
+	cnt := 0
+	for _, member := range members {
+		if _, ok := set[member]; !ok {
+			set[member] = struct{}{}
+			s.addSize(req, valueSize(member))
+			cnt++
+		}
+	}
+	req.unchanged = cnt == 0
+	return cnt, nil
+}
+
+// removes members from incoming list from set, set is deleted when its last member is removed.
+// Returns count of removed members
+func (s *Storage) srem(req *innerRequest) (interface{}, error) {
+	members, ok := req.val.([]string)
+	if !ok {
+		return nil, &BadRequest{req, "Incoming object is not list"}
+	}
+	set, err := s.getSet(req)
+	if err != nil { return nil, err }
+	cnt := 0
+	for _, member := range members {
+		if _, ok := set[member]; ok {
+			s.addSize(req, -valueSize(member))
+			delete(set, member)
+			cnt++
+		}
+	}
+	req.unchanged = cnt == 0
+	if len(set) == 0 {
+		s.delete(req)
+	}
+	return cnt, nil
+}
+
+// returns copy of set
+func (s *Storage) smembers(req *innerRequest) (interface{}, error) {
+	set, err := s.getSet(req)
+	if err != nil { return nil, err }
+	res := make(map[string]struct{}, len(set))
+	for member := range set {
+		res[member] = struct{}{}
+	}
+	return res, nil
+}
+
+// returns 1 if set contains member passed as index, 0 otherwise
+func (s *Storage) sismember(req *innerRequest) (interface{}, error) {
+	if req.meta.t == TYPE_NULL {
+		return 0, nil
+	}
+	set, err := s.getSet(req)
+	if err != nil { return nil, err }
+	if _, ok := set[req.idx]; !ok {
+		return 0, nil
+	}
+	return 1, nil
+}
+
+func (s *Storage) scard(req *innerRequest) (interface{}, error) {
+	set, err := s.getSet(req)
+	if err != nil { return nil, err }
+	return len(set), nil
+}
+
+// removes and returns random member of set, set is deleted when its last member is removed
+func (s *Storage) spop(req *innerRequest) (interface{}, error) {
+	set, err := s.getSet(req)
+	if err != nil { return nil, err }
+	if len(set) == 0 {
+		return nil, &BadRequest{req, "Set is empty"}
+	}
+	var member string
+	// map iteration order is random
+	for member = range set {
+		break
+	}
+	s.addSize(req, -valueSize(member))
+	delete(set, member)
+	if len(set) == 0 {
+		s.delete(req)
+	}
+	return member, nil
+}
+
+func (s *Storage) getSet(req *innerRequest) (map[string]struct{}, error) {
+	if req.meta.t == TYPE_NULL {
+		return nil, &ObjectNotFound{req}
+	} else if req.meta.t != TYPE_SET {
+		return nil, &BadRequest{req, "Stored object is not set"}
+	}
+	setPtr, _ := s.buckets[req.bucket].get(req.key)
+	return (*setPtr).(map[string]struct{}), nil
+}
+
+/**
+ * Set algebra, keys are request key and keys from incoming list
+ */
+
+func (s *Storage) sinter(req *innerRequest) (interface{}, error) {
+	sets, err := s.getSetsCopies(req)
+	if err != nil { return nil, err }
+	res := sets[0]
+	for _, set := range sets[1:] {
+		for member := range res {
+			if _, ok := set[member]; !ok {
+				delete(res, member)
+			}
+		}
+	}
+	return res, nil
+}
+
+func (s *Storage) sunion(req *innerRequest) (interface{}, error) {
+	sets, err := s.getSetsCopies(req)
+	if err != nil { return nil, err }
+	res := sets[0]
+	for _, set := range sets[1:] {
+		for member := range set {
+			res[member] = struct{}{}
+		}
+	}
+	return res, nil
+}
+
+func (s *Storage) sdiff(req *innerRequest) (interface{}, error) {
+	sets, err := s.getSetsCopies(req)
+	if err != nil { return nil, err }
+	res := sets[0]
+	for _, set := range sets[1:] {
+		for member := range set {
+			delete(res, member)
+		}
+	}
+	return res, nil
+}
+
+// requests copies of sets from their buckets, missing sets are treated as empty ones
+func (s *Storage) getSetsCopies(req *innerRequest) ([]map[string]struct{}, error) {
+	keys := []string{req.key}
+	if req.val != nil {
+		otherKeys, ok := req.val.([]string)
+		if !ok {
+			return nil, &BadRequest{req, "Incoming object is not list"}
+		}
+		keys = append(keys, otherKeys...)
+	}
+	sets := make([]map[string]struct{}, len(keys))
+	for i, k := range keys {
+		v, err := s.doRequest(s.newInnerRequest(OP_SMEMBERS, k, ``, nil, 0))
+		if err != nil {
+			if _, ok := err.(*ObjectNotFound); !ok {
+				return nil, err
+			}
+			v = map[string]struct{}{}
+		}
+		sets[i] = v.(map[string]struct{})
+	}
+	return sets, nil
+}
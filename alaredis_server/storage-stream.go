@@ -0,0 +1,425 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/**
+ * Operations on stream objects. Stream is append-only log of entries with increasing ids,
+ * consumer groups read it cooperatively and acknowledge processed entries
+ */
+
+// entry id is unix time in milliseconds and sequence number of entry added within the same millisecond
+type streamId struct {
+	ms  uint64
+	seq uint64
+}
+
+// entry fields are never changed after entry is added, so they are returned without copying
+type streamEntry struct {
+	id     streamId
+	fields map[string]string
+}
+
+type streamGroup struct {
+	// id of the last entry delivered to consumers of group
+	lastDelivered streamId
+	// entries delivered to consumers, but not acknowledged yet
+	pending map[streamId]*pendingEntry
+}
+
+type pendingEntry struct {
+	id          streamId
+	consumer    string
+	// unix time in milliseconds of the last delivery
+	deliveredAt int64
+	deliveries  int
+}
+
+type stream struct {
+	entries []streamEntry
+	// id of the last added entry, it is kept when entries are trimmed, so ids are never reused
+	lastId  streamId
+	groups  map[string]*streamGroup
+}
+
+func newStream() *stream {
+	st := new(stream)
+	st.entries = make([]streamEntry, 0, 8)
+	st.groups = make(map[string]*streamGroup)
+	return st
+}
+
+func (id streamId) less(other streamId) bool {
+	return id.ms < other.ms || (id.ms == other.ms && id.seq < other.seq)
+}
+
+func (id streamId) String() string {
+	return strconv.FormatUint(id.ms, 10)+`-`+strconv.FormatUint(id.seq, 10)
+}
+
+// parses id in form <ms>-<seq> or <ms>, which means the first entry of millisecond
+func parseStreamId(s string) (streamId, error) {
+	msStr, seqStr := s, ``
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		msStr, seqStr = s[:i], s[i+1:]
+	}
+	var id streamId
+	var err error
+	if id.ms, err = strconv.ParseUint(msStr, 10, 64); err != nil {
+		return id, err
+	}
+	if seqStr != `` {
+		id.seq, err = strconv.ParseUint(seqStr, 10, 64)
+	}
+	return id, err
+}
+
+// parses range bound, where '-' is the lowest id and '+' is the highest one. Bound without sequence number
+// includes all entries of millisecond
+func parseStreamBound(s string, end bool) (streamId, error) {
+	switch s {
+	case `-`:
+		return streamId{}, nil
+	case `+`:
+		return streamId{^uint64(0), ^uint64(0)}, nil
+	}
+	id, err := parseStreamId(s)
+	if err == nil && end && strings.IndexByte(s, '-') < 0 {
+		id.seq = ^uint64(0)
+	}
+	return id, err
+}
+
+// returns id for new entry, which is current time or the next id after the last one, if clock went back.
+// It fails when the last id is the max possible one
+func (st *stream) nextId() (streamId, error) {
+	ms := uint64(nowMillis())
+	if ms > st.lastId.ms {
+		return streamId{ms, 0}, nil
+	}
+	if st.lastId.seq < ^uint64(0) {
+		return streamId{st.lastId.ms, st.lastId.seq+1}, nil
+	}
+	if st.lastId.ms < ^uint64(0) {
+		return streamId{st.lastId.ms+1, 0}, nil
+	}
+	return streamId{}, errors.New("Stream has exhausted the last possible id")
+}
+
+// returns position of entry with id, or position where it should be inserted
+func (st *stream) search(id streamId) int {
+	return sort.Search(len(st.entries), func(i int) bool {
+		return !st.entries[i].id.less(id)
+	})
+}
+
+// removes the oldest entries, so stream has not more than maxLen ones. Returns removed entries
+func (st *stream) trim(maxLen int) []streamEntry {
+	cnt := len(st.entries)-maxLen
+	if cnt <= 0 {
+		return nil
+	}
+	removed := st.entries[:cnt]
+	st.entries = append(st.entries[:0:0], st.entries[cnt:]...)
+	return removed
+}
+
+// appends entry with fields from incoming dict, missing stream is created. Id is passed as index, empty one or '*'
+// means auto generated id, explicit id must be greater than ids of all entries. Optional arg limits stream length.
+// Returns id of added entry
+func (s *Storage) xadd(req *innerRequest) (interface{}, error) {
+	k := req.key
+	m := req.meta
+	fields, ok := req.val.(map[string]string)
+	if !ok {
+		return nil, &BadRequest{req, "Incoming object is not dict"}
+	}
+	if len(fields) == 0 {
+		return nil, &BadRequest{req, "Entry has no fields"}
+	}
+	if m.t != TYPE_NULL && m.t != TYPE_STREAM {
+		return nil, &BadRequest{req, "Stored object is not stream"}
+	}
+	maxLen := -1
+	if len(req.args) > 0 {
+		var err error
+		if maxLen, err = strconv.Atoi(req.args[0]); err != nil || maxLen < 0 {
+			return nil, &BadRequest{req, "Max length must be non negative integer"}
+		}
+	}
+	st := newStream()
+	if m.t == TYPE_STREAM {
+		stPtr, _ := s.buckets[req.bucket].get(k)
+		st = (*stPtr).(*stream)
+	}
+	var id streamId
+	var err error
+	if req.idx == `` || req.idx == `*` {
+		if id, err = st.nextId(); err != nil {
+			return nil, &BadRequest{req, err.Error()}
+		}
+	} else {
+		if id, err = parseStreamId(req.idx); err != nil {
+			return nil, &BadRequest{req, "Wrong entry id: "+err.Error()}
+		}
+		if !st.lastId.less(id) {
+			return nil, &BadRequest{req, "Entry id must be greater than "+st.lastId.String()}
+		}
+	}
+	st.entries = append(st.entries, streamEntry{id, fields})
+	st.lastId = id
+	size := streamEntriesSize(st.entries[len(st.entries)-1:])
+	if maxLen >= 0 {
+		size -= streamEntriesSize(st.trim(maxLen))
+	}
+	if m.t == TYPE_NULL {
+		m.t = TYPE_STREAM
+		s.setKeyMeta(k, m)
+		s.buckets[req.bucket].set(k, st)
+	}
+	s.addSize(req, size)
+	return id.String(), nil
+}
+
+// returns entries with ids from start (index) to end (first arg) inclusive, optional second arg limits count of entries
+func (s *Storage) xrange(req *innerRequest) (interface{}, error) {
+	return s.streamRange(req, false)
+}
+
+// returns entries with ids from end (index) to start (first arg) in reverse order, optional second arg limits count
+func (s *Storage) xrevrange(req *innerRequest) (interface{}, error) {
+	return s.streamRange(req, true)
+}
+
+func (s *Storage) streamRange(req *innerRequest, reverse bool) (interface{}, error) {
+	st, err := s.getStream(req)
+	if err != nil { return nil, err }
+	if len(req.args) < 1 {
+		return nil, &BadRequest{req, "Range end is not set"}
+	}
+	startStr, endStr := req.idx, req.args[0]
+	if reverse {
+		startStr, endStr = endStr, startStr
+	}
+	start, err := parseStreamBound(startStr, false)
+	if err != nil {
+		return nil, &BadRequest{req, "Wrong range start: "+err.Error()}
+	}
+	end, err := parseStreamBound(endStr, true)
+	if err != nil {
+		return nil, &BadRequest{req, "Wrong range end: "+err.Error()}
+	}
+	count := -1
+	if len(req.args) > 1 {
+		if count, err = strconv.Atoi(req.args[1]); err != nil {
+			return nil, &BadRequest{req, "Non integer count: "+err.Error()}
+		}
+	}
+	from := st.search(start)
+	to := sort.Search(len(st.entries), func(i int) bool {
+		return end.less(st.entries[i].id)
+	})
+	res := make([]streamEntry, 0)
+	for i := from; i < to && count != 0; i++ {
+		if reverse {
+			res = append(res, st.entries[from+to-1-i])
+		} else {
+			res = append(res, st.entries[i])
+		}
+		count--
+	}
+	return res, nil
+}
+
+func (s *Storage) xlen(req *innerRequest) (interface{}, error) {
+	st, err := s.getStream(req)
+	if err != nil { return nil, err }
+	return len(st.entries), nil
+}
+
+// removes the oldest entries, so stream has not more entries than index value. Returns count of removed entries
+func (s *Storage) xtrim(req *innerRequest) (interface{}, error) {
+	st, err := s.getStream(req)
+	if err != nil { return nil, err }
+	maxLen, err := strconv.Atoi(req.idx)
+	if err != nil || maxLen < 0 {
+		return nil, &BadRequest{req, "Max length must be non negative integer"}
+	}
+	removed := st.trim(maxLen)
+	s.addSize(req, -streamEntriesSize(removed))
+	req.unchanged = len(removed) == 0
+	return len(removed), nil
+}
+
+// creates consumer group passed as index, missing stream is created. Optional arg is id of the last entry,
+// which is considered delivered: '$' (default) means the last entry of stream, '0' means that group reads all entries
+func (s *Storage) xgroupcreate(req *innerRequest) (interface{}, error) {
+	k := req.key
+	m := req.meta
+	if m.t != TYPE_NULL && m.t != TYPE_STREAM {
+		return nil, &BadRequest{req, "Stored object is not stream"}
+	}
+	st := newStream()
+	if m.t == TYPE_STREAM {
+		stPtr, _ := s.buckets[req.bucket].get(k)
+		st = (*stPtr).(*stream)
+	}
+	if _, ok := st.groups[req.idx]; ok {
+		return nil, &BadRequest{req, "Stream already has group '"+req.idx+"'"}
+	}
+	lastDelivered := st.lastId
+	if len(req.args) > 0 && req.args[0] != `$` {
+		var err error
+		if lastDelivered, err = parseStreamId(req.args[0]); err != nil {
+			return nil, &BadRequest{req, "Wrong entry id: "+err.Error()}
+		}
+	}
+	st.groups[req.idx] = &streamGroup{lastDelivered, make(map[streamId]*pendingEntry)}
+	if m.t == TYPE_NULL {
+		m.t = TYPE_STREAM
+		s.setKeyMeta(k, m)
+		s.buckets[req.bucket].set(k, st)
+	}
+	s.addSize(req, streamGroupSize(req.idx))
+	return nil, nil
+}
+
+// delivers entries, which were not delivered to group (index) yet, to consumer (first arg). Optional second arg
+// limits count of entries. Delivered entries are pending, until they are acknowledged.
+// If optional third arg is entry id instead of '>', entries pending for consumer after that id are delivered again
+func (s *Storage) xreadgroup(req *innerRequest) (interface{}, error) {
+	st, err := s.getStream(req)
+	if err != nil { return nil, err }
+	g, err := getStreamGroup(req, st)
+	if err != nil { return nil, err }
+	if len(req.args) < 1 || req.args[0] == `` {
+		return nil, &BadRequest{req, "Consumer is not set"}
+	}
+	consumer := req.args[0]
+	count := -1
+	if len(req.args) > 1 {
+		if count, err = strconv.Atoi(req.args[1]); err != nil {
+			return nil, &BadRequest{req, "Non integer count: "+err.Error()}
+		}
+	}
+	if len(req.args) > 2 && req.args[2] != `>` {
+		after, err := parseStreamId(req.args[2])
+		if err != nil {
+			return nil, &BadRequest{req, "Wrong entry id: "+err.Error()}
+		}
+		return st.redeliver(g, consumer, after, count), nil
+	}
+	now := nowMillis()
+	res := make([]streamEntry, 0)
+	i := sort.Search(len(st.entries), func(i int) bool {
+		return g.lastDelivered.less(st.entries[i].id)
+	})
+	for ; i < len(st.entries) && count != 0; i++ {
+		e := st.entries[i]
+		g.pending[e.id] = &pendingEntry{e.id, consumer, now, 1}
+		g.lastDelivered = e.id
+		res = append(res, e)
+		count--
+	}
+	s.addSize(req, int64(len(res))*PENDING_ENTRY_SIZE)
+	req.unchanged = len(res) == 0
+	return res, nil
+}
+
+// delivers entries pending for consumer with ids greater than passed one again. Trimmed entries are
+// delivered without fields, so consumer could acknowledge them
+func (st *stream) redeliver(g *streamGroup, consumer string, after streamId, count int) []streamEntry {
+	ids := make([]streamId, 0)
+	for id, p := range g.pending {
+		if p.consumer == consumer && after.less(id) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].less(ids[j])
+	})
+	if count >= 0 && count < len(ids) {
+		ids = ids[:count]
+	}
+	now := nowMillis()
+	res := make([]streamEntry, 0, len(ids))
+	for _, id := range ids {
+		p := g.pending[id]
+		p.deliveredAt = now
+		p.deliveries++
+		e := streamEntry{id: id}
+		if i := st.search(id); i < len(st.entries) && st.entries[i].id == id {
+			e = st.entries[i]
+		}
+		res = append(res, e)
+	}
+	return res
+}
+
+// acknowledges entries with ids from incoming list for group passed as index. Returns count of entries,
+// which were pending
+func (s *Storage) xack(req *innerRequest) (interface{}, error) {
+	ids, ok := req.val.([]string)
+	if !ok {
+		return nil, &BadRequest{req, "Incoming object is not list"}
+	}
+	st, err := s.getStream(req)
+	if err != nil { return nil, err }
+	g, err := getStreamGroup(req, st)
+	if err != nil { return nil, err }
+	cnt := 0
+	for _, idStr := range ids {
+		id, err := parseStreamId(idStr)
+		if err != nil {
+			return nil, &BadRequest{req, "Wrong entry id '"+idStr+"': "+err.Error()}
+		}
+		if _, ok := g.pending[id]; ok {
+			delete(g.pending, id)
+			cnt++
+		}
+	}
+	s.addSize(req, -int64(cnt)*PENDING_ENTRY_SIZE)
+	req.unchanged = cnt == 0
+	return cnt, nil
+}
+
+// returns entries pending in group passed as index ordered by id, optional arg filters them by consumer
+func (s *Storage) xpending(req *innerRequest) (interface{}, error) {
+	st, err := s.getStream(req)
+	if err != nil { return nil, err }
+	g, err := getStreamGroup(req, st)
+	if err != nil { return nil, err }
+	res := make([]pendingEntry, 0, len(g.pending))
+	for _, p := range g.pending {
+		if len(req.args) > 0 && req.args[0] != `` && p.consumer != req.args[0] {
+			continue
+		}
+		res = append(res, *p)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].id.less(res[j].id)
+	})
+	return res, nil
+}
+
+func (s *Storage) getStream(req *innerRequest) (*stream, error) {
+	if req.meta.t == TYPE_NULL {
+		return nil, &ObjectNotFound{req}
+	} else if req.meta.t != TYPE_STREAM {
+		return nil, &BadRequest{req, "Stored object is not stream"}
+	}
+	stPtr, _ := s.buckets[req.bucket].get(req.key)
+	return (*stPtr).(*stream), nil
+}
+
+func getStreamGroup(req *innerRequest, st *stream) (*streamGroup, error) {
+	g, ok := st.groups[req.idx]
+	if !ok {
+		return nil, &BadRequest{req, "Stream does not contain group '"+req.idx+"'"}
+	}
+	return g, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"sort"
+)
+
+/**
+ * Transactions
+ */
+
+// ordered batch of requests, which are performed atomically
+type transaction struct {
+	// requests with versions of watched keys, nothing is performed if any of them does not match
+	watch []*innerRequest
+	reqs  []*innerRequest
+}
+
+// performs requests of transaction, while all buckets of their keys are held.
+// Returns list with result of each request, which is either value or error.
+// Failed request does not stop transaction and does not roll back previous ones
+func (s *Storage) exec(req *innerRequest) (interface{}, error) {
+	tx, ok := req.val.(*transaction)
+	if !ok {
+		return nil, &BadRequest{req, "Incoming object is not transaction"}
+	}
+	buckets := make(map[uint8]struct{})
+	for _, r := range tx.reqs {
+		if _, ok := s.multiKeyHandlers[r.op]; ok || s.opHandlers[r.op] == nil || BLOCKING_OPERATIONS[r.op] {
+			return nil, &BadRequest{req, "Operation is not supported in transaction"}
+		}
+		buckets[r.bucket] = struct{}{}
+	}
+	for _, r := range tx.watch {
+		buckets[r.bucket] = struct{}{}
+	}
+
+	release := s.holdBuckets(buckets)
+	defer release()
+
+	for _, r := range tx.watch {
+		if m, ok := s.getKeyMeta(r.key); ok {
+			r.meta = m
+		} else {
+			r.meta = newKeyMeta(r.key)
+		}
+		if r.meta.version != r.ifVersion {
+			r.version = r.meta.version
+			return nil, &VersionMismatch{r}
+		}
+	}
+	res := make([]interface{}, len(tx.reqs))
+	for i, r := range tx.reqs {
+		val, err := s.handleInnerRequest(r, s.opHandlers[r.op])
+		if err != nil {
+			res[i] = err
+		} else {
+			res[i] = val
+		}
+	}
+	return res, nil
+}
+
+// blocks workers of buckets until returned function is called. Buckets are held in ascending order,
+// so concurrent transactions can not wait for each other forever
+func (s *Storage) holdBuckets(buckets map[uint8]struct{}) func() {
+	ids := make([]int, 0, len(buckets))
+	for b := range buckets {
+		ids = append(ids, int(b))
+	}
+	sort.Ints(ids)
+	releaseChan := make(chan struct{})
+	for _, b := range ids {
+		hold := new(innerRequest)
+		hold.op = OP_HOLD
+		hold.bucket = uint8(b)
+		hold.val = releaseChan
+		hold.outCh = make(chan interface{}, 1)
+		s.buckets[b].requestChan <- hold
+		<-hold.outCh
+	}
+	return func() {
+		close(releaseChan)
+	}
+}
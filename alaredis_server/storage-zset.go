@@ -0,0 +1,299 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"math"
+)
+
+/**
+ * Operations on sorted set objects
+ */
+
+// sorted set keeps members ordered by score, members with equal scores are ordered lexicographically
+type zset struct {
+	scores map[string]float64
+	items  *skiplist
+}
+
+type zsetItem struct {
+	member string
+	score  float64
+}
+
+func newZSet() *zset {
+	z := new(zset)
+	z.scores = make(map[string]float64)
+	z.items = newSkiplist(func(a, b interface{}) bool {
+		return a.(zsetItem).less(b.(zsetItem))
+	})
+	return z
+}
+
+func (i zsetItem) less(j zsetItem) bool {
+	return i.score < j.score || (i.score == j.score && i.member < j.member)
+}
+
+// adds member or updates its score, returns true if member is new
+func (z *zset) add(member string, score float64) bool {
+	cur, exists := z.scores[member]
+	if exists {
+		if cur == score {
+			return false
+		}
+		z.remove(member)
+	}
+	z.items.insert(zsetItem{member, score})
+	z.scores[member] = score
+	return !exists
+}
+
+func (z *zset) remove(member string) bool {
+	score, ok := z.scores[member]
+	if !ok {
+		return false
+	}
+	z.items.remove(zsetItem{member, score})
+	delete(z.scores, member)
+	return true
+}
+
+func (z *zset) rank(member string) (int, bool) {
+	score, ok := z.scores[member]
+	if !ok {
+		return 0, false
+	}
+	item := zsetItem{member, score}
+	_, rank := z.items.search(func(i interface{}) bool {
+		return !i.(zsetItem).less(item)
+	})
+	return rank, true
+}
+
+func (z *zset) len() int {
+	return z.items.length
+}
+
+// adds members with scores from incoming dict, missing sorted set is created. Returns count of added members
+func (s *Storage) zadd(req *innerRequest) (interface{}, error) {
+	k := req.key
+	m := req.meta
+	v, ok := req.val.(map[string]string)
+	if !ok {
+		return nil, &BadRequest{req, "Incoming object is not dict"}
+	}
+	if m.t != TYPE_NULL && m.t != TYPE_ZSET {
+		return nil, &BadRequest{req, "Stored object is not sorted set"}
+	}
+	scores := make(map[string]float64, len(v))
+	for member, scoreStr := range v {
+		score, err := parseScore(scoreStr)
+		if err != nil {
+			return nil, &BadRequest{req, "Non float score for member '"+member+"'"}
+		}
+		scores[member] = score
+	}
+	var z *zset
+	if m.t == TYPE_ZSET {
+		zPtr, _ := s.buckets[req.bucket].get(k)
+		z = (*zPtr).(*zset)
+	} else {
+		if len(scores) == 0 {
+			req.unchanged = true
+			return 0, nil
+		}
+		z = newZSet()
+		m.t = TYPE_ZSET
+		s.setKeyMeta(k, m)
+		s.buckets[req.bucket].set(k, z)
+	}
+	cnt := 0
+	changed := false
+	for member, score := range scores {
+		if cur, ok := z.scores[member]; !ok || cur != score {
+			changed = true
+		}
+		if z.add(member, score) {
+			s.addSize(req, zsetMemberSize(member))
+			cnt++
+		}
+	}
+	req.unchanged = !changed
+	return cnt, nil
+}
+
+// increments score of member from incoming string by index value, missing member gets index value as score.
+// Returns new score
+func (s *Storage) zincrby(req *innerRequest) (interface{}, error) {
+	k := req.key
+	m := req.meta
+	member, ok := req.val.(string)
+	if !ok {
+		return nil, &BadRequest{req, "Incoming object is not string"}
+	}
+	delta, err := parseScore(req.idx)
+	if err != nil {
+		return nil, &BadRequest{req, "Non float index: "+err.Error()}
+	}
+	if m.t != TYPE_NULL && m.t != TYPE_ZSET {
+		return nil, &BadRequest{req, "Stored object is not sorted set"}
+	}
+	var z *zset
+	if m.t == TYPE_ZSET {
+		zPtr, _ := s.buckets[req.bucket].get(k)
+		z = (*zPtr).(*zset)
+	} else {
+		z = newZSet()
+	}
+	score := z.scores[member]+delta
+	if math.IsNaN(score) {
+		return nil, &BadRequest{req, "Increment would produce NaN"}
+	}
+	added := z.add(member, score)
+	if m.t == TYPE_NULL {
+		m.t = TYPE_ZSET
+		s.setKeyMeta(k, m)
+		s.buckets[req.bucket].set(k, z)
+	}
+	if added {
+		s.addSize(req, zsetMemberSize(member))
+	}
+	return formatScore(score), nil
+}
+
+// removes members from incoming list, sorted set is deleted when its last member is removed.
+// Returns count of removed members
+func (s *Storage) zrem(req *innerRequest) (interface{}, error) {
+	members, ok := req.val.([]string)
+	if !ok {
+		return nil, &BadRequest{req, "Incoming object is not list"}
+	}
+	z, err := s.getZSet(req)
+	if err != nil { return nil, err }
+	cnt := 0
+	for _, member := range members {
+		if z.remove(member) {
+			s.addSize(req, -zsetMemberSize(member))
+			cnt++
+		}
+	}
+	req.unchanged = cnt == 0
+	if z.len() == 0 {
+		s.delete(req)
+	}
+	return cnt, nil
+}
+
+// returns score of member passed as index
+func (s *Storage) zscore(req *innerRequest) (interface{}, error) {
+	z, err := s.getZSet(req)
+	if err != nil { return nil, err }
+	score, ok := z.scores[req.idx]
+	if !ok {
+		return nil, &BadRequest{req, "Sorted set does not contain member '"+req.idx+"'"}
+	}
+	return formatScore(score), nil
+}
+
+// returns position of member passed as index, members are ordered from the lowest score
+func (s *Storage) zrank(req *innerRequest) (interface{}, error) {
+	z, err := s.getZSet(req)
+	if err != nil { return nil, err }
+	rank, ok := z.rank(req.idx)
+	if !ok {
+		return nil, &BadRequest{req, "Sorted set does not contain member '"+req.idx+"'"}
+	}
+	return rank, nil
+}
+
+func (s *Storage) zcard(req *innerRequest) (interface{}, error) {
+	z, err := s.getZSet(req)
+	if err != nil { return nil, err }
+	return z.len(), nil
+}
+
+// returns members from start to stop position inclusive, negative positions are counted from the end
+func (s *Storage) zrange(req *innerRequest) (interface{}, error) {
+	z, err := s.getZSet(req)
+	if err != nil { return nil, err }
+	start, stop, err := listRange(req, z.len())
+	if err != nil { return nil, err }
+	res := make([]string, 0, stop-start)
+	for n := z.items.at(start); len(res) < stop-start; n = n.next() {
+		res = append(res, n.item.(zsetItem).member)
+	}
+	return res, nil
+}
+
+// returns members with scores between min (index) and max (first arg), optional offset and count args
+// limit result. Scores prefixed with '(' are exclusive, -inf and +inf are supported
+func (s *Storage) zrangebyscore(req *innerRequest) (interface{}, error) {
+	z, err := s.getZSet(req)
+	if err != nil { return nil, err }
+	if len(req.args) < 1 {
+		return nil, &BadRequest{req, "Max score is not set"}
+	}
+	min, minExclusive, err := parseScoreBound(req.idx)
+	if err != nil {
+		return nil, &BadRequest{req, "Non float min score: "+err.Error()}
+	}
+	max, maxExclusive, err := parseScoreBound(req.args[0])
+	if err != nil {
+		return nil, &BadRequest{req, "Non float max score: "+err.Error()}
+	}
+	offset, count := 0, -1
+	if len(req.args) > 1 {
+		if len(req.args) < 3 {
+			return nil, &BadRequest{req, "Both offset and count must be set"}
+		}
+		if offset, err = strconv.Atoi(req.args[1]); err != nil || offset < 0 {
+			return nil, &BadRequest{req, "Offset must be non negative integer"}
+		}
+		if count, err = strconv.Atoi(req.args[2]); err != nil {
+			return nil, &BadRequest{req, "Non integer count: "+err.Error()}
+		}
+	}
+	_, i := z.items.search(func(item interface{}) bool {
+		score := item.(zsetItem).score
+		return score > min || (!minExclusive && score == min)
+	})
+	res := make([]string, 0)
+	for n := z.items.at(i+offset); n != nil && count != 0; n = n.next() {
+		item := n.item.(zsetItem)
+		if item.score > max || (maxExclusive && item.score == max) { break }
+		res = append(res, item.member)
+		count--
+	}
+	return res, nil
+}
+
+func (s *Storage) getZSet(req *innerRequest) (*zset, error) {
+	if req.meta.t == TYPE_NULL {
+		return nil, &ObjectNotFound{req}
+	} else if req.meta.t != TYPE_ZSET {
+		return nil, &BadRequest{req, "Stored object is not sorted set"}
+	}
+	zPtr, _ := s.buckets[req.bucket].get(req.key)
+	return (*zPtr).(*zset), nil
+}
+
+func parseScore(s string) (float64, error) {
+	score, err := strconv.ParseFloat(s, 64)
+	if err == nil && math.IsNaN(score) {
+		return 0, strconv.ErrSyntax
+	}
+	return score, err
+}
+
+func parseScoreBound(s string) (float64, bool, error) {
+	exclusive := strings.HasPrefix(s, `(`)
+	if exclusive {
+		s = s[1:]
+	}
+	score, err := parseScore(s)
+	return score, exclusive, err
+}
+
+func formatScore(score float64) string {
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
@@ -3,8 +3,11 @@ package main
 import (
 	"hash/crc32"
 	"sync"
+	"sync/atomic"
 	"log"
 	"strconv"
+	"math"
+	"fmt"
 )
 
 const (
@@ -12,8 +15,24 @@ const (
 	TYPE_STRING
 	TYPE_LIST
 	TYPE_DICT
+	TYPE_SET
+	TYPE_ZSET
+	TYPE_STREAM
+	TYPE_BYTES
+	TYPE_JSON
 )
 
+var TYPE_NAMES = map[uint8]string {
+	TYPE_STRING: `string`,
+	TYPE_LIST: `list`,
+	TYPE_DICT: `dict`,
+	TYPE_SET: `set`,
+	TYPE_ZSET: `zset`,
+	TYPE_STREAM: `stream`,
+	TYPE_BYTES: `bytes`,
+	TYPE_JSON: `json`,
+}
+
 const (
 	OP_DELETE = iota
 	OP_SET
@@ -27,8 +46,139 @@ const (
 	OP_DGET
 	OP_DGETI
 	OP_DKEYS
+	OP_EXPIRE
+	OP_ADD
+	OP_REPLACE
+	OP_TOUCH
+	OP_INCRBY
+	OP_INCR
+	OP_DECR
+	OP_INCRBYFLOAT
+	OP_LPUSH
+	OP_RPUSH
+	OP_LPOP
+	OP_RPOP
+	OP_LLEN
+	OP_LRANGE
+	OP_LTRIM
+	OP_LINSERT
+	OP_LREM
+	OP_LPOS
+	OP_DDELI
+	OP_DLEN
+	OP_DEXISTS
+	OP_DVALS
+	OP_DMGET
+	OP_DMSET
+	OP_SADD
+	OP_SREM
+	OP_SMEMBERS
+	OP_SISMEMBER
+	OP_SCARD
+	OP_SPOP
+	OP_SINTER
+	OP_SUNION
+	OP_SDIFF
+	OP_ZADD
+	OP_ZINCRBY
+	OP_ZREM
+	OP_ZSCORE
+	OP_ZRANK
+	OP_ZCARD
+	OP_ZRANGE
+	OP_ZRANGEBYSCORE
+	OP_EXEC
+	OP_MGET
+	OP_MSET
+	OP_MDEL
+	OP_SCAN
+	OP_DBSIZE
+	OP_TTL
+	OP_EXPIREAT
+	OP_PERSIST
+	OP_PTTL
+	OP_PUBLISH
+	OP_BLPOP
+	OP_BRPOP
+	OP_XADD
+	OP_XRANGE
+	OP_XREVRANGE
+	OP_XLEN
+	OP_XTRIM
+	OP_XGROUPCREATE
+	OP_XREADGROUP
+	OP_XACK
+	OP_XPENDING
+	OP_BSET
+	OP_BGET
+	OP_JSET
+	OP_JGET
+	OP_JDEL
+	OP_JARRAPPEND
+	OP_JNUMINCRBY
+	// internal operation, sets object of any type, used by persister
+	OP_RESTORE
+	// internal operation, blocks bucket worker while transaction is performed
+	OP_HOLD
+	// internal operation, returns page of keys of one bucket for scan
+	OP_SCANBUCKET
+	// internal operation, removes expired key
+	OP_EXPIRED
+	// internal operation, removes expired dict field or list item
+	OP_EXPIREDI
+	// internal operation, removes parked blocking request
+	OP_UNBLOCK
 )
 
+// operations, which do not change stored value, so they do not change its version
+var READ_OPERATIONS = map[int]bool {
+	OP_GET: true,
+	OP_LGET: true,
+	OP_LGETI: true,
+	OP_DGET: true,
+	OP_DGETI: true,
+	OP_DKEYS: true,
+	OP_EXPIRE: true,
+	OP_EXPIREAT: true,
+	OP_PERSIST: true,
+	OP_TOUCH: true,
+	OP_LLEN: true,
+	OP_LRANGE: true,
+	OP_LPOS: true,
+	OP_DLEN: true,
+	OP_DEXISTS: true,
+	OP_DVALS: true,
+	OP_DMGET: true,
+	OP_SMEMBERS: true,
+	OP_SISMEMBER: true,
+	OP_SCARD: true,
+	OP_SINTER: true,
+	OP_SUNION: true,
+	OP_SDIFF: true,
+	OP_ZSCORE: true,
+	OP_ZRANK: true,
+	OP_ZCARD: true,
+	OP_ZRANGE: true,
+	OP_ZRANGEBYSCORE: true,
+	OP_MGET: true,
+	OP_SCAN: true,
+	OP_SCANBUCKET: true,
+	OP_DBSIZE: true,
+	OP_TTL: true,
+	OP_PTTL: true,
+	OP_XRANGE: true,
+	OP_XREVRANGE: true,
+	OP_XLEN: true,
+	OP_XPENDING: true,
+	OP_BGET: true,
+	OP_JGET: true,
+	// message is sent to channel subscribers and is not stored
+	OP_PUBLISH: true,
+	OP_UNBLOCK: true,
+	// removes key or does nothing, so version of existing key is not changed
+	OP_EXPIRED: true,
+}
+
 
 type Storage struct {
 	bucketsNum  int
@@ -37,6 +187,18 @@ type Storage struct {
 	metaLock    sync.RWMutex
 	requestChan chan *innerRequest
 	ttlMonitor  *ttlMonitor
+	stopChan    chan struct{}
+	// operations on single key, performed by bucket workers
+	opHandlers map[int]func(req *innerRequest) (interface{}, error)
+	// operations on several keys, on the whole keyspace and pub/sub operations, which are performed outside of bucket workers
+	multiKeyHandlers map[int]func(req *innerRequest) (interface{}, error)
+	// last version assigned to changed object, versions are unique across all keys
+	lastVersion uint64
+	// memory limit in bytes, zero means no limit
+	maxMemory int64
+	evictionPolicy int
+	events *eventNotifier
+	pubsub *pubSub
 }
 
 type innerRequest struct {
@@ -45,13 +207,33 @@ type innerRequest struct {
 	meta    *keyMeta
 	bucket  uint8
 	idx     string
+	args    []string
+	// in milliseconds
 	ttl     int64
 	val     interface{}
+	// unix time in milliseconds, when fields of restored object expire
+	fieldExpireAt map[string]int64
+	// if set, request is performed only when stored object version is equal to ifVersion,
+	// zero version means that object must not exist
+	checkVersion bool
+	ifVersion    uint64
+	// if set, request fails for missing object instead of creating it
+	mustExist bool
+	// set by handler, if operation succeeded without changing anything, so version is kept and no event is sent
+	unchanged bool
+	// version of object captured by bucket worker after request, so it is read after reply without racing
+	// with later requests changing shared key meta
+	version uint64
 	outCh   chan interface{}
 	errChan chan error
 }
 
 func NewStorage(bucketsNum int) *Storage {
+	return newStorage(bucketsNum, newPubSub())
+}
+
+// creates storage with pub/sub channels, which could be shared with other storages
+func newStorage(bucketsNum int, pubsub *pubSub) *Storage {
 	s := new(Storage)
 	s.bucketsNum = bucketsNum
 	s.buckets = make([]*StorageBucket, s.bucketsNum, s.bucketsNum)
@@ -61,7 +243,22 @@ func NewStorage(bucketsNum int) *Storage {
 	s.keyMetaMap = make(map[string]*keyMeta)
 	s.requestChan = make(chan *innerRequest)
 	s.metaLock = sync.RWMutex{}
-	s.ttlMonitor = newTTLMonitor(s.bucketsNum*2, s.onKeyExpire)
+	s.ttlMonitor = newTTLMonitor(s.bucketsNum*2, s.onKeyExpire, s.onFieldExpire)
+	s.stopChan = make(chan struct{})
+	s.events = newEventNotifier()
+	s.pubsub = pubsub
+	s.multiKeyHandlers = map[int]func(req *innerRequest) (interface{}, error){
+		OP_SINTER: s.sinter,
+		OP_SUNION: s.sunion,
+		OP_SDIFF: s.sdiff,
+		OP_EXEC: s.exec,
+		OP_MGET: s.mget,
+		OP_MSET: s.mset,
+		OP_MDEL: s.mdel,
+		OP_SCAN: s.scan,
+		OP_DBSIZE: s.dbsize,
+		OP_PUBLISH: s.publish,
+	}
 	return s
 }
 
@@ -76,7 +273,8 @@ func (s *Storage) newInnerRequest(op int, key string, idx string, val interface{
 	req.meta = m
 	req.idx = idx
 	req.val = val
-	req.ttl = ttl
+	// ttl is passed in seconds, request keeps it in milliseconds
+	req.ttl = ttl*1000
 	req.bucket = uint8(m.hash%uint32(s.bucketsNum))
 	req.outCh = make(chan interface{}, 1)
 	req.errChan = make(chan error, 1)
@@ -85,7 +283,7 @@ func (s *Storage) newInnerRequest(op int, key string, idx string, val interface{
 
 func (s *Storage) run() {
 
-	opHandlers := make([]func(req *innerRequest) (interface{}, error), len(OPERATIONS))
+	opHandlers := make(map[int]func(req *innerRequest) (interface{}, error))
 	opHandlers[OP_DELETE] = s.delete
 	opHandlers[OP_SET] = s.set
 	opHandlers[OP_GET] = s.get
@@ -98,6 +296,72 @@ func (s *Storage) run() {
 	opHandlers[OP_DGET] = s.dget
 	opHandlers[OP_DGETI] = s.dgeti
 	opHandlers[OP_DKEYS] = s.dkeys
+	opHandlers[OP_EXPIRE] = s.expire
+	opHandlers[OP_ADD] = s.add
+	opHandlers[OP_REPLACE] = s.replace
+	opHandlers[OP_TOUCH] = s.touch
+	opHandlers[OP_INCRBY] = s.incrby
+	opHandlers[OP_INCR] = s.incr
+	opHandlers[OP_DECR] = s.decr
+	opHandlers[OP_INCRBYFLOAT] = s.incrbyfloat
+	opHandlers[OP_LPUSH] = s.lpush
+	opHandlers[OP_RPUSH] = s.rpush
+	opHandlers[OP_LPOP] = s.lpop
+	opHandlers[OP_RPOP] = s.rpop
+	opHandlers[OP_LLEN] = s.llen
+	opHandlers[OP_LRANGE] = s.lrange
+	opHandlers[OP_LTRIM] = s.ltrim
+	opHandlers[OP_LINSERT] = s.linsert
+	opHandlers[OP_LREM] = s.lrem
+	opHandlers[OP_LPOS] = s.lpos
+	opHandlers[OP_DDELI] = s.ddeli
+	opHandlers[OP_DLEN] = s.dlen
+	opHandlers[OP_DEXISTS] = s.dexists
+	opHandlers[OP_DVALS] = s.dvals
+	opHandlers[OP_DMGET] = s.dmget
+	opHandlers[OP_DMSET] = s.dmset
+	opHandlers[OP_SADD] = s.sadd
+	opHandlers[OP_SREM] = s.srem
+	opHandlers[OP_SMEMBERS] = s.smembers
+	opHandlers[OP_SISMEMBER] = s.sismember
+	opHandlers[OP_SCARD] = s.scard
+	opHandlers[OP_SPOP] = s.spop
+	opHandlers[OP_ZADD] = s.zadd
+	opHandlers[OP_ZINCRBY] = s.zincrby
+	opHandlers[OP_ZREM] = s.zrem
+	opHandlers[OP_ZSCORE] = s.zscore
+	opHandlers[OP_ZRANK] = s.zrank
+	opHandlers[OP_ZCARD] = s.zcard
+	opHandlers[OP_ZRANGE] = s.zrange
+	opHandlers[OP_ZRANGEBYSCORE] = s.zrangebyscore
+	opHandlers[OP_XADD] = s.xadd
+	opHandlers[OP_XRANGE] = s.xrange
+	opHandlers[OP_XREVRANGE] = s.xrevrange
+	opHandlers[OP_XLEN] = s.xlen
+	opHandlers[OP_XTRIM] = s.xtrim
+	opHandlers[OP_XGROUPCREATE] = s.xgroupcreate
+	opHandlers[OP_XREADGROUP] = s.xreadgroup
+	opHandlers[OP_XACK] = s.xack
+	opHandlers[OP_XPENDING] = s.xpending
+	opHandlers[OP_BSET] = s.bset
+	opHandlers[OP_BGET] = s.bget
+	opHandlers[OP_JSET] = s.jset
+	opHandlers[OP_JGET] = s.jget
+	opHandlers[OP_JDEL] = s.jdel
+	opHandlers[OP_JARRAPPEND] = s.jarrappend
+	opHandlers[OP_JNUMINCRBY] = s.jnumincrby
+	opHandlers[OP_RESTORE] = s.restore
+	opHandlers[OP_SCANBUCKET] = s.scanbucket
+	opHandlers[OP_EXPIRED] = s.expired
+	opHandlers[OP_EXPIREDI] = s.expiredi
+	opHandlers[OP_BLPOP] = s.blpop
+	opHandlers[OP_BRPOP] = s.brpop
+	opHandlers[OP_UNBLOCK] = s.unblock
+	opHandlers[OP_TTL] = s.ttl
+	opHandlers[OP_PTTL] = s.pttl
+	opHandlers[OP_EXPIREAT] = s.expireat
+	opHandlers[OP_PERSIST] = s.persistKey
+	s.opHandlers = opHandlers
 
 	// starting workers, processing requests, one per bucket
 	for i, b := range s.buckets {
@@ -107,12 +371,24 @@ func (s *Storage) run() {
 			for {
 				select {
 				case req := <-bucket.requestChan:
-					val, err := opHandlers[req.op](req)
+					if req.op == OP_HOLD {
+						// bucket is held by transaction, until it is released
+						req.outCh <- nil
+						<-req.val.(chan struct{})
+						continue
+					}
+					val, err := s.handleInnerRequest(req, opHandlers[req.op])
+					if err == errRequestBlocked {
+						// request is replied, when its value is pushed
+						continue
+					}
 					if err == nil {
 						req.outCh <- val
 					} else {
 						req.errChan <- err
 					}
+				case <-s.stopChan:
+					return
 				}
 			}
 		} ()
@@ -121,12 +397,94 @@ func (s *Storage) run() {
 	s.ttlMonitor.run()
 }
 
+// performs request inside of bucket worker
+func (s *Storage) handleInnerRequest(req *innerRequest, handler func(req *innerRequest) (interface{}, error)) (interface{}, error) {
+	// key meta could be changed by other requests after this one was created
+	if m, ok := s.getKeyMeta(req.key); ok {
+		req.meta = m
+	} else if req.meta.t != TYPE_NULL {
+		req.meta = newKeyMeta(req.key)
+	}
+	// ttl monitor removes expired keys asynchronously, so key could be expired but still not removed
+	if req.meta.expireAt > 0 && req.meta.expireAt <= nowMillis() {
+		s.delete(req)
+		s.events.notify(req.key, EVENT_EXPIRED, ``)
+		req.meta = newKeyMeta(req.key)
+	}
+	if len(req.meta.fieldExpireAt) > 0 {
+		s.deleteExpiredFields(req)
+	}
+	if req.checkVersion && req.meta.version != req.ifVersion {
+		req.version = req.meta.version
+		return nil, &VersionMismatch{req}
+	}
+	if req.mustExist && req.meta.t == TYPE_NULL {
+		return nil, &ObjectNotFound{req}
+	}
+	if s.maxMemory > 0 && GROWING_OPERATIONS[req.op] && !s.evict(req) {
+		return nil, &OutOfMemory{req}
+	}
+	val, err := handler(req)
+	if req.meta.t != TYPE_NULL {
+		req.meta.touchAccess()
+	}
+	if err == nil && !READ_OPERATIONS[req.op] && !req.unchanged {
+		req.meta.version = atomic.AddUint64(&s.lastVersion, 1)
+	}
+	req.version = req.meta.version
+	// internal operations notify about their changes themselves
+	if err == nil && (!READ_OPERATIONS[req.op] || EXPIRATION_OPERATIONS[req.op]) && !req.unchanged && req.op < OP_RESTORE {
+		s.events.notify(req.key, eventOpNames[req.op], ``)
+	}
+	if err == nil && len(s.buckets[req.bucket].waiters[req.key]) > 0 {
+		s.serveWaiters(req)
+	}
+	return val, err
+}
+
+// stops bucket workers
+func (s *Storage) stop() {
+	close(s.stopChan)
+}
+
 func (s *Storage) processInnerRequest(req *innerRequest) {
+	if h, ok := s.multiKeyHandlers[req.op]; ok {
+		// such operation makes requests to other buckets itself, so it can not block bucket worker
+		go func() {
+			val, err := h(req)
+			if err == nil {
+				req.outCh <- val
+			} else {
+				req.errChan <- err
+			}
+		}()
+		return
+	}
 	s.buckets[req.bucket].requestChan <- req
 }
 
+// sends request to bucket worker and waits for its result
+func (s *Storage) doRequest(req *innerRequest) (interface{}, error) {
+	s.processInnerRequest(req)
+	select {
+	case val := <-req.outCh:
+		return val, nil
+	case err := <-req.errChan:
+		return nil, err
+	}
+}
+
 func (s *Storage) onKeyExpire(m *keyMeta) {
-	s.processInnerRequest(s.newInnerRequest(OP_DELETE, m.key, ``, nil, 0))
+	s.processInnerRequest(s.newInnerRequest(OP_EXPIRED, m.key, ``, m, 0))
+}
+
+// sends message from body to subscribers of channel passed as key, returns count of receivers
+func (s *Storage) publish(req *innerRequest) (interface{}, error) {
+	msg, ok := req.val.(string)
+	if !ok {
+		return nil, &BadRequest{req, "Incoming object is not string"}
+	}
+	return s.pubsub.publish(req.key, msg), nil
 }
 
 func (s *Storage) getKeyMeta(k string) (*keyMeta, bool) {
@@ -142,16 +500,43 @@ func (s *Storage) setKeyMeta(k string, m *keyMeta) {
 	s.metaLock.Unlock()
 }
 
-// clears all data
+// clears all data. Workers of all buckets are held meanwhile, so requests see either all keys or none of them,
+// and keys metas, buckets and ttl monitor are cleared consistently
 func (s *Storage) clear() {
+	buckets := make(map[uint8]struct{}, len(s.buckets))
+	for i := range s.buckets {
+		buckets[uint8(i)] = struct{}{}
+	}
+	release := s.holdBuckets(buckets)
+	defer release()
+
+	s.metaLock.Lock()
+	s.keyMetaMap = make(map[string]*keyMeta)
+	s.metaLock.Unlock()
 	for i, b := range s.buckets {
 		log.Printf("Clearing bucket #%d", i)
 		b.clear()
 	}
+	s.ttlMonitor.clear()
+}
+
+// returns count of stored keys
+func (s *Storage) dbsize(req *innerRequest) (interface{}, error) {
+	s.metaLock.RLock()
+	defer s.metaLock.RUnlock()
+	return len(s.keyMetaMap), nil
 }
 
 func (s *Storage) delete(req *innerRequest) (interface{}, error) {
 	k := req.key
+	if req.meta.t == TYPE_NULL {
+		req.unchanged = true
+		return nil, nil
+	}
+	if req.meta.expireAt > 0 {
+		s.ttlMonitor.unmonitor(req.meta)
+	}
+	s.clearFieldExpirations(req.meta)
 
 	s.metaLock.Lock()
 	delete(s.keyMetaMap, k)
@@ -167,10 +552,16 @@ func (s *Storage) set(req *innerRequest) (interface{}, error) {
 	if !ok {
 		return nil, &BadRequest{req, "Incoming object is not string"}
 	}
+	if ttl < 0 {
+		// negative ttl means that object is expired at once, so only previous one is removed
+		return s.delete(req)
+	}
 	s.ttlMonitor.monitor(req.meta, ttl)
+	s.clearFieldExpirations(req.meta)
 	req.meta.t = TYPE_STRING
 	s.setKeyMeta(k, req.meta)
 	s.buckets[req.bucket].set(k, v)
+	s.setSize(req, v)
 	return nil, nil
 }
 
@@ -187,6 +578,137 @@ func (s *Storage) get(req *innerRequest) (interface{}, error) {
 	return *v, nil
 }
 
+// sets raw bytes object, which is stored and returned as is. Stored bytes are never changed in place
+func (s *Storage) bset(req *innerRequest) (interface{}, error) {
+	v, ok := req.val.([]byte)
+	if !ok {
+		return nil, &BadRequest{req, "Incoming object is not bytes"}
+	}
+	s.ttlMonitor.monitor(req.meta, req.ttl)
+	s.clearFieldExpirations(req.meta)
+	req.meta.t = TYPE_BYTES
+	s.setKeyMeta(req.key, req.meta)
+	s.buckets[req.bucket].set(req.key, v)
+	s.setSize(req, v)
+	return nil, nil
+}
+
+func (s *Storage) bget(req *innerRequest) (interface{}, error) {
+	if req.meta.t == TYPE_NULL {
+		return nil, &ObjectNotFound{req}
+	} else if req.meta.t != TYPE_BYTES {
+		return nil, &BadRequest{req, "Stored object is not bytes"}
+	}
+	v, _ := s.buckets[req.bucket].get(req.key)
+	return *v, nil
+}
+
+func (s *Storage) add(req *innerRequest) (interface{}, error) {
+	if req.meta.t != TYPE_NULL {
+		return nil, &ObjectExists{req}
+	}
+	return s.set(req)
+}
+
+func (s *Storage) replace(req *innerRequest) (interface{}, error) {
+	if req.meta.t == TYPE_NULL {
+		return nil, &ObjectNotFound{req}
+	}
+	return s.set(req)
+}
+
+// increments integer stored in string object by index value, missing object is created with 0.
+// Optional arg is lower bound, which decremented value is clamped to
+func (s *Storage) incrby(req *innerRequest) (interface{}, error) {
+	delta, err := strconv.ParseInt(req.idx, 10, 64)
+	if err != nil {
+		return nil, &BadRequest{req, "Non integer index: "+err.Error()}
+	}
+	min := int64(math.MinInt64)
+	if len(req.args) > 0 {
+		if min, err = strconv.ParseInt(req.args[0], 10, 64); err != nil {
+			return nil, &BadRequest{req, "Non integer lower bound: "+err.Error()}
+		}
+	}
+	return s.incrementInt(req, delta, min)
+}
+
+func (s *Storage) incr(req *innerRequest) (interface{}, error) {
+	return s.incrementInt(req, 1, math.MinInt64)
+}
+
+func (s *Storage) decr(req *innerRequest) (interface{}, error) {
+	return s.incrementInt(req, -1, math.MinInt64)
+}
+
+// increments float stored in string object by index value, missing object is created with 0
+func (s *Storage) incrbyfloat(req *innerRequest) (interface{}, error) {
+	k := req.key
+	m := req.meta
+	if m.t != TYPE_NULL && m.t != TYPE_STRING {
+		return nil, &BadRequest{req, "Stored object is not string"}
+	}
+	delta, err := strconv.ParseFloat(req.idx, 64)
+	if err != nil {
+		return nil, &BadRequest{req, "Non float index: "+err.Error()}
+	}
+	var cur float64
+	if m.t == TYPE_STRING {
+		v, _ := s.buckets[req.bucket].get(k)
+		cur, err = strconv.ParseFloat((*v).(string), 64)
+		if err != nil {
+			return nil, &BadRequest{req, "Stored object is not float"}
+		}
+	}
+	res := cur+delta
+	if math.IsInf(res, 0) || math.IsNaN(res) {
+		return nil, &BadRequest{req, "Increment would produce NaN or Infinity"}
+	}
+	if m.t == TYPE_NULL {
+		m.t = TYPE_STRING
+		s.setKeyMeta(k, m)
+	}
+	v := strconv.FormatFloat(res, 'f', -1, 64)
+	s.buckets[req.bucket].set(k, v)
+	s.setSize(req, v)
+	return v, nil
+}
+
+func (s *Storage) incrementInt(req *innerRequest, delta int64, min int64) (interface{}, error) {
+	k := req.key
+	m := req.meta
+	if m.t != TYPE_NULL && m.t != TYPE_STRING {
+		return nil, &BadRequest{req, "Stored object is not string"}
+	}
+	var cur int64
+	if m.t == TYPE_STRING {
+		v, _ := s.buckets[req.bucket].get(k)
+		var err error
+		cur, err = strconv.ParseInt((*v).(string), 10, 64)
+		if err != nil {
+			return nil, &BadRequest{req, "Stored object is not integer"}
+		}
+	}
+	res := min
+	if delta > 0 && cur > math.MaxInt64-delta {
+		return nil, &BadRequest{req, "Increment would overflow"}
+	} else if delta < 0 && cur < math.MinInt64-delta {
+		if min == math.MinInt64 {
+			return nil, &BadRequest{req, "Increment would overflow"}
+		}
+	} else if cur+delta > min {
+		res = cur+delta
+	}
+	if m.t == TYPE_NULL {
+		m.t = TYPE_STRING
+		s.setKeyMeta(k, m)
+	}
+	v := strconv.FormatInt(res, 10)
+	s.buckets[req.bucket].set(k, v)
+	s.setSize(req, v)
+	return v, nil
+}
+
 func (s *Storage) lset(req *innerRequest) (interface{}, error) {
 	k := req.key
 	ttl := req.ttl
@@ -196,9 +718,11 @@ func (s *Storage) lset(req *innerRequest) (interface{}, error) {
 	}
 
 	s.ttlMonitor.monitor(req.meta, ttl)
+	s.clearFieldExpirations(req.meta)
 	req.meta.t = TYPE_LIST
 	s.setKeyMeta(k, req.meta)
 	s.buckets[req.bucket].set(k, v)
+	s.setSize(req, v)
 	return nil, nil
 }
 
@@ -219,10 +743,17 @@ func (s *Storage) lseti(req *innerRequest) (interface{}, error) {
 	}
 	listPtr, _ := s.buckets[req.bucket].get(k)
 	list, _ := (*listPtr).([]string)
-	if idx >= len(list) {
+	if idx < 0 {
+		// negative index is counted from the end, item expiration is kept by index from the head
+		idx += len(list)
+		req.idx = strconv.Itoa(idx)
+	}
+	if idx < 0 || idx >= len(list) {
 		return nil, &BadRequest{req, "List index out of range"}
 	}
+	s.addSize(req, valueSize(v)-valueSize(list[idx]))
 	list[idx] = v
+	s.setFieldTTL(req)
 	return nil, nil
 }
 func (s *Storage) lget(req *innerRequest) (interface{}, error) {
@@ -254,12 +785,330 @@ func (s *Storage) lgeti(req *innerRequest) (interface{}, error) {
 
 	listPtr, _ := s.buckets[req.bucket].get(k)
 	list, _ := (*listPtr).([]string)
-	if idx >= len(list) {
+	if idx < 0 || idx >= len(list) {
 		return nil, &BadRequest{req, "List index out of range"}
 	}
 	return list[idx], nil
 }
 
+// inserts value before or after the first occurrence of pivot value, returns list length
+func (s *Storage) linsert(req *innerRequest) (interface{}, error) {
+	if req.meta.t == TYPE_NULL {
+		return nil, &ObjectNotFound{req}
+	} else if req.meta.t != TYPE_LIST {
+		return nil, &BadRequest{req, "Stored object is not list"}
+	}
+	var shift int
+	switch req.idx {
+	case `before`:
+		shift = 0
+	case `after`:
+		shift = 1
+	default:
+		return nil, &BadRequest{req, "Index must be 'before' or 'after'"}
+	}
+	if len(req.args) < 1 {
+		return nil, &BadRequest{req, "Pivot value is not set"}
+	}
+	pivot := req.args[0]
+	v, ok := req.val.(string)
+	if !ok {
+		return nil, &BadRequest{req, "Incoming object is not string"}
+	}
+	k := req.key
+	listPtr, _ := s.buckets[req.bucket].get(k)
+	list, _ := (*listPtr).([]string)
+	pos := indexOf(list, pivot)
+	if pos < 0 {
+		return nil, &BadRequest{req, "List does not contain value '"+pivot+"'"}
+	}
+	pos += shift
+	list = append(list, ``)
+	copy(list[pos+1:], list[pos:])
+	list[pos] = v
+	s.buckets[req.bucket].set(k, list)
+	s.addSize(req, valueSize(v))
+	s.remapItemExpirations(req, func(i int) int {
+		if i >= pos {
+			return i+1
+		}
+		return i
+	})
+	return len(list), nil
+}
+
+// removes count occurrences of value, starting from the head for positive count and from the tail
+// for negative one, zero count removes all occurrences. Returns number of removed values
+func (s *Storage) lrem(req *innerRequest) (interface{}, error) {
+	if req.meta.t == TYPE_NULL {
+		return nil, &ObjectNotFound{req}
+	} else if req.meta.t != TYPE_LIST {
+		return nil, &BadRequest{req, "Stored object is not list"}
+	}
+	count, err := strconv.Atoi(req.idx)
+	if err != nil {
+		return nil, &BadRequest{req, "Non integer index: "+err.Error()}
+	}
+	v, ok := req.val.(string)
+	if !ok {
+		return nil, &BadRequest{req, "Incoming object is not string"}
+	}
+	k := req.key
+	listPtr, _ := s.buckets[req.bucket].get(k)
+	list, _ := (*listPtr).([]string)
+	limit := count
+	if limit < 0 { limit = -limit }
+	removed := make(map[int]bool)
+	for i := range list {
+		if limit > 0 && len(removed) >= limit { break }
+		j := i
+		if count < 0 { j = len(list)-1-i }
+		if list[j] == v {
+			removed[j] = true
+		}
+	}
+	if len(removed) == 0 {
+		req.unchanged = true
+		return 0, nil
+	}
+	newList := make([]string, 0, len(list)-len(removed))
+	newIdxs := make([]int, len(list))
+	for i := range list {
+		if removed[i] {
+			newIdxs[i] = -1
+		} else {
+			newIdxs[i] = len(newList)
+			newList = append(newList, list[i])
+		}
+	}
+	s.addSize(req, -int64(len(removed))*valueSize(v))
+	if len(newList) == 0 {
+		s.delete(req)
+	} else {
+		s.buckets[req.bucket].set(k, newList)
+		s.remapItemExpirations(req, func(i int) int {
+			if i >= len(newIdxs) {
+				return -1
+			}
+			return newIdxs[i]
+		})
+	}
+	return len(removed), nil
+}
+
+// returns index of the first occurrence of value
+func (s *Storage) lpos(req *innerRequest) (interface{}, error) {
+	if req.meta.t == TYPE_NULL {
+		return nil, &ObjectNotFound{req}
+	} else if req.meta.t != TYPE_LIST {
+		return nil, &BadRequest{req, "Stored object is not list"}
+	}
+	listPtr, _ := s.buckets[req.bucket].get(req.key)
+	list, _ := (*listPtr).([]string)
+	pos := indexOf(list, req.idx)
+	if pos < 0 {
+		return nil, &BadRequest{req, "List does not contain value '"+req.idx+"'"}
+	}
+	return pos, nil
+}
+
+func indexOf(list []string, v string) int {
+	for i := range list {
+		if list[i] == v {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Storage) lpush(req *innerRequest) (interface{}, error) {
+	return s.push(req, true)
+}
+
+func (s *Storage) rpush(req *innerRequest) (interface{}, error) {
+	return s.push(req, false)
+}
+
+// pushes values to the head or to the tail of list, missing list is created
+func (s *Storage) push(req *innerRequest, head bool) (interface{}, error) {
+	k := req.key
+	m := req.meta
+	if m.t != TYPE_NULL && m.t != TYPE_LIST {
+		return nil, &BadRequest{req, "Stored object is not list"}
+	}
+	v, ok := req.val.([]string)
+	if !ok {
+		return nil, &BadRequest{req, "Incoming object is not list"}
+	}
+	var list []string
+	if m.t == TYPE_LIST {
+		listPtr, _ := s.buckets[req.bucket].get(k)
+		list = (*listPtr).([]string)
+	}
+	if head {
+		// values are pushed one by one, so the last one becomes the head
+		newList := make([]string, len(v), len(v)+len(list))
+		for i := range v {
+			newList[len(v)-1-i] = v[i]
+		}
+		list = append(newList, list...)
+	} else {
+		list = append(list, v...)
+	}
+	if m.t == TYPE_NULL {
+		m.t = TYPE_LIST
+		s.setKeyMeta(k, m)
+	}
+	s.buckets[req.bucket].set(k, list)
+	size := int64(0)
+	for _, item := range v {
+		size += valueSize(item)
+	}
+	s.addSize(req, size)
+	if head {
+		s.remapItemExpirations(req, func(i int) int {
+			return i+len(v)
+		})
+	}
+	return len(list), nil
+}
+
+func (s *Storage) lpop(req *innerRequest) (interface{}, error) {
+	return s.pop(req, true)
+}
+
+func (s *Storage) rpop(req *innerRequest) (interface{}, error) {
+	return s.pop(req, false)
+}
+
+// pops value from the head or from the tail of list, list is deleted when last value is popped
+func (s *Storage) pop(req *innerRequest, head bool) (interface{}, error) {
+	k := req.key
+	m := req.meta
+	if m.t == TYPE_NULL {
+		return nil, &ObjectNotFound{req}
+	} else if m.t != TYPE_LIST {
+		return nil, &BadRequest{req, "Stored object is not list"}
+	}
+	listPtr, _ := s.buckets[req.bucket].get(k)
+	list := (*listPtr).([]string)
+	if len(list) == 0 {
+		return nil, &BadRequest{req, "List is empty"}
+	}
+	var v string
+	if head {
+		v = list[0]
+		list = list[1:]
+	} else {
+		v = list[len(list)-1]
+		list = list[:len(list)-1]
+	}
+	s.addSize(req, -valueSize(v))
+	if len(list) == 0 {
+		s.delete(req)
+	} else {
+		s.buckets[req.bucket].set(k, list)
+		s.remapItemExpirations(req, func(i int) int {
+			if head {
+				i--
+			}
+			if i >= len(list) {
+				return -1
+			}
+			return i
+		})
+	}
+	return v, nil
+}
+
+func (s *Storage) llen(req *innerRequest) (interface{}, error) {
+	m := req.meta
+	if m.t == TYPE_NULL {
+		return nil, &ObjectNotFound{req}
+	} else if m.t != TYPE_LIST {
+		return nil, &BadRequest{req, "Stored object is not list"}
+	}
+	listPtr, _ := s.buckets[req.bucket].get(req.key)
+	return len((*listPtr).([]string)), nil
+}
+
+// returns copy of list values from start to stop index inclusive, negative indexes are counted from the tail
+func (s *Storage) lrange(req *innerRequest) (interface{}, error) {
+	m := req.meta
+	if m.t == TYPE_NULL {
+		return nil, &ObjectNotFound{req}
+	} else if m.t != TYPE_LIST {
+		return nil, &BadRequest{req, "Stored object is not list"}
+	}
+	listPtr, _ := s.buckets[req.bucket].get(req.key)
+	list := (*listPtr).([]string)
+	start, stop, err := listRange(req, len(list))
+	if err != nil { return nil, err }
+	res := make([]string, stop-start)
+	copy(res, list[start:stop])
+	return res, nil
+}
+
+// leaves only list values from start to stop index inclusive, list is deleted if nothing is left
+func (s *Storage) ltrim(req *innerRequest) (interface{}, error) {
+	m := req.meta
+	if m.t == TYPE_NULL {
+		return nil, &ObjectNotFound{req}
+	} else if m.t != TYPE_LIST {
+		return nil, &BadRequest{req, "Stored object is not list"}
+	}
+	listPtr, _ := s.buckets[req.bucket].get(req.key)
+	list := (*listPtr).([]string)
+	start, stop, err := listRange(req, len(list))
+	if err != nil { return nil, err }
+	if start == 0 && stop == len(list) {
+		req.unchanged = true
+		return nil, nil
+	}
+	size := int64(0)
+	for _, items := range [][]string{list[:start], list[stop:]} {
+		for _, item := range items {
+			size += valueSize(item)
+		}
+	}
+	s.addSize(req, -size)
+	if start == stop {
+		s.delete(req)
+	} else {
+		s.buckets[req.bucket].set(req.key, list[start:stop])
+		s.remapItemExpirations(req, func(i int) int {
+			if i < start || i >= stop {
+				return -1
+			}
+			return i-start
+		})
+	}
+	return nil, nil
+}
+
+// converts start and stop indexes of request to slice bounds for list of given length
+func listRange(req *innerRequest, length int) (int, int, error) {
+	if len(req.args) < 1 {
+		return 0, 0, &BadRequest{req, "Stop index is not set"}
+	}
+	start, err := strconv.Atoi(req.idx)
+	if err != nil {
+		return 0, 0, &BadRequest{req, "Non integer index: "+err.Error()}
+	}
+	stop, err := strconv.Atoi(req.args[0])
+	if err != nil {
+		return 0, 0, &BadRequest{req, "Non integer index: "+err.Error()}
+	}
+	if start < 0 { start += length }
+	if stop < 0 { stop += length }
+	if start < 0 { start = 0 }
+	if stop >= length { stop = length-1 }
+	if start > stop {
+		return 0, 0, nil
+	}
+	return start, stop+1, nil
+}
+
 func (s *Storage) dset(req *innerRequest) (interface{}, error) {
 	k := req.key
 	ttl := req.ttl
@@ -269,9 +1118,11 @@ func (s *Storage) dset(req *innerRequest) (interface{}, error) {
 	}
 
 	s.ttlMonitor.monitor(req.meta, ttl)
+	s.clearFieldExpirations(req.meta)
 	req.meta.t = TYPE_DICT
 	s.setKeyMeta(k, req.meta)
 	s.buckets[req.bucket].set(k, v)
+	s.setSize(req, v)
 	return nil, nil
 }
 func (s *Storage) dseti(req *innerRequest) (interface{}, error) {
@@ -280,16 +1131,26 @@ func (s *Storage) dseti(req *innerRequest) (interface{}, error) {
 	if !ok {
 		return nil, &BadRequest{req, "Incoming object is not string"}
 	}
+	if req.meta.t != TYPE_NULL && req.meta.t != TYPE_DICT {
+		return nil, &BadRequest{req, "Stored object is not dict"}
+	}
 	idx := req.idx
 	dictPtr, ok := s.buckets[req.bucket].get(k)
 	if !ok {
 		req.meta.t = TYPE_DICT
 		s.setKeyMeta(k, req.meta)
 		s.buckets[req.bucket].set(k, map[string]string{idx:v})
+		s.addSize(req, fieldSize(idx, v))
 	} else {
 		dict := (*dictPtr).(map[string]string)
+		size := fieldSize(idx, v)
+		if cur, ok := dict[idx]; ok {
+			size -= fieldSize(idx, cur)
+		}
 		dict[idx] = v
+		s.addSize(req, size)
 	}
+	s.setFieldTTL(req)
 	return nil, nil
 }
 func (s *Storage) dget(req *innerRequest) (interface{}, error) {
@@ -301,8 +1162,14 @@ func (s *Storage) dget(req *innerRequest) (interface{}, error) {
 	} else if m.t != TYPE_DICT {
 		return nil, &BadRequest{req, "Stored object is not dict"}
 	}
-	v, _ := s.buckets[req.bucket].get(k)
-	return *v, nil
+	dictPtr, _ := s.buckets[req.bucket].get(k)
+	// stored dict is changed by worker, so its copy is returned
+	dict := (*dictPtr).(map[string]string)
+	res := make(map[string]string, len(dict))
+	for f, v := range dict {
+		res[f] = v
+	}
+	return res, nil
 }
 func (s *Storage) dgeti(req *innerRequest) (interface{}, error) {
 	k := req.key
@@ -343,6 +1210,302 @@ func (s *Storage) dkeys(req *innerRequest) (interface{}, error) {
 	return keys, nil
 }
 
+func (s *Storage) expire(req *innerRequest) (interface{}, error) {
+	if req.meta.t == TYPE_NULL {
+		return nil, &ObjectNotFound{req}
+	}
+	if req.ttl <= 0 {
+		// non-positive ttl means key is already expired
+		s.ttlMonitor.unmonitor(req.meta)
+		return s.delete(req)
+	}
+	s.ttlMonitor.monitor(req.meta, req.ttl)
+	return nil, nil
+}
+
+// updates ttl of existing object, zero ttl removes expiration
+func (s *Storage) touch(req *innerRequest) (interface{}, error) {
+	if req.meta.t == TYPE_NULL {
+		return nil, &ObjectNotFound{req}
+	}
+	if req.ttl < 0 {
+		s.ttlMonitor.unmonitor(req.meta)
+		return s.delete(req)
+	}
+	s.ttlMonitor.monitor(req.meta, req.ttl)
+	return nil, nil
+}
+
+// returns remaining ttl in seconds, -1 if object does not expire and -2 if there is no object.
+// If index is set, ttl of dict field or list item is returned
+func (s *Storage) ttl(req *innerRequest) (interface{}, error) {
+	pttl, err := s.pttl(req)
+	if err != nil { return nil, err }
+	if ttl := pttl.(int); ttl > 0 {
+		return (ttl+500)/1000, nil
+	}
+	return pttl, nil
+}
+
+// returns remaining ttl in milliseconds, -1 if object does not expire and -2 if there is no object.
+// If index is set, ttl of dict field or list item is returned
+func (s *Storage) pttl(req *innerRequest) (interface{}, error) {
+	if req.meta.t == TYPE_NULL {
+		return -2, nil
+	}
+	if req.idx != `` {
+		return s.fieldPTTL(req)
+	}
+	if req.meta.expireAt == 0 {
+		return -1, nil
+	}
+	ttl := req.meta.expireAt-nowMillis()
+	if ttl < 0 {
+		ttl = 0
+	}
+	return int(ttl), nil
+}
+
+// sets unix time from index, when existing object expires. Object is removed if time has already come
+func (s *Storage) expireat(req *innerRequest) (interface{}, error) {
+	if req.meta.t == TYPE_NULL {
+		return nil, &ObjectNotFound{req}
+	}
+	expireAt, err := strconv.ParseInt(req.idx, 10, 64)
+	if err != nil {
+		return nil, &BadRequest{req, "Non integer index: "+err.Error()}
+	}
+	expireAt *= 1000
+	if expireAt <= nowMillis() {
+		return s.delete(req)
+	}
+	s.ttlMonitor.monitorAt(req.meta, expireAt)
+	return nil, nil
+}
+
+// removes expiration of existing object, returns 1 if object had expiration or 0 otherwise
+func (s *Storage) persistKey(req *innerRequest) (interface{}, error) {
+	if req.meta.t == TYPE_NULL {
+		return nil, &ObjectNotFound{req}
+	}
+	if req.meta.expireAt == 0 {
+		req.unchanged = true
+		return 0, nil
+	}
+	s.ttlMonitor.unmonitor(req.meta)
+	return 1, nil
+}
+
+// removes key, if its meta is still the same as expired one and expiration was not changed meanwhile
+func (s *Storage) expired(req *innerRequest) (interface{}, error) {
+	m := req.val.(*keyMeta)
+	if req.meta != m || m.expireAt == 0 || m.expireAt > nowMillis() {
+		return nil, nil
+	}
+	s.events.notify(req.key, EVENT_EXPIRED, ``)
+	return s.delete(req)
+}
+
+
+// removes field passed as index and fields passed as args from dict, dict is deleted when its last field is removed.
+// Returns count of removed fields
+func (s *Storage) ddeli(req *innerRequest) (interface{}, error) {
+	k := req.key
+	m := req.meta
+
+	if m.t == TYPE_NULL {
+		req.unchanged = true
+		return 0, nil
+	} else if m.t != TYPE_DICT {
+		return nil, &BadRequest{req, "Stored object is not dict"}
+	}
+	dictPtr, _ := s.buckets[req.bucket].get(k)
+	dict := (*dictPtr).(map[string]string)
+	cnt := 0
+	for _, f := range append([]string{req.idx}, req.args...) {
+		if _, ok := dict[f]; ok {
+			s.deleteField(req, f)
+			cnt++
+		}
+	}
+	req.unchanged = cnt == 0
+	return cnt, nil
+}
+
+func (s *Storage) dlen(req *innerRequest) (interface{}, error) {
+	k := req.key
+	m := req.meta
+
+	if m.t == TYPE_NULL {
+		return nil, &ObjectNotFound{req}
+	} else if m.t != TYPE_DICT {
+		return nil, &BadRequest{req, "Stored object is not dict"}
+	}
+	dictPtr, _ := s.buckets[req.bucket].get(k)
+	return len((*dictPtr).(map[string]string)), nil
+}
+
+// returns 1 if dict contains field, 0 otherwise
+func (s *Storage) dexists(req *innerRequest) (interface{}, error) {
+	k := req.key
+	m := req.meta
+
+	if m.t == TYPE_NULL {
+		return 0, nil
+	} else if m.t != TYPE_DICT {
+		return nil, &BadRequest{req, "Stored object is not dict"}
+	}
+	dictPtr, _ := s.buckets[req.bucket].get(k)
+	if _, ok := (*dictPtr).(map[string]string)[req.idx]; !ok {
+		return 0, nil
+	}
+	return 1, nil
+}
+
+func (s *Storage) dvals(req *innerRequest) (interface{}, error) {
+	k := req.key
+	m := req.meta
+
+	if m.t == TYPE_NULL {
+		return nil, &ObjectNotFound{req}
+	} else if m.t != TYPE_DICT {
+		return nil, &BadRequest{req, "Stored object is not dict"}
+	}
+	dictPtr, _ := s.buckets[req.bucket].get(k)
+	dict := (*dictPtr).(map[string]string)
+	vals := make([]string, len(dict))
+	i := 0
+	for _, v := range dict {
+		vals[i] = v
+		i++
+	}
+	return vals, nil
+}
+
+// returns dict with requested fields, missing fields are skipped
+func (s *Storage) dmget(req *innerRequest) (interface{}, error) {
+	k := req.key
+	m := req.meta
+
+	fields, ok := req.val.([]string)
+	if !ok {
+		return nil, &BadRequest{req, "Incoming object is not list"}
+	}
+	if m.t == TYPE_NULL {
+		return nil, &ObjectNotFound{req}
+	} else if m.t != TYPE_DICT {
+		return nil, &BadRequest{req, "Stored object is not dict"}
+	}
+	dictPtr, _ := s.buckets[req.bucket].get(k)
+	dict := (*dictPtr).(map[string]string)
+	res := make(map[string]string, len(fields))
+	for _, f := range fields {
+		if v, ok := dict[f]; ok {
+			res[f] = v
+		}
+	}
+	return res, nil
+}
+
+// merges incoming dict into stored one following json merge-patch semantics - nil values remove fields.
+// Missing dict is created, dict without fields is deleted. Returns count of added fields
+func (s *Storage) dmset(req *innerRequest) (interface{}, error) {
+	k := req.key
+	m := req.meta
+
+	patch, ok := req.val.(map[string]*string)
+	if !ok {
+		return nil, &BadRequest{req, "Incoming object is not dict"}
+	}
+	if m.t != TYPE_NULL && m.t != TYPE_DICT {
+		return nil, &BadRequest{req, "Stored object is not dict"}
+	}
+	var dict map[string]string
+	if m.t == TYPE_DICT {
+		dictPtr, _ := s.buckets[req.bucket].get(k)
+		dict = (*dictPtr).(map[string]string)
+	} else {
+		dict = make(map[string]string, len(patch))
+	}
+	size := int64(0)
+	changed := false
+	added := 0
+	for f, v := range patch {
+		cur, ok := dict[f]
+		if ok {
+			size -= fieldSize(f, cur)
+		}
+		if v == nil {
+			delete(dict, f)
+			changed = changed || ok
+		} else {
+			dict[f] = *v
+			size += fieldSize(f, *v)
+			changed = changed || !ok || cur != *v
+			if !ok {
+				added++
+			}
+		}
+		if _, ok := m.fieldExpireAt[f]; ok {
+			s.ttlMonitor.unmonitorField(m, f)
+		}
+	}
+	req.unchanged = !changed
+	if len(dict) == 0 {
+		if m.t == TYPE_DICT {
+			s.delete(req)
+		}
+		return added, nil
+	}
+	if m.t == TYPE_NULL {
+		m.t = TYPE_DICT
+		s.setKeyMeta(k, m)
+		s.buckets[req.bucket].set(k, dict)
+	}
+	s.addSize(req, size)
+	return added, nil
+}
+
+// sets object of any supported type, overwriting existing one
+func (s *Storage) restore(req *innerRequest) (interface{}, error) {
+	k := req.key
+	t := valueType(req.val)
+	if t == TYPE_NULL {
+		return nil, &BadRequest{req, fmt.Sprintf("Incoming object type %T is not supported", req.val)}
+	}
+	s.ttlMonitor.monitor(req.meta, req.ttl)
+	s.clearFieldExpirations(req.meta)
+	for f, expireAt := range req.fieldExpireAt {
+		s.ttlMonitor.monitorFieldAt(req.meta, f, expireAt)
+	}
+	req.meta.t = t
+	s.setKeyMeta(k, req.meta)
+	s.buckets[req.bucket].set(k, req.val)
+	s.setSize(req, req.val)
+	return nil, nil
+}
+
+func valueType(v interface{}) uint8 {
+	switch v.(type) {
+	case string:
+		return TYPE_STRING
+	case []string:
+		return TYPE_LIST
+	case map[string]string:
+		return TYPE_DICT
+	case map[string]struct{}:
+		return TYPE_SET
+	case *zset:
+		return TYPE_ZSET
+	case *stream:
+		return TYPE_STREAM
+	case []byte:
+		return TYPE_BYTES
+	case *jsonDoc:
+		return TYPE_JSON
+	}
+	return TYPE_NULL
+}
 
 func (s *Storage) persist() {
 
@@ -358,6 +1521,14 @@ type keyMeta struct {
 	key string
 	hash     uint32
 	t        uint8
+	version  uint64
+	// unix time in milliseconds, when object expires, zero if it does not. Changed by bucket worker only
+	expireAt int64
+	// unix time in milliseconds, when dict fields or list items (by index) expire. Changed by bucket worker only
+	fieldExpireAt map[string]int64
+	// unix time in milliseconds of the last access and approximate access frequency, used by eviction policies
+	accessedAt int64
+	frequency  uint32
 }
 
 func newKeyMeta(k string) *keyMeta {
@@ -388,4 +1559,28 @@ type ObjectNotFound struct {
 
 func (nf *ObjectNotFound) Error() string {
 	return "Object not found for key '"+nf.req.key+"'"
-}
\ No newline at end of file
+}
+
+type VersionMismatch struct {
+	req *innerRequest
+}
+
+func (vm *VersionMismatch) Error() string {
+	return fmt.Sprintf("Version of object for key '%s' is %d, not %d", vm.req.key, vm.req.version, vm.req.ifVersion)
+}
+
+type ObjectExists struct {
+	req *innerRequest
+}
+
+func (oe *ObjectExists) Error() string {
+	return "Object already exists for key '"+oe.req.key+"'"
+}
+
+type OutOfMemory struct {
+	req *innerRequest
+}
+
+func (oom *OutOfMemory) Error() string {
+	return "Out of memory storing object for key '"+oom.req.key+"'"
+}
@@ -2,8 +2,15 @@ package main
 
 import (
 	"testing"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
 	"time"
 	"sort"
+	"strings"
 )
 
 const (
@@ -22,6 +29,72 @@ var OPERATION_NAMES = map[int]string {
 	OP_DSETI: `dseti`,
 	OP_DGET: `dget`,
 	OP_DKEYS: `dkeys`,
+	OP_EXPIRE: `expire`,
+	OP_ADD: `add`,
+	OP_REPLACE: `replace`,
+	OP_TOUCH: `touch`,
+	OP_INCRBY: `incrby`,
+	OP_INCR: `incr`,
+	OP_DECR: `decr`,
+	OP_INCRBYFLOAT: `incrbyfloat`,
+	OP_LPUSH: `lpush`,
+	OP_RPUSH: `rpush`,
+	OP_LPOP: `lpop`,
+	OP_RPOP: `rpop`,
+	OP_LLEN: `llen`,
+	OP_LRANGE: `lrange`,
+	OP_LTRIM: `ltrim`,
+	OP_LINSERT: `linsert`,
+	OP_LREM: `lrem`,
+	OP_LPOS: `lpos`,
+	OP_DDELI: `ddeli`,
+	OP_DLEN: `dlen`,
+	OP_DEXISTS: `dexists`,
+	OP_DVALS: `dvals`,
+	OP_DMGET: `dmget`,
+	OP_DMSET: `dmset`,
+	OP_SADD: `sadd`,
+	OP_SREM: `srem`,
+	OP_SMEMBERS: `smembers`,
+	OP_SISMEMBER: `sismember`,
+	OP_SCARD: `scard`,
+	OP_SPOP: `spop`,
+	OP_SINTER: `sinter`,
+	OP_SUNION: `sunion`,
+	OP_SDIFF: `sdiff`,
+	OP_ZADD: `zadd`,
+	OP_ZINCRBY: `zincrby`,
+	OP_ZREM: `zrem`,
+	OP_ZSCORE: `zscore`,
+	OP_ZRANK: `zrank`,
+	OP_ZCARD: `zcard`,
+	OP_ZRANGE: `zrange`,
+	OP_ZRANGEBYSCORE: `zrangebyscore`,
+	OP_TTL: `ttl`,
+	OP_EXPIREAT: `expireat`,
+	OP_PERSIST: `persist`,
+	OP_PTTL: `pttl`,
+	OP_PUBLISH: `publish`,
+	OP_BLPOP: `blpop`,
+	OP_BRPOP: `brpop`,
+	OP_XADD: `xadd`,
+	OP_XRANGE: `xrange`,
+	OP_XREVRANGE: `xrevrange`,
+	OP_XLEN: `xlen`,
+	OP_XTRIM: `xtrim`,
+	OP_XGROUPCREATE: `xgroupcreate`,
+	OP_XREADGROUP: `xreadgroup`,
+	OP_XACK: `xack`,
+	OP_XPENDING: `xpending`,
+	OP_BSET: `bset`,
+	OP_BGET: `bget`,
+	OP_JSET: `jset`,
+	OP_JGET: `jget`,
+	OP_JDEL: `jdel`,
+	OP_JARRAPPEND: `jarrappend`,
+	OP_JNUMINCRBY: `jnumincrby`,
+	OP_DBSIZE: `dbsize`,
+	OP_RESTORE: `restore`,
 }
 
 type operation struct {
@@ -29,6 +102,7 @@ type operation struct {
 	key string
 	idx string
 	val interface{}
+	args []string
 	ttl int64
 	expectedValue interface{}
 	expectedErr string
@@ -47,6 +121,91 @@ func TestStorage_Strings(t *testing.T) {
 	s.stop()
 }
 
+func TestStorage_Bytes(t *testing.T) {
+	s := *NewStorage(1)
+	s.run()
+	k := `test key`
+	v := []byte{0, 1, 2, 0xff}
+	s.testOperation(t, operation{op:OP_BSET, key:k, val:v})
+	s.testOperation(t, operation{op:OP_BGET, key:k, expectedValue:v})
+	s.testOperation(t, operation{op:OP_GET, key:k, expectedErr:`BadRequest: Stored object is not string`})
+	s.testOperation(t, operation{op:OP_BSET, key:k, val:`string`, expectedErr:`BadRequest: Incoming object is not bytes`})
+	s.testOperation(t, operation{op:OP_BSET, key:k, val:[]byte{}})
+	s.testOperation(t, operation{op:OP_BGET, key:k, expectedValue:[]byte{}})
+	s.testOperation(t, operation{op:OP_SET, key:k, val:`string`})
+	s.testOperation(t, operation{op:OP_BGET, key:k, expectedErr:`BadRequest: Stored object is not bytes`})
+	s.stop()
+}
+
+func TestStorage_JSON(t *testing.T) {
+	s := *NewStorage(1)
+	s.run()
+	k := `test key`
+	doc := json.RawMessage(`{"user":{"name":"Ann","age":30,"addresses":[{"city":"Oslo"},{"city":"Rome"}]}}`)
+	s.testOperation(t, operation{op:OP_JGET, key:k, expectedErr:`Object not found for key 'test key'`})
+	s.testOperation(t, operation{op:OP_JSET, key:k, idx:`$.user`, val:json.RawMessage(`{}`), expectedErr:`Object not found for key 'test key'`})
+	s.testOperation(t, operation{op:OP_JSET, key:k, val:doc})
+	s.testOperation(t, operation{op:OP_JGET, key:k, idx:`$.user.addresses[0].city`, expectedValue:`"Oslo"`})
+	s.testOperation(t, operation{op:OP_JGET, key:k, idx:`$.user.addresses[-1]`, expectedValue:`{"city":"Rome"}`})
+	s.testOperation(t, operation{op:OP_JGET, key:k, idx:`$['user']['name']`, expectedValue:`"Ann"`})
+	s.testOperation(t, operation{op:OP_JGET, key:k, idx:`$.user.addresses[2]`, expectedErr:`BadRequest: Path does not exist`})
+	s.testOperation(t, operation{op:OP_JGET, key:k, idx:`user`, expectedErr:`BadRequest: Wrong path: Path must start with '$'`})
+	s.testOperation(t, operation{op:OP_JSET, key:k, idx:`$.user.addresses[1].city`, val:json.RawMessage(`"Paris"`)})
+	s.testOperation(t, operation{op:OP_JSET, key:k, idx:`$.user.email`, val:json.RawMessage(`"ann@example.com"`)})
+	s.testOperation(t, operation{op:OP_JSET, key:k, idx:`$.user.phone.home`, val:json.RawMessage(`"1"`), expectedErr:`BadRequest: Path does not exist`})
+	s.testOperation(t, operation{op:OP_JSET, key:k, idx:`$.user.email`, val:json.RawMessage(`{"broken"`), expectedErr:`BadRequest: Wrong json: unexpected EOF`})
+	s.testOperation(t, operation{op:OP_JARRAPPEND, key:k, idx:`$.user.addresses`, val:json.RawMessage(`[{"city":"Kyiv"}]`), expectedValue:3})
+	s.testOperation(t, operation{op:OP_JARRAPPEND, key:k, idx:`$.user.name`, val:json.RawMessage(`[1]`), expectedErr:`BadRequest: Value by path is not array`})
+	s.testOperation(t, operation{op:OP_JNUMINCRBY, key:k, idx:`$.user.age`, args:[]string{`2`}, expectedValue:`32`})
+	s.testOperation(t, operation{op:OP_JNUMINCRBY, key:k, idx:`$.user.age`, args:[]string{`0.5`}, expectedValue:`32.5`})
+	s.testOperation(t, operation{op:OP_JNUMINCRBY, key:k, idx:`$.user.name`, args:[]string{`1`}, expectedErr:`BadRequest: Value by path is not number`})
+	s.testOperation(t, operation{op:OP_JDEL, key:k, idx:`$.user.addresses[0]`, expectedValue:1})
+	s.testOperation(t, operation{op:OP_JDEL, key:k, idx:`$.user.missing`, expectedValue:0})
+	s.testOperation(t, operation{op:OP_JGET, key:k, expectedValue:`{"user":{"addresses":[{"city":"Paris"},{"city":"Kyiv"}],"age":32.5,"email":"ann@example.com","name":"Ann"}}`})
+	s.testOperation(t, operation{op:OP_GET, key:k, expectedErr:`BadRequest: Stored object is not string`})
+	s.testOperation(t, operation{op:OP_JDEL, key:k, expectedValue:1})
+	s.testOperation(t, operation{op:OP_JGET, key:k, expectedErr:`Object not found for key 'test key'`})
+	s.testOperation(t, operation{op:OP_SET, key:k, val:`string`})
+	s.testOperation(t, operation{op:OP_JGET, key:k, expectedErr:`BadRequest: Stored object is not json`})
+	s.stop()
+}
+
+func TestStorage_ConditionalStrings(t *testing.T) {
+	s := *NewStorage(1)
+	s.run()
+	k := `test key`
+	s.testOperation(t, operation{op:OP_REPLACE, key:k, val:`value`, expectedErr:`Object not found for key 'test key'`})
+	s.testOperation(t, operation{op:OP_ADD, key:k, val:`value`})
+	s.testOperation(t, operation{op:OP_ADD, key:k, val:`other value`, expectedErr:`Object already exists for key 'test key'`})
+	s.testOperation(t, operation{op:OP_REPLACE, key:k, val:`10`})
+	s.testOperation(t, operation{op:OP_INCRBY, key:k, idx:`5`, expectedValue:`15`})
+	s.testOperation(t, operation{op:OP_INCRBY, key:k, idx:`-20`, expectedValue:`-5`})
+	s.testOperation(t, operation{op:OP_INCRBY, key:`counter`, idx:`3`, expectedValue:`3`})
+	s.testOperation(t, operation{op:OP_SET, key:k, val:`not a number`})
+	s.testOperation(t, operation{op:OP_INCRBY, key:k, idx:`1`, expectedErr:`BadRequest: Stored object is not integer`})
+	s.testOperation(t, operation{op:OP_TOUCH, key:`missing`, ttl:10, expectedErr:`Object not found for key 'missing'`})
+	s.testOperation(t, operation{op:OP_TOUCH, key:k, ttl:-1})
+	s.testOperation(t, operation{op:OP_GET, key:k, expectedErr:`Object not found for key 'test key'`})
+	s.stop()
+}
+func TestStorage_Counters(t *testing.T) {
+	s := *NewStorage(1)
+	s.run()
+	k := `test key`
+	s.testOperation(t, operation{op:OP_INCR, key:k, expectedValue:`1`})
+	s.testOperation(t, operation{op:OP_INCR, key:k, expectedValue:`2`})
+	s.testOperation(t, operation{op:OP_DECR, key:k, expectedValue:`1`})
+	s.testOperation(t, operation{op:OP_INCRBY, key:k, idx:`10`, expectedValue:`11`})
+	s.testOperation(t, operation{op:OP_INCRBYFLOAT, key:k, idx:`0.5`, expectedValue:`11.5`})
+	s.testOperation(t, operation{op:OP_INCR, key:k, expectedErr:`BadRequest: Stored object is not integer`})
+	s.testOperation(t, operation{op:OP_INCRBYFLOAT, key:k, idx:`-1.5`, expectedValue:`10`})
+	s.testOperation(t, operation{op:OP_INCR, key:k, expectedValue:`11`})
+	s.testOperation(t, operation{op:OP_SET, key:k, val:`9223372036854775807`})
+	s.testOperation(t, operation{op:OP_INCR, key:k, expectedErr:`BadRequest: Increment would overflow`})
+	s.testOperation(t, operation{op:OP_LSET, key:`list`, val:[]string{`1`}})
+	s.testOperation(t, operation{op:OP_INCR, key:`list`, expectedErr:`BadRequest: Stored object is not string`})
+	s.stop()
+}
 
 func TestStorage_TTL(t *testing.T) {
 	s := *NewStorage(1)
@@ -80,6 +239,49 @@ func TestStorage_Lists(t *testing.T) {
 	s.stop()
 }
 
+func TestStorage_ListQueues(t *testing.T) {
+	s := *NewStorage(1)
+	s.run()
+	k := `test key`
+	s.testOperation(t, operation{op:OP_RPUSH, key:k, val:[]string{`b`, `c`}, expectedValue:2})
+	s.testOperation(t, operation{op:OP_LPUSH, key:k, val:[]string{`a`, `z`}, expectedValue:4})
+	s.testOperation(t, operation{op:OP_LGET, key:k, expectedValue:[]string{`z`, `a`, `b`, `c`}})
+	s.testOperation(t, operation{op:OP_LLEN, key:k, expectedValue:4})
+	s.testOperation(t, operation{op:OP_LRANGE, key:k, idx:`1`, args:[]string{`-2`}, expectedValue:[]string{`a`, `b`}})
+	s.testOperation(t, operation{op:OP_LRANGE, key:k, idx:`-100`, args:[]string{`100`}, expectedValue:[]string{`z`, `a`, `b`, `c`}})
+	s.testOperation(t, operation{op:OP_LRANGE, key:k, idx:`3`, args:[]string{`1`}, expectedValue:[]string{}})
+	s.testOperation(t, operation{op:OP_LPOP, key:k, expectedValue:`z`})
+	s.testOperation(t, operation{op:OP_RPOP, key:k, expectedValue:`c`})
+	s.testOperation(t, operation{op:OP_LTRIM, key:k, idx:`1`, args:[]string{`-1`}})
+	s.testOperation(t, operation{op:OP_LGET, key:k, expectedValue:[]string{`b`}})
+	s.testOperation(t, operation{op:OP_RPOP, key:k, expectedValue:`b`})
+	s.testOperation(t, operation{op:OP_LPOP, key:k, expectedErr:`Object not found for key 'test key'`})
+	s.testOperation(t, operation{op:OP_SET, key:k, val:`string`})
+	s.testOperation(t, operation{op:OP_RPUSH, key:k, val:[]string{`a`}, expectedErr:`BadRequest: Stored object is not list`})
+	s.stop()
+}
+
+func TestStorage_ListValues(t *testing.T) {
+	s := *NewStorage(1)
+	s.run()
+	k := `test key`
+	s.testOperation(t, operation{op:OP_LINSERT, key:k, idx:`before`, args:[]string{`a`}, val:`b`, expectedErr:`Object not found for key 'test key'`})
+	s.testOperation(t, operation{op:OP_LSET, key:k, val:[]string{`a`, `b`, `a`, `c`, `a`}})
+	s.testOperation(t, operation{op:OP_LINSERT, key:k, idx:`after`, args:[]string{`c`}, val:`d`, expectedValue:6})
+	s.testOperation(t, operation{op:OP_LINSERT, key:k, idx:`before`, args:[]string{`a`}, val:`z`, expectedValue:7})
+	s.testOperation(t, operation{op:OP_LINSERT, key:k, idx:`before`, args:[]string{`x`}, val:`z`, expectedErr:`BadRequest: List does not contain value 'x'`})
+	s.testOperation(t, operation{op:OP_LGET, key:k, expectedValue:[]string{`z`, `a`, `b`, `a`, `c`, `d`, `a`}})
+	s.testOperation(t, operation{op:OP_LPOS, key:k, idx:`c`, expectedValue:4})
+	s.testOperation(t, operation{op:OP_LREM, key:k, idx:`-2`, val:`a`, expectedValue:2})
+	s.testOperation(t, operation{op:OP_LGET, key:k, expectedValue:[]string{`z`, `a`, `b`, `c`, `d`}})
+	s.testOperation(t, operation{op:OP_LREM, key:k, idx:`0`, val:`x`, expectedValue:0})
+	s.testOperation(t, operation{op:OP_LPOS, key:k, idx:`x`, expectedErr:`BadRequest: List does not contain value 'x'`})
+	s.testOperation(t, operation{op:OP_LSET, key:k, val:[]string{`a`, `a`}})
+	s.testOperation(t, operation{op:OP_LREM, key:k, idx:`0`, val:`a`, expectedValue:2})
+	s.testOperation(t, operation{op:OP_LGET, key:k, expectedErr:`Object not found for key 'test key'`})
+	s.stop()
+}
+
 func TestStorage_Dicts(t *testing.T) {
 	s := *NewStorage(1)
 	s.run()
@@ -106,6 +308,732 @@ func TestStorage_Dicts(t *testing.T) {
 	s.stop()
 }
 
+func TestStorage_DictFields(t *testing.T) {
+	s := *NewStorage(1)
+	s.run()
+	k := `test key`
+	s.testOperation(t, operation{op:OP_DLEN, key:k, expectedErr:`Object not found for key 'test key'`})
+	s.testOperation(t, operation{op:OP_DEXISTS, key:k, idx:`k1`, expectedValue:0})
+	s.testOperation(t, operation{op:OP_DSET, key:k, val:map[string]string{`k1`:`value1`, `k2`:`value2`, `k3`:`value3`}})
+	s.testOperation(t, operation{op:OP_DLEN, key:k, expectedValue:3})
+	s.testOperation(t, operation{op:OP_DEXISTS, key:k, idx:`k1`, expectedValue:1})
+	s.testOperation(t, operation{op:OP_DEXISTS, key:k, idx:`k5`, expectedValue:0})
+	s.testOperation(t, operation{op:OP_DVALS, key:k, expectedValue:[]string{`value3`, `value1`, `value2`}})
+	s.testOperation(t, operation{op:OP_DMGET, key:k, val:[]string{`k1`, `k3`, `k5`}, expectedValue:map[string]string{`k1`:`value1`, `k3`:`value3`}})
+	s.testOperation(t, operation{op:OP_DDELI, key:k, idx:`k1`, expectedValue:1})
+	s.testOperation(t, operation{op:OP_DDELI, key:k, idx:`k1`, expectedValue:0})
+	s.testOperation(t, operation{op:OP_DDELI, key:k, idx:`k2`, args:[]string{`k5`, `k3`}, expectedValue:2})
+	s.testOperation(t, operation{op:OP_DGET, key:k, expectedErr:`Object not found for key 'test key'`})
+	s.stop()
+}
+
+func TestStorage_DictMerge(t *testing.T) {
+	s := *NewStorage(1)
+	s.run()
+	k := `test key`
+	v1, v2 := `value1`, `value2`
+	s.testOperation(t, operation{op:OP_DMSET, key:k, val:map[string]*string{`k1`:&v1, `k2`:nil}, expectedValue:1})
+	s.testOperation(t, operation{op:OP_DGET, key:k, expectedValue:map[string]string{`k1`:`value1`}})
+	s.testOperation(t, operation{op:OP_DMSET, key:k, val:map[string]*string{`k1`:nil, `k2`:&v2, `k3`:&v1}, expectedValue:2})
+	s.testOperation(t, operation{op:OP_DMSET, key:k, val:map[string]*string{`k2`:&v2}, expectedValue:0})
+	s.testOperation(t, operation{op:OP_DGET, key:k, expectedValue:map[string]string{`k2`:`value2`, `k3`:`value1`}})
+	s.testOperation(t, operation{op:OP_DMSET, key:k, val:map[string]*string{`k2`:nil, `k3`:nil}, expectedValue:0})
+	s.testOperation(t, operation{op:OP_DGET, key:k, expectedErr:`Object not found for key 'test key'`})
+	s.testOperation(t, operation{op:OP_SET, key:k, val:`string`})
+	s.testOperation(t, operation{op:OP_DMSET, key:k, val:map[string]*string{`k1`:&v1}, expectedErr:`BadRequest: Stored object is not dict`})
+	s.stop()
+}
+
+func TestStorage_Sets(t *testing.T) {
+	s := *NewStorage(2)
+	s.run()
+	k1 := `test key 1`
+	k2 := `test key 2`
+	s.testOperation(t, operation{op:OP_SADD, key:k1, val:[]string{`a`, `b`, `c`, `a`}, expectedValue:3})
+	s.testOperation(t, operation{op:OP_SADD, key:k1, val:[]string{`c`, `d`}, expectedValue:1})
+	s.testOperation(t, operation{op:OP_SMEMBERS, key:k1, expectedValue:[]string{`a`, `b`, `c`, `d`}})
+	s.testOperation(t, operation{op:OP_SISMEMBER, key:k1, idx:`a`, expectedValue:1})
+	s.testOperation(t, operation{op:OP_SISMEMBER, key:k1, idx:`x`, expectedValue:0})
+	s.testOperation(t, operation{op:OP_SREM, key:k1, val:[]string{`a`, `x`}, expectedValue:1})
+	s.testOperation(t, operation{op:OP_SCARD, key:k1, expectedValue:3})
+	s.testOperation(t, operation{op:OP_SADD, key:k2, val:[]string{`c`, `d`, `e`}, expectedValue:3})
+	s.testOperation(t, operation{op:OP_SINTER, key:k1, val:[]string{k2}, expectedValue:[]string{`c`, `d`}})
+	s.testOperation(t, operation{op:OP_SUNION, key:k1, val:[]string{k2, `missing`}, expectedValue:[]string{`b`, `c`, `d`, `e`}})
+	s.testOperation(t, operation{op:OP_SDIFF, key:k1, val:[]string{k2}, expectedValue:[]string{`b`}})
+	s.testOperation(t, operation{op:OP_SINTER, key:k1, val:[]string{`missing`}, expectedValue:[]string{}})
+	s.testOperation(t, operation{op:OP_SET, key:`string`, val:`value`})
+	s.testOperation(t, operation{op:OP_SUNION, key:k1, val:[]string{`string`}, expectedErr:`BadRequest: Stored object is not set`})
+	s.testOperation(t, operation{op:OP_SREM, key:k2, val:[]string{`c`, `d`}, expectedValue:2})
+	s.testOperation(t, operation{op:OP_SPOP, key:k2, expectedValue:`e`})
+	s.testOperation(t, operation{op:OP_SCARD, key:k2, expectedErr:`Object not found for key 'test key 2'`})
+	s.stop()
+}
+
+func TestStorage_SortedSets(t *testing.T) {
+	s := *NewStorage(1)
+	s.run()
+	k := `test key`
+	s.testOperation(t, operation{op:OP_ZADD, key:k, val:map[string]string{`a`:`3`, `b`:`1`, `c`:`2`, `d`:`2`}, expectedValue:4})
+	s.testOperation(t, operation{op:OP_ZADD, key:k, val:map[string]string{`a`:`0`, `e`:`5`}, expectedValue:1})
+	s.testOperation(t, operation{op:OP_ZADD, key:k, val:map[string]string{`x`:`not a number`}, expectedErr:`BadRequest: Non float score for member 'x'`})
+	s.testOperation(t, operation{op:OP_ZRANGE, key:k, idx:`0`, args:[]string{`-1`}, expectedValue:[]string{`a`, `b`, `c`, `d`, `e`}})
+	s.testOperation(t, operation{op:OP_ZINCRBY, key:k, idx:`1.5`, val:`c`, expectedValue:`3.5`})
+	s.testOperation(t, operation{op:OP_ZINCRBY, key:k, idx:`4`, val:`f`, expectedValue:`4`})
+	s.testOperation(t, operation{op:OP_ZSCORE, key:k, idx:`c`, expectedValue:`3.5`})
+	s.testOperation(t, operation{op:OP_ZRANK, key:k, idx:`c`, expectedValue:3})
+	s.testOperation(t, operation{op:OP_ZRANK, key:k, idx:`x`, expectedErr:`BadRequest: Sorted set does not contain member 'x'`})
+	s.testOperation(t, operation{op:OP_ZRANGE, key:k, idx:`-2`, args:[]string{`-1`}, expectedValue:[]string{`f`, `e`}})
+	s.testOperation(t, operation{op:OP_ZRANGEBYSCORE, key:k, idx:`1`, args:[]string{`4`}, expectedValue:[]string{`b`, `d`, `c`, `f`}})
+	s.testOperation(t, operation{op:OP_ZRANGEBYSCORE, key:k, idx:`(1`, args:[]string{`+inf`, `1`, `2`}, expectedValue:[]string{`c`, `f`}})
+	s.testOperation(t, operation{op:OP_ZRANGEBYSCORE, key:k, idx:`-inf`, args:[]string{`(2`}, expectedValue:[]string{`a`, `b`}})
+	s.testOperation(t, operation{op:OP_ZREM, key:k, val:[]string{`a`, `x`}, expectedValue:1})
+	s.testOperation(t, operation{op:OP_ZCARD, key:k, expectedValue:5})
+	s.testOperation(t, operation{op:OP_ZREM, key:k, val:[]string{`b`, `c`, `d`, `e`, `f`}, expectedValue:5})
+	s.testOperation(t, operation{op:OP_ZCARD, key:k, expectedErr:`Object not found for key 'test key'`})
+	s.stop()
+}
+
+func TestStorage_Streams(t *testing.T) {
+	s := *NewStorage(1)
+	s.run()
+	k := `test key`
+	f := map[string]string{`f`:`v`}
+	s.testOperation(t, operation{op:OP_XADD, key:k, idx:`1-1`, val:f, expectedValue:`1-1`})
+	s.testOperation(t, operation{op:OP_XADD, key:k, idx:`1-2`, val:f, expectedValue:`1-2`})
+	s.testOperation(t, operation{op:OP_XADD, key:k, idx:`2`, val:f, expectedValue:`2-0`})
+	s.testOperation(t, operation{op:OP_XADD, key:k, idx:`2-0`, val:f, expectedErr:`BadRequest: Entry id must be greater than 2-0`})
+	s.testOperation(t, operation{op:OP_XADD, key:k, idx:`3-5`, val:map[string]string{}, expectedErr:`BadRequest: Entry has no fields`})
+	s.testOperation(t, operation{op:OP_XADD, key:k, idx:`3-5`, val:f, expectedValue:`3-5`})
+	s.testOperation(t, operation{op:OP_XLEN, key:k, expectedValue:4})
+	s.testOperation(t, operation{op:OP_XRANGE, key:k, idx:`-`, args:[]string{`+`}, expectedValue:[]string{`1-1`, `1-2`, `2-0`, `3-5`}})
+	s.testOperation(t, operation{op:OP_XRANGE, key:k, idx:`1-2`, args:[]string{`2`}, expectedValue:[]string{`1-2`, `2-0`}})
+	s.testOperation(t, operation{op:OP_XRANGE, key:k, idx:`1`, args:[]string{`+`, `2`}, expectedValue:[]string{`1-1`, `1-2`}})
+	s.testOperation(t, operation{op:OP_XREVRANGE, key:k, idx:`+`, args:[]string{`1-2`}, expectedValue:[]string{`3-5`, `2-0`, `1-2`}})
+	s.testOperation(t, operation{op:OP_XREVRANGE, key:k, idx:`+`, args:[]string{`-`, `1`}, expectedValue:[]string{`3-5`}})
+	s.testOperation(t, operation{op:OP_XGROUPCREATE, key:k, idx:`g`, args:[]string{`1-2`}})
+	s.testOperation(t, operation{op:OP_XGROUPCREATE, key:k, idx:`g`, expectedErr:`BadRequest: Stream already has group 'g'`})
+	s.testOperation(t, operation{op:OP_XREADGROUP, key:k, idx:`g`, args:[]string{`alice`, `1`}, expectedValue:[]string{`2-0`}})
+	s.testOperation(t, operation{op:OP_XREADGROUP, key:k, idx:`g`, args:[]string{`bob`}, expectedValue:[]string{`3-5`}})
+	s.testOperation(t, operation{op:OP_XREADGROUP, key:k, idx:`g`, args:[]string{`bob`}, expectedValue:[]string{}})
+	s.testOperation(t, operation{op:OP_XREADGROUP, key:k, idx:`x`, args:[]string{`bob`}, expectedErr:`BadRequest: Stream does not contain group 'x'`})
+	s.testOperation(t, operation{op:OP_XPENDING, key:k, idx:`g`, expectedValue:[]string{`2-0`, `3-5`}})
+	s.testOperation(t, operation{op:OP_XPENDING, key:k, idx:`g`, args:[]string{`bob`}, expectedValue:[]string{`3-5`}})
+	s.testOperation(t, operation{op:OP_XACK, key:k, idx:`g`, val:[]string{`2-0`, `1-1`}, expectedValue:1})
+	s.testOperation(t, operation{op:OP_XTRIM, key:k, idx:`1`, expectedValue:3})
+	s.testOperation(t, operation{op:OP_XRANGE, key:k, idx:`-`, args:[]string{`+`}, expectedValue:[]string{`3-5`}})
+	s.testOperation(t, operation{op:OP_XREADGROUP, key:k, idx:`g`, args:[]string{`bob`, `10`, `0`}, expectedValue:[]string{`3-5`}})
+	s.testOperation(t, operation{op:OP_XTRIM, key:k, idx:`0`, expectedValue:1})
+	s.testOperation(t, operation{op:OP_XLEN, key:k, expectedValue:0})
+	s.testOperation(t, operation{op:OP_XADD, key:k, idx:`3-5`, val:f, expectedErr:`BadRequest: Entry id must be greater than 3-5`})
+	req := s.newInnerRequest(OP_XADD, k, `*`, f, 0)
+	req.args = []string{`1`}
+	if id, err := s.doRequest(req); err != nil || !strings.HasSuffix(id.(string), `-0`) {
+		t.Errorf("Expected auto generated id, got %v, %v", id, err)
+	}
+	s.testOperation(t, operation{op:OP_XLEN, key:k, expectedValue:1})
+	// auto generated id can not wrap around after the max one
+	s.testOperation(t, operation{op:OP_XADD, key:k, idx:`18446744073709551615-18446744073709551614`, val:f, expectedValue:`18446744073709551615-18446744073709551614`})
+	s.testOperation(t, operation{op:OP_XADD, key:k, idx:`*`, val:f, expectedValue:`18446744073709551615-18446744073709551615`})
+	s.testOperation(t, operation{op:OP_XADD, key:k, idx:`*`, val:f, expectedErr:`BadRequest: Stream has exhausted the last possible id`})
+	s.testOperation(t, operation{op:OP_SET, key:`string`, val:`v`})
+	s.testOperation(t, operation{op:OP_XADD, key:`string`, val:f, expectedErr:`BadRequest: Stored object is not stream`})
+	s.stop()
+
+	// pending entries are delivered again with increased delivery count
+	st := *NewStorage(1)
+	st.run()
+	st.testOperation(t, operation{op:OP_XGROUPCREATE, key:k, idx:`g`})
+	st.doRequest(st.newInnerRequest(OP_XADD, k, ``, f, 0))
+	v, err := st.doRequest(st.newInnerRequest(OP_XREADGROUP, k, `g`, nil, 0))
+	if err == nil {
+		t.Errorf("Expected error for request without consumer")
+	}
+	req = st.newInnerRequest(OP_XREADGROUP, k, `g`, nil, 0)
+	req.args = []string{`alice`, `-1`, `0`}
+	v, err = st.doRequest(req)
+	if err != nil || len(v.([]streamEntry)) != 0 {
+		t.Errorf("Expected nothing pending for consumer, got %v, %v", v, err)
+	}
+	req = st.newInnerRequest(OP_XREADGROUP, k, `g`, nil, 0)
+	req.args = []string{`alice`}
+	st.doRequest(req)
+	req = st.newInnerRequest(OP_XREADGROUP, k, `g`, nil, 0)
+	req.args = []string{`alice`, `-1`, `0`}
+	st.doRequest(req)
+	v, err = st.doRequest(st.newInnerRequest(OP_XPENDING, k, `g`, nil, 0))
+	if err != nil || len(v.([]pendingEntry)) != 1 || v.([]pendingEntry)[0].deliveries != 2 || v.([]pendingEntry)[0].consumer != `alice` {
+		t.Errorf("Expected entry delivered twice to alice, got %v, %v", v, err)
+	}
+	st.stop()
+}
+
+func testStreamIds(v interface{}) []string {
+	ids := make([]string, 0)
+	switch entries := v.(type) {
+	case []streamEntry:
+		for _, e := range entries {
+			ids = append(ids, e.id.String())
+		}
+	case []pendingEntry:
+		for _, p := range entries {
+			ids = append(ids, p.id.String())
+		}
+	}
+	return ids
+}
+
+func TestStorage_Versions(t *testing.T) {
+	s := NewStorage(2)
+	s.run()
+	defer s.stop()
+	k := `test key`
+
+	// zero version means that object must not exist
+	req := s.newInnerRequest(OP_SET, k, ``, `value`, 0)
+	req.checkVersion = true
+	if _, err := s.doRequest(req); err != nil {
+		t.Fatalf("Failed to set value for missing key: %v", err)
+	}
+	version := req.version
+	if version == 0 {
+		t.Fatal("Version was not assigned to new object")
+	}
+
+	req = s.newInnerRequest(OP_GET, k, ``, nil, 0)
+	s.doRequest(req)
+	if req.version != version {
+		t.Errorf("Read operation changed version from %d to %d", version, req.version)
+	}
+
+	req = s.newInnerRequest(OP_SET, k, ``, `other value`, 0)
+	req.checkVersion = true
+	req.ifVersion = version+100
+	if _, err := s.doRequest(req); err == nil {
+		t.Error("Value was set with wrong version")
+	} else if _, ok := err.(*VersionMismatch); !ok {
+		t.Errorf("Expected version mismatch error, got '%v'", err)
+	}
+
+	req = s.newInnerRequest(OP_SET, k, ``, `other value`, 0)
+	req.checkVersion = true
+	req.ifVersion = version
+	if _, err := s.doRequest(req); err != nil {
+		t.Errorf("Failed to set value with right version: %v", err)
+	}
+	if req.version <= version {
+		t.Errorf("Version was not increased: %d, was %d", req.version, version)
+	}
+
+	// operation changing nothing keeps version
+	version = req.version
+	s.testOperation(t, operation{op:OP_LSET, key:`list`, val:[]string{`a`, `b`}})
+	req = s.newInnerRequest(OP_LLEN, `list`, ``, nil, 0)
+	s.doRequest(req)
+	listVersion := req.version
+	req = s.newInnerRequest(OP_LREM, `list`, `0`, `c`, 0)
+	if _, err := s.doRequest(req); err != nil {
+		t.Errorf("Failed to remove missing list item: %v", err)
+	}
+	if req.version != listVersion {
+		t.Errorf("Removing nothing changed version from %d to %d", listVersion, req.version)
+	}
+
+	// ttl changes keep version
+	req = s.newInnerRequest(OP_GET, k, ``, nil, 0)
+	s.doRequest(req)
+	version = req.version
+	expireAt := strconv.FormatInt(time.Now().Unix()+100, 10)
+	for _, req := range []*innerRequest{s.newInnerRequest(OP_EXPIRE, k, ``, nil, 100), s.newInnerRequest(OP_PERSIST, k, ``, nil, 0),
+		s.newInnerRequest(OP_EXPIREAT, k, expireAt, nil, 0), s.newInnerRequest(OP_TOUCH, k, ``, nil, 0)} {
+		if _, err := s.doRequest(req); err != nil {
+			t.Errorf("Failed to change ttl: %v", err)
+		}
+		if req.version != version {
+			t.Errorf("Operation %d changed version from %d to %d", req.op, version, req.version)
+		}
+	}
+
+	// versions do not repeat after object is recreated
+	s.doRequest(s.newInnerRequest(OP_DELETE, k, ``, nil, 0))
+	req = s.newInnerRequest(OP_SET, k, ``, `value`, 0)
+	s.doRequest(req)
+	if req.version <= version {
+		t.Errorf("Version of recreated object %d is not greater than %d", req.version, version)
+	}
+}
+
+func TestStorage_Clear(t *testing.T) {
+	s := NewStorage(2)
+	s.run()
+	defer s.stop()
+	s.testOperation(t, operation{op:OP_SET, key:`string`, val:`value`, ttl:1})
+	s.testOperation(t, operation{op:OP_DSETI, key:`dict`, idx:`field`, val:`value`, ttl:1})
+	s.testOperation(t, operation{op:OP_LSET, key:`list`, val:[]string{`a`}})
+	s.clear()
+	s.testOperation(t, operation{op:OP_DBSIZE, expectedValue:0})
+	s.testOperation(t, operation{op:OP_GET, key:`string`, expectedErr:`Object not found for key 'string'`})
+	s.testOperation(t, operation{op:OP_LGET, key:`list`, expectedErr:`Object not found for key 'list'`})
+	s.ttlMonitor.lock.Lock()
+	if len(s.ttlMonitor.keyExpireAtMap) != 0 {
+		t.Errorf("Ttl monitor was not cleared: %v", s.ttlMonitor.keyExpireAtMap)
+	}
+	s.ttlMonitor.lock.Unlock()
+
+	// storage works as usual after clearing
+	s.testOperation(t, operation{op:OP_SET, key:`string`, val:`new value`, ttl:1})
+	s.testOperation(t, operation{op:OP_DBSIZE, expectedValue:1})
+	time.Sleep(1100*time.Millisecond)
+	s.testOperation(t, operation{op:OP_DBSIZE, expectedValue:0})
+}
+
+func TestStorage_TTLOperations(t *testing.T) {
+	s := NewStorage(2)
+	s.run()
+	defer s.stop()
+	k := `test key`
+
+	s.testOperation(t, operation{op:OP_TTL, key:k, expectedValue:-2})
+	s.testOperation(t, operation{op:OP_SET, key:k, val:`value`})
+	s.testOperation(t, operation{op:OP_TTL, key:k, expectedValue:-1})
+	s.testOperation(t, operation{op:OP_PERSIST, key:k, expectedValue:0})
+	s.testOperation(t, operation{op:OP_EXPIRE, key:k, ttl:100})
+	v, _ := s.doRequest(s.newInnerRequest(OP_TTL, k, ``, nil, 0))
+	if ttl := v.(int); ttl < 99 || ttl > 100 {
+		t.Errorf("Expected ttl about 100, got %d", ttl)
+	}
+	s.testOperation(t, operation{op:OP_PERSIST, key:k, expectedValue:1})
+	s.testOperation(t, operation{op:OP_TTL, key:k, expectedValue:-1})
+	s.testOperation(t, operation{op:OP_PTTL, key:k, expectedValue:-1})
+
+	s.testOperation(t, operation{op:OP_EXPIREAT, key:k, idx:strconv.FormatInt(time.Now().Unix()+1000, 10)})
+	v, _ = s.doRequest(s.newInnerRequest(OP_TTL, k, ``, nil, 0))
+	if ttl := v.(int); ttl < 999 || ttl > 1000 {
+		t.Errorf("Expected ttl about 1000, got %d", ttl)
+	}
+	s.testOperation(t, operation{op:OP_EXPIREAT, key:k, idx:`1`})
+	s.testOperation(t, operation{op:OP_GET, key:k, expectedErr:`Object not found for key 'test key'`})
+	s.testOperation(t, operation{op:OP_PERSIST, key:k, expectedErr:`Object not found for key 'test key'`})
+
+	// expiration of deleted key does not affect the new one
+	s.testOperation(t, operation{op:OP_SET, key:k, val:`value`, ttl:1})
+	s.testOperation(t, operation{op:OP_DELETE, key:k})
+	s.testOperation(t, operation{op:OP_SET, key:k, val:`new value`})
+	time.Sleep(time.Duration(2100)*time.Millisecond)
+	s.testOperation(t, operation{op:OP_GET, key:k, expectedValue:`new value`})
+}
+
+func TestStorage_MillisecondTTL(t *testing.T) {
+	s := NewStorage(2)
+	s.run()
+	defer s.stop()
+	k := `test key`
+
+	req := s.newInnerRequest(OP_SET, k, ``, `value`, 0)
+	req.ttl = 200
+	s.doRequest(req)
+	v, _ := s.doRequest(s.newInnerRequest(OP_PTTL, k, ``, nil, 0))
+	if ttl := v.(int); ttl <= 0 || ttl > 200 {
+		t.Errorf("Expected pttl up to 200, got %d", ttl)
+	}
+	time.Sleep(time.Duration(100)*time.Millisecond)
+	s.testOperation(t, operation{op:OP_GET, key:k, expectedValue:`value`})
+	time.Sleep(time.Duration(200)*time.Millisecond)
+	s.testOperation(t, operation{op:OP_GET, key:k, expectedErr:`Object not found for key 'test key'`})
+}
+
+func TestStorage_FieldTTL(t *testing.T) {
+	s := NewStorage(2)
+	s.run()
+	defer s.stop()
+	d := `test dict`
+	l := `test list`
+
+	// only expired dict field is removed
+	s.testOperation(t, operation{op:OP_DSET, key:d, val:map[string]string{`a`:`1`, `b`:`2`}})
+	s.testOperation(t, operation{op:OP_DSETI, key:d, idx:`c`, val:`3`, ttl:1})
+	s.testOperation(t, operation{op:OP_PTTL, key:d, idx:`a`, expectedValue:-1})
+	s.testOperation(t, operation{op:OP_PTTL, key:d, idx:`x`, expectedValue:-2})
+	v, _ := s.doRequest(s.newInnerRequest(OP_TTL, d, `c`, nil, 0))
+	if ttl := v.(int); ttl != 1 {
+		t.Errorf("Expected field ttl 1, got %d", ttl)
+	}
+	s.testOperation(t, operation{op:OP_TTL, key:d, expectedValue:-1})
+
+	// list item expiration follows item, when list values are shifted
+	s.testOperation(t, operation{op:OP_LSET, key:l, val:[]string{`a`, `b`, `c`}})
+	s.testOperation(t, operation{op:OP_LSETI, key:l, idx:`1`, val:`B`, ttl:1})
+	s.testOperation(t, operation{op:OP_LPUSH, key:l, val:[]string{`x`}, expectedValue:4})
+	s.testOperation(t, operation{op:OP_PTTL, key:l, idx:`1`, expectedValue:-1})
+	v, _ = s.doRequest(s.newInnerRequest(OP_PTTL, l, `2`, nil, 0))
+	if ttl := v.(int); ttl <= 0 || ttl > 1000 {
+		t.Errorf("Expected item pttl up to 1000, got %d", ttl)
+	}
+
+	time.Sleep(time.Duration(1100)*time.Millisecond)
+	s.testOperation(t, operation{op:OP_DGET, key:d, expectedValue:map[string]string{`a`:`1`, `b`:`2`}})
+	s.testOperation(t, operation{op:OP_LGET, key:l, expectedValue:[]string{`x`, `a`, `c`}})
+
+	// setting field without ttl removes its expiration, replacing object removes all of them
+	s.testOperation(t, operation{op:OP_DSETI, key:d, idx:`a`, val:`1`, ttl:1})
+	s.testOperation(t, operation{op:OP_DSETI, key:d, idx:`a`, val:`1`})
+	s.testOperation(t, operation{op:OP_DSETI, key:d, idx:`b`, val:`2`, ttl:1})
+	s.testOperation(t, operation{op:OP_DSET, key:d, val:map[string]string{`b`:`2`}})
+	s.testOperation(t, operation{op:OP_PTTL, key:d, idx:`b`, expectedValue:-1})
+
+	// object is removed with its last field
+	s.testOperation(t, operation{op:OP_DSETI, key:d, idx:`b`, val:`2`, ttl:1})
+	time.Sleep(time.Duration(1100)*time.Millisecond)
+	s.testOperation(t, operation{op:OP_DGET, key:d, expectedErr:`Object not found for key 'test dict'`})
+}
+
+func TestStorage_Eviction(t *testing.T) {
+	v := strings.Repeat(`v`, 100)
+
+	s := NewStorage(1)
+	s.setMemoryLimit(350, EVICTION_NO)
+	s.run()
+	// object is rejected before it is stored, if it does not fit
+	s.testOperation(t, operation{op:OP_SET, key:`big`, val:strings.Repeat(`v`, 300), expectedErr:`Out of memory storing object for key 'big'`})
+	s.testOperation(t, operation{op:OP_SET, key:`k1`, val:v})
+	s.testOperation(t, operation{op:OP_SET, key:`k2`, val:v})
+	s.testOperation(t, operation{op:OP_SET, key:`k3`, val:v, expectedErr:`Out of memory storing object for key 'k3'`})
+	s.testOperation(t, operation{op:OP_GET, key:`k1`, expectedValue:v})
+	s.testOperation(t, operation{op:OP_DELETE, key:`k1`})
+	s.testOperation(t, operation{op:OP_SET, key:`k3`, val:v})
+	s.stop()
+
+	s = NewStorage(1)
+	s.setMemoryLimit(350, EVICTION_ALLKEYS_LRU)
+	s.run()
+	s.testOperation(t, operation{op:OP_SET, key:`k1`, val:v})
+	time.Sleep(time.Duration(5)*time.Millisecond)
+	s.testOperation(t, operation{op:OP_SET, key:`k2`, val:v})
+	time.Sleep(time.Duration(5)*time.Millisecond)
+	s.testOperation(t, operation{op:OP_GET, key:`k1`, expectedValue:v})
+	s.testOperation(t, operation{op:OP_SET, key:`k3`, val:v})
+	s.testOperation(t, operation{op:OP_GET, key:`k2`, expectedErr:`Object not found for key 'k2'`})
+	s.testOperation(t, operation{op:OP_GET, key:`k1`, expectedValue:v})
+	s.stop()
+
+	// only keys with ttl are evicted by volatile policies
+	s = NewStorage(1)
+	s.setMemoryLimit(350, EVICTION_VOLATILE_TTL)
+	s.run()
+	s.testOperation(t, operation{op:OP_SET, key:`k1`, val:v, ttl:100})
+	s.testOperation(t, operation{op:OP_SET, key:`k2`, val:v})
+	s.testOperation(t, operation{op:OP_SET, key:`k3`, val:v})
+	s.testOperation(t, operation{op:OP_GET, key:`k1`, expectedErr:`Object not found for key 'k1'`})
+	s.testOperation(t, operation{op:OP_SET, key:`k4`, val:v, expectedErr:`Out of memory storing object for key 'k4'`})
+	s.stop()
+}
+
+// sizes tracked by operations must match sizes of whole objects
+func TestStorage_Sizes(t *testing.T) {
+	s := NewStorage(1)
+	s.setMemoryLimit(1<<20, EVICTION_NO)
+	s.run()
+	defer s.stop()
+	ops := []operation{
+		{op:OP_SET, key:`str`, val:`99`},
+		{op:OP_INCRBY, key:`str`, idx:`5`},
+		{op:OP_INCRBY, key:`counter`, idx:`100`},
+		{op:OP_RPUSH, key:`list`, val:[]string{`a`, `bb`, `ccc`, `a`}},
+		{op:OP_LPUSH, key:`list`, val:[]string{`dddd`}},
+		{op:OP_LSETI, key:`list`, idx:`0`, val:`e`},
+		{op:OP_LINSERT, key:`list`, idx:`after`, args:[]string{`bb`}, val:`ffffff`},
+		{op:OP_LREM, key:`list`, idx:`0`, val:`a`},
+		{op:OP_LPOP, key:`list`},
+		{op:OP_LTRIM, key:`list`, idx:`1`, args:[]string{`1`}},
+		{op:OP_DSETI, key:`dict`, idx:`f1`, val:`v1`},
+		{op:OP_DSETI, key:`dict`, idx:`f1`, val:`value1`},
+		{op:OP_DMSET, key:`dict`, val:map[string]*string{`f2`:&[]string{`v2`}[0], `f1`:nil}},
+		{op:OP_DDELI, key:`dict`, idx:`f3`},
+		{op:OP_DDELI, key:`dict`, idx:`f2`},
+		{op:OP_SADD, key:`set`, val:[]string{`a`, `b`, `a`}},
+		{op:OP_SREM, key:`set`, val:[]string{`a`, `c`}},
+		{op:OP_SPOP, key:`set`},
+		{op:OP_ZADD, key:`zset`, val:map[string]string{`a`:`1`, `bb`:`2`}},
+		{op:OP_ZINCRBY, key:`zset`, idx:`1`, val:`ccc`},
+		{op:OP_ZREM, key:`zset`, val:[]string{`a`}},
+		{op:OP_XADD, key:`stream`, idx:`1-1`, val:map[string]string{`f`:`v`}},
+		{op:OP_XADD, key:`stream`, idx:`1-2`, val:map[string]string{`f`:`value`}, args:[]string{`1`}},
+		{op:OP_XGROUPCREATE, key:`stream`, idx:`g`, args:[]string{`0`}},
+		{op:OP_XREADGROUP, key:`stream`, idx:`g`, args:[]string{`c`}},
+		{op:OP_XACK, key:`stream`, idx:`g`, val:[]string{`1-2`}},
+		{op:OP_XTRIM, key:`stream`, idx:`0`},
+		{op:OP_JSET, key:`doc`, val:json.RawMessage(`{"a":{"n":1,"l":[]}}`)},
+		{op:OP_JSET, key:`doc`, idx:`$.a.s`, val:json.RawMessage(`"text"`)},
+		{op:OP_JSET, key:`doc`, idx:`$.a.s`, val:json.RawMessage(`[1,2]`)},
+		{op:OP_JARRAPPEND, key:`doc`, idx:`$.a.l`, val:json.RawMessage(`["x",{"y":"z"}]`)},
+		{op:OP_JNUMINCRBY, key:`doc`, idx:`$.a.n`, args:[]string{`1000`}},
+		{op:OP_JDEL, key:`doc`, idx:`$.a.l[1]`},
+		{op:OP_JDEL, key:`doc`, idx:`$.a.s`},
+		{op:OP_BSET, key:`bytes`, val:[]byte(`data`)},
+		{op:OP_SET, key:`list`, val:`overwritten`},
+		{op:OP_DELETE, key:`str`},
+	}
+	b := s.buckets[0]
+	for _, op := range ops {
+		req := s.newInnerRequest(op.op, op.key, op.idx, op.val, op.ttl)
+		req.args = op.args
+		if _, err := s.doRequest(req); err != nil {
+			t.Errorf("[%s] Unexpected error: %v", req, err)
+		}
+		total := int64(0)
+		for k, v := range b.data {
+			if size := objectSize(k, v); b.sizes[k] != size {
+				t.Errorf("[%s] Wrong size of '%s': expected %d, got %d", req, k, size, b.sizes[k])
+			}
+			total += b.sizes[k]
+		}
+		if len(b.sizes) != len(b.data) || b.size != total {
+			t.Errorf("[%s] Wrong bucket size: expected %d, got %d", req, total, b.size)
+		}
+	}
+}
+
+func TestStorage_Events(t *testing.T) {
+	s := NewStorage(2)
+	s.run()
+	defer s.stop()
+	all := s.events.subscribe(``, nil)
+	filtered := s.events.subscribe(`user:*`, map[string]bool{`set`: true, EVENT_EXPIRED: true})
+
+	s.testOperation(t, operation{op:OP_SET, key:`user:1`, val:`v`})
+	s.testOperation(t, operation{op:OP_GET, key:`user:1`, expectedValue:`v`})
+	s.testOperation(t, operation{op:OP_DSETI, key:`other`, idx:`f`, val:`v`, ttl:1})
+	// operations changing nothing are not notified
+	s.testOperation(t, operation{op:OP_DDELI, key:`other`, idx:`missing`, expectedValue:0})
+	s.testOperation(t, operation{op:OP_DELETE, key:`missing`})
+	s.testOperation(t, operation{op:OP_DELETE, key:`user:1`})
+	s.testOperation(t, operation{op:OP_SET, key:`user:2`, val:`v`, ttl:2})
+	s.testOperation(t, operation{op:OP_EXPIRE, key:`user:2`, ttl:2})
+
+	expected := []keyEvent{{Key:`user:1`, Op:`set`}, {Key:`other`, Op:`dseti`}, {Key:`user:1`, Op:`delete`},
+		{Key:`user:2`, Op:`set`}, {Key:`user:2`, Op:`expire`}, {Key:`other`, Op:EVENT_EXPIREDI, Idx:`f`}, {Key:`user:2`, Op:EVENT_EXPIRED}}
+	for _, ee := range expected {
+		select {
+		case e := <-all.events:
+			if e.Key != ee.Key || e.Op != ee.Op || e.Idx != ee.Idx {
+				t.Errorf("Expected event %+v, got %+v", ee, *e)
+			}
+		case <-time.After(time.Duration(3)*time.Second):
+			t.Fatalf("Event %+v was not received", ee)
+		}
+	}
+	for _, ee := range []keyEvent{{Key:`user:1`, Op:`set`}, {Key:`user:2`, Op:`set`}, {Key:`user:2`, Op:EVENT_EXPIRED}} {
+		e := <-filtered.events
+		if e.Key != ee.Key || e.Op != ee.Op {
+			t.Errorf("Expected filtered event %+v, got %+v", ee, *e)
+		}
+	}
+
+	// events are dropped, while subscriber buffer is full
+	s.events.unsubscribe(filtered)
+	for i := 0; i < EVENTS_BUFFER_SIZE+10; i++ {
+		s.testOperation(t, operation{op:OP_SET, key:`k`, val:`v`})
+	}
+	if dropped := all.takeDropped(); dropped != 10 {
+		t.Errorf("Expected 10 dropped events, got %d", dropped)
+	}
+	s.events.close()
+	if _, ok := <-filtered.events; ok {
+		t.Error("Events channel of unsubscribed subscriber is not closed")
+	}
+}
+
+func TestStorage_BlockingPops(t *testing.T) {
+	s := NewStorage(1)
+	s.run()
+	defer s.stop()
+	k := `queue`
+
+	s.testOperation(t, operation{op:OP_RPUSH, key:k, val:[]string{`a`, `b`}, expectedValue:2})
+	s.testOperation(t, operation{op:OP_BLPOP, key:k, expectedValue:`a`})
+	s.testOperation(t, operation{op:OP_BRPOP, key:k, expectedValue:`b`})
+
+	// waiters are served in order of their arrival, bucket worker is not blocked meanwhile
+	results := make(chan string, 2)
+	for _, op := range []int{OP_BLPOP, OP_BRPOP} {
+		req := s.newInnerRequest(op, k, `5`, nil, 0)
+		go func() {
+			v, err := s.doBlockingRequest(context.Background(), req)
+			if err != nil {
+				t.Errorf("Blocking pop failed: %v", err)
+			}
+			results <- fmt.Sprint(v)
+		}()
+		time.Sleep(time.Duration(50)*time.Millisecond)
+	}
+	s.testOperation(t, operation{op:OP_SET, key:`other`, val:`v`})
+	s.testOperation(t, operation{op:OP_RPUSH, key:k, val:[]string{`c`, `d`, `e`}, expectedValue:3})
+	if first, second := <-results, <-results; first != `c` || second != `e` {
+		t.Errorf("Expected 'c' and 'e' to be popped, got '%s' and '%s'", first, second)
+	}
+	s.testOperation(t, operation{op:OP_LGET, key:k, expectedValue:[]string{`d`}})
+	s.testOperation(t, operation{op:OP_LPOP, key:k, expectedValue:`d`})
+
+	// nothing is popped after timeout or cancellation, so pushed values are not lost
+	start := time.Now()
+	_, err := s.doBlockingRequest(context.Background(), s.newInnerRequest(OP_BLPOP, k, `0.1`, nil, 0))
+	if _, ok := err.(*ObjectNotFound); !ok || time.Since(start) < time.Duration(100)*time.Millisecond {
+		t.Errorf("Expected not found error after timeout, got %v in %v", err, time.Since(start))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(time.Duration(50)*time.Millisecond)
+		cancel()
+	}()
+	_, err = s.doBlockingRequest(ctx, s.newInnerRequest(OP_BRPOP, k, ``, nil, 0))
+	if _, ok := err.(*ObjectNotFound); !ok {
+		t.Errorf("Expected not found error after cancellation, got %v", err)
+	}
+	s.testOperation(t, operation{op:OP_RPUSH, key:k, val:[]string{`f`}, expectedValue:1})
+	s.testOperation(t, operation{op:OP_LGET, key:k, expectedValue:[]string{`f`}})
+	if len(s.buckets[0].waiters) != 0 {
+		t.Errorf("Waiters were not removed: %v", s.buckets[0].waiters)
+	}
+	_, err = s.doBlockingRequest(context.Background(), s.newInnerRequest(OP_BLPOP, k, `-1`, nil, 0))
+	if err == nil || err.Error() != `BadRequest: Timeout must be non negative number` {
+		t.Errorf("Expected bad request for negative timeout, got %v", err)
+	}
+}
+
+func TestStorage_MultiKey(t *testing.T) {
+	s := NewStorage(4)
+	s.run()
+	defer s.stop()
+
+	if _, err := s.doRequest(s.newInnerRequest(OP_MSET, ``, ``, map[string]string{`k1`: `v1`, `k2`: `v2`, `k3`: `v3`}, 0)); err != nil {
+		t.Fatalf("Failed to set values: %v", err)
+	}
+	s.doRequest(s.newInnerRequest(OP_LSET, `list`, ``, []string{`a`}, 0))
+	v, err := s.doRequest(s.newInnerRequest(OP_MGET, ``, ``, []string{`k3`, `missing`, `list`, `k1`}, 0))
+	if err != nil {
+		t.Fatalf("Failed to get values: %v", err)
+	}
+	res := v.([]*string)
+	if len(res) != 4 || res[0] == nil || *res[0] != `v3` || res[1] != nil || res[2] != nil || res[3] == nil || *res[3] != `v1` {
+		t.Errorf("Wrong values %v", res)
+	}
+
+	v, err = s.doRequest(s.newInnerRequest(OP_MDEL, ``, ``, []string{`k1`, `missing`, `list`}, 0))
+	if err != nil || v != 2 {
+		t.Errorf("Expected 2 deleted objects, got %v, error %v", v, err)
+	}
+	if _, ok := s.getKeyMeta(`list`); ok {
+		t.Error("List was not deleted")
+	}
+}
+
+func TestStorage_Scan(t *testing.T) {
+	s := NewStorage(4)
+	s.run()
+	defer s.stop()
+
+	expected := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		k := fmt.Sprintf("user:%d", i)
+		s.doRequest(s.newInnerRequest(OP_SET, k, ``, `value`, 0))
+		expected[k] = true
+	}
+	s.doRequest(s.newInnerRequest(OP_LSET, `user:list`, ``, []string{`a`}, 0))
+	s.doRequest(s.newInnerRequest(OP_SET, `other`, ``, `value`, 0))
+	// deleted keys are removed from sorted keys of buckets
+	for i := 0; i < 50; i += 3 {
+		k := fmt.Sprintf("user:%d", i)
+		s.doRequest(s.newInnerRequest(OP_DELETE, k, ``, nil, 0))
+		delete(expected, k)
+	}
+
+	found := make(map[string]bool)
+	cursor := SCAN_CURSOR_START
+	for pages := 0; ; pages++ {
+		if pages > 50 {
+			t.Fatal("Scan did not finish")
+		}
+		v, err := s.doRequest(s.newInnerRequest(OP_SCAN, ``, ``, &scanQuery{cursor: cursor, match: `user:*`, t: TYPE_STRING, count: 7}, 0))
+		if err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		res := v.([]string)
+		if len(res) > 8 {
+			t.Errorf("Got %d keys for count 7", len(res)-1)
+		}
+		for _, k := range res[1:] {
+			if found[k] {
+				t.Errorf("Key '%s' is returned twice", k)
+			}
+			found[k] = true
+		}
+		cursor = res[0]
+		if cursor == SCAN_CURSOR_START {
+			break
+		}
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("Wrong scanned keys: expected %v, got %v", expected, found)
+	}
+
+	if _, err := s.doRequest(s.newInnerRequest(OP_SCAN, ``, ``, &scanQuery{cursor: `wrong`, count: 1}, 0)); err == nil {
+		t.Error("Scan with wrong cursor succeeded")
+	}
+}
+
+func TestStorage_Transactions(t *testing.T) {
+	s := NewStorage(4)
+	s.run()
+	defer s.stop()
+
+	s.doRequest(s.newInnerRequest(OP_SET, `counter`, ``, `1`, 0))
+	version := s.keyMetaMap[`counter`].version
+	tx := &transaction{}
+	tx.reqs = []*innerRequest{
+		s.newInnerRequest(OP_RPUSH, `cart`, ``, []string{`a`, `b`}, 0),
+		s.newInnerRequest(OP_DSETI, `order`, `status`, `new`, 0),
+		s.newInnerRequest(OP_LPOP, `order`, ``, nil, 0),
+		s.newInnerRequest(OP_INCR, `counter`, ``, nil, 0),
+		s.newInnerRequest(OP_LLEN, `cart`, ``, nil, 0),
+	}
+	watchReq := s.newInnerRequest(OP_GET, `counter`, ``, nil, 0)
+	watchReq.ifVersion = version
+	tx.watch = []*innerRequest{watchReq}
+
+	v, err := s.doRequest(s.newInnerRequest(OP_EXEC, ``, ``, tx, 0))
+	if err != nil {
+		t.Fatalf("Transaction failed: %v", err)
+	}
+	res := v.([]interface{})
+	if len(res) != len(tx.reqs) {
+		t.Fatalf("Got %d results for %d operations", len(res), len(tx.reqs))
+	}
+	if res[0] != 2 || res[3] != `2` || res[4] != 2 {
+		t.Errorf("Unexpected transaction results %v", res)
+	}
+	if _, ok := res[2].(*BadRequest); !ok {
+		t.Errorf("Expected error for pop from dict, got %v", res[2])
+	}
+	if v, _ := s.doRequest(s.newInnerRequest(OP_DGETI, `order`, `status`, nil, 0)); v != `new` {
+		t.Errorf("Operation after failed one was not performed, got '%v'", v)
+	}
+
+	// counter was changed by transaction, so watching its old version aborts the next one
+	tx.reqs = []*innerRequest{s.newInnerRequest(OP_INCR, `counter`, ``, nil, 0)}
+	if _, err := s.doRequest(s.newInnerRequest(OP_EXEC, ``, ``, tx, 0)); err == nil {
+		t.Error("Transaction was performed with changed watched key")
+	} else if _, ok := err.(*VersionMismatch); !ok {
+		t.Errorf("Expected version mismatch error, got '%v'", err)
+	}
+	if v, _ := s.doRequest(s.newInnerRequest(OP_GET, `counter`, ``, nil, 0)); v != `2` {
+		t.Errorf("Aborted transaction changed value to '%v'", v)
+	}
+
+	tx = &transaction{reqs: []*innerRequest{s.newInnerRequest(OP_SINTER, `cart`, ``, nil, 0)}}
+	if _, err := s.doRequest(s.newInnerRequest(OP_EXEC, ``, ``, tx, 0)); err == nil {
+		t.Error("Multi key operation was performed in transaction")
+	}
+}
+
 func (r *innerRequest) String() string {
 	opDescr := OPERATION_NAMES[r.op]+"/"+r.key
 	if len(r.idx) > 0 {
@@ -135,6 +1063,7 @@ func (s *Storage) testOperation(t *testing.T, op operation) {
 
 	// create and perform request
 	req := s.newInnerRequest(op.op, op.key, op.idx, valCopy, op.ttl)
+	req.args = op.args
 	s.processInnerRequest(req)
 	select {
 	case responseValue := <- req.outCh:
@@ -146,14 +1075,22 @@ func (s *Storage) testOperation(t *testing.T, op operation) {
 			switch op.op {
 			case OP_GET, OP_DGETI, OP_LGETI:
 				equal = op.expectedValue == responseValue
-			case OP_LGET:
+			case OP_BGET:
+				equal = bytes.Equal(op.expectedValue.([]byte), responseValue.([]byte))
+			case OP_JGET, OP_JNUMINCRBY:
+				equal = op.expectedValue == string(responseValue.(json.RawMessage))
+			case OP_LGET, OP_LRANGE, OP_ZRANGE, OP_ZRANGEBYSCORE:
 				equal = testListEq(op.expectedValue.([]string), responseValue.([]string))
-			case OP_DGET:
+			case OP_DGET, OP_DMGET:
 				equal = testDictEq(op.expectedValue.(map[string]string), responseValue.(map[string]string))
-			case OP_DKEYS:
+			case OP_DKEYS, OP_DVALS:
 				sort.Strings(op.expectedValue.([]string))
 				sort.Strings(responseValue.([]string))
 				equal = testListEq(op.expectedValue.([]string), responseValue.([]string))
+			case OP_SMEMBERS, OP_SINTER, OP_SUNION, OP_SDIFF:
+				equal = testSetEq(op.expectedValue.([]string), responseValue.(map[string]struct{}))
+			case OP_XRANGE, OP_XREVRANGE, OP_XREADGROUP, OP_XPENDING:
+				equal = testListEq(op.expectedValue.([]string), testStreamIds(responseValue))
 			default:
 				equal = op.expectedValue == responseValue
 			}
@@ -221,4 +1158,16 @@ func testDictEq(a, b map[string]string) bool {
 		}
 	}
 	return true
+}
+
+func testSetEq(a []string, b map[string]struct{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, member := range a {
+		if _, ok := b[member]; !ok {
+			return false
+		}
+	}
+	return true
 }
\ No newline at end of file
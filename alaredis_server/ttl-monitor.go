@@ -1,34 +1,48 @@
 package main
 
 import (
+	"container/heap"
 	"time"
-	"sort"
+	"sync"
 )
 
 type ttlMonitor struct {
-	expireAtList    sortedTimeList
-	expireAtKeysMap map[int64][]*keyMeta
-	keyExpireAtMap  map[*keyMeta]int64
+	expireAtList    *timeHeap
+	expireAtKeysMap map[int64][]ttlEntry
+	keyExpireAtMap  map[ttlEntry]int64
 	applicationChan chan *application
 	updateChan      chan struct{}
 	onKeyExpire	func(m *keyMeta)
+	onFieldExpire	func(m *keyMeta, field string)
+	// guards maps and list, which are shared by applications and expiration watchers
+	lock            sync.Mutex
+}
+
+// object or its field (dict field or list item index), watched by monitor
+type ttlEntry struct {
+	m *keyMeta
+	field string
+	isField bool
 }
 
 
 type application struct {
-	m *keyMeta
+	e ttlEntry
 	expireAt int64
+	// if set, all entries are removed instead of applying expiration, and channel is closed after that
+	cleared chan struct{}
 }
 
 
-func newTTLMonitor(inputQueueSize int, onKeyExpire func(m *keyMeta)) *ttlMonitor {
+func newTTLMonitor(inputQueueSize int, onKeyExpire func(m *keyMeta), onFieldExpire func(m *keyMeta, field string)) *ttlMonitor {
 	mon := new(ttlMonitor)
-	mon.expireAtList = make(sortedTimeList, 0, 256)
-	mon.expireAtKeysMap = make(map[int64][]*keyMeta)
-	mon.keyExpireAtMap = make(map[*keyMeta]int64)
+	mon.expireAtList = newTimeHeap()
+	mon.expireAtKeysMap = make(map[int64][]ttlEntry)
+	mon.keyExpireAtMap = make(map[ttlEntry]int64)
 	mon.applicationChan = make(chan *application, inputQueueSize)
 	mon.updateChan = make(chan struct{}, 1)
 	mon.onKeyExpire = onKeyExpire
+	mon.onFieldExpire = onFieldExpire
 	return mon
 }
 
@@ -40,16 +54,32 @@ func (mon *ttlMonitor) run() {
 		for {
 			select {
 			case appl := <-mon.applicationChan:
-				curExpireAt := mon.keyExpireAtMap[appl.m]
+				mon.lock.Lock()
+				if appl.cleared != nil {
+					mon.expireAtList = newTimeHeap()
+					mon.expireAtKeysMap = make(map[int64][]ttlEntry)
+					mon.keyExpireAtMap = make(map[ttlEntry]int64)
+					mon.lock.Unlock()
+					// expiration watcher could wait for removed time
+					mon.notifyUpdate()
+					close(appl.cleared)
+					continue
+				}
+				curExpireAt := mon.keyExpireAtMap[appl.e]
+				updated := false
 				if appl.expireAt > 0 && appl.expireAt != curExpireAt {
 					// set new expire at
-					mon.add(appl.m, appl.expireAt)
-					mon.updateChan <- struct{}{}
+					mon.remove(appl.e, curExpireAt)
+					mon.add(appl.e, appl.expireAt)
+					updated = true
 				} else if curExpireAt > 0 && appl.expireAt == 0 {
-					// delete old expire at, as new one is zero
-					mon.remove(appl.m, curExpireAt)
-					mon.onKeyExpire(appl.m)
-					mon.updateChan <- struct{}{}
+					// delete old expire at, as new one is zero, key itself stays alive
+					mon.remove(appl.e, curExpireAt)
+					updated = true
+				}
+				mon.lock.Unlock()
+				if updated {
+					mon.notifyUpdate()
 				}
 			}
 		}
@@ -58,18 +88,34 @@ func (mon *ttlMonitor) run() {
 	// expiration watcher
 	go func() {
 		for {
-			if mon.expireAtList.len() == 0 {
+			mon.lock.Lock()
+			empty := mon.expireAtList.len() == 0
+			var nearestExpireAt int64
+			if !empty {
+				nearestExpireAt = mon.expireAtList.getFirst()
+			}
+			mon.lock.Unlock()
+			if empty {
 				select {
 				case <- mon.updateChan:
 					// do nothing, just continue loop
 					//log.Print("Updating expiration order")
 				}
 			} else {
-				var nearestExpireAt = mon.expireAtList.getFirst()
 				select {
-				case <-time.After(time.Duration(nearestExpireAt-time.Now().Unix())*1e9):
+				case <-time.After(time.Duration(nearestExpireAt-nowMillis())*time.Millisecond):
 					//log.Print("Removing keys")
-					mon.removeAll(nearestExpireAt)
+					mon.lock.Lock()
+					expired := mon.removeAll(nearestExpireAt)
+					mon.lock.Unlock()
+					// callbacks are called without lock, as they could wait for applications watcher
+					for _, e := range expired {
+						if e.isField {
+							mon.onFieldExpire(e.m, e.field)
+						} else {
+							mon.onKeyExpire(e.m)
+						}
+					}
 				case <-mon.updateChan:
 					// do nothing, just continue loop
 					//log.Print("Updating expiration order")
@@ -79,103 +125,175 @@ func (mon *ttlMonitor) run() {
 	}()
 }
 
-
+// ttl is in milliseconds
 func (mon *ttlMonitor) monitor(m *keyMeta, ttl int64) {
-	var expireAt int64
-	if ttl > 0 {
-		expireAt = time.Now().Unix() + ttl
+	mon.monitorAt(m, expireAtFromTTL(ttl))
+}
+
+// sets unix time in milliseconds, when key expires, zero expireAt removes expiration.
+// Key meta keeps expireAt too, so it could be read by bucket worker
+func (mon *ttlMonitor) monitorAt(m *keyMeta, expireAt int64) {
+	m.expireAt = expireAt
+	mon.applicationChan <- &application{e: ttlEntry{m: m}, expireAt: expireAt}
+}
+
+func (mon *ttlMonitor) unmonitor(m *keyMeta) {
+	mon.monitorAt(m, 0)
+}
+
+// ttl is in milliseconds
+func (mon *ttlMonitor) monitorField(m *keyMeta, field string, ttl int64) {
+	mon.monitorFieldAt(m, field, expireAtFromTTL(ttl))
+}
+
+// sets unix time in milliseconds, when dict field or list item expires, zero expireAt removes expiration.
+// Field expirations are kept by key meta too
+func (mon *ttlMonitor) monitorFieldAt(m *keyMeta, field string, expireAt int64) {
+	if expireAt > 0 {
+		if m.fieldExpireAt == nil {
+			m.fieldExpireAt = make(map[string]int64)
+		}
+		m.fieldExpireAt[field] = expireAt
 	} else {
-		expireAt = 0
+		delete(m.fieldExpireAt, field)
 	}
-	mon.applicationChan <- &application{m, expireAt}
+	mon.applicationChan <- &application{e: ttlEntry{m, field, true}, expireAt: expireAt}
 }
 
-func (mon *ttlMonitor) unmonitor(m *keyMeta) {
-	mon.applicationChan <- &application{m, 0}
+func (mon *ttlMonitor) unmonitorField(m *keyMeta, field string) {
+	mon.monitorFieldAt(m, field, 0)
+}
+
+// removes all entries and waits for it. Clearing is queued after applications sent before it,
+// so keys monitored before clearing are not watched after it
+func (mon *ttlMonitor) clear() {
+	cleared := make(chan struct{})
+	mon.applicationChan <- &application{cleared: cleared}
+	<-cleared
 }
 
-func (mon *ttlMonitor) remove(meta *keyMeta, expireAt int64) {
+// wakes expiration watcher up to reread the nearest expiration time. Update is not sent if one is pending already,
+// so applications watcher does not wait for expiration watcher, which could wait for bucket workers itself
+func (mon *ttlMonitor) notifyUpdate() {
+	select {
+	case mon.updateChan <- struct{}{}:
+	default:
+	}
+}
+
+func expireAtFromTTL(ttl int64) int64 {
+	if ttl > 0 {
+		return nowMillis() + ttl
+	}
+	return 0
+}
+
+func (mon *ttlMonitor) remove(e ttlEntry, expireAt int64) {
 	if expireAt == 0 { return }
-	delete(mon.keyExpireAtMap, meta)
+	delete(mon.keyExpireAtMap, e)
 	keys, ok := mon.expireAtKeysMap[expireAt]
-	if ok {
-		i := -1
-		var m *keyMeta
-		for i, m = range keys {
-			if m == meta { break }
-		}
-		if i > -1 {
+	if !ok { return }
+	for i, e1 := range keys {
+		if e1 == e {
 			keys[i] = keys[len(keys)-1]
 			keys = keys[:len(keys)-1]
-		}
-		if len(keys) == 0 {
-			delete(mon.expireAtKeysMap, expireAt)
+			break
 		}
 	}
-	mon.expireAtList.remove(expireAt)
+	if len(keys) == 0 {
+		delete(mon.expireAtKeysMap, expireAt)
+		mon.expireAtList.remove(expireAt)
+	} else {
+		mon.expireAtKeysMap[expireAt] = keys
+	}
 }
 
-func (mon *ttlMonitor) removeAll(expireAt int64) {
+// removes keys expiring at passed time and returns them
+func (mon *ttlMonitor) removeAll(expireAt int64) []ttlEntry {
 	keys, ok := mon.expireAtKeysMap[expireAt]
 	if ok {
-		for _, m := range keys {
-			delete(mon.keyExpireAtMap, m)
-			mon.onKeyExpire(m)
+		for _, e := range keys {
+			delete(mon.keyExpireAtMap, e)
 		}
 		delete(mon.expireAtKeysMap, expireAt)
 	}
 	mon.expireAtList.remove(expireAt)
+	return keys
 }
 
-func (mon *ttlMonitor) add(meta *keyMeta, expireAt int64) {
-	mon.keyExpireAtMap[meta] = expireAt
+func (mon *ttlMonitor) add(e ttlEntry, expireAt int64) {
+	mon.keyExpireAtMap[e] = expireAt
 	keys, ok := mon.expireAtKeysMap[expireAt]
 	if !ok {
-		keys = make([]*keyMeta, 0, 8)
+		keys = make([]ttlEntry, 0, 8)
 		mon.expireAtList.add(expireAt)
 	}
-	mon.expireAtKeysMap[expireAt] = append(keys, meta)
+	mon.expireAtKeysMap[expireAt] = append(keys, e)
 }
 
 
 
-type sortedTimeList []int64
+func nowMillis() int64 {
+	return time.Now().UnixNano()/int64(time.Millisecond)
+}
 
-func (l *sortedTimeList) add(t int64) {
-	*l = append(*l, t)
-	sort.Sort(l)
+// min-heap of expiration times. Position of each time is kept, so any time is added or removed in logarithmic time
+type timeHeap struct {
+	times []int64
+	pos   map[int64]int
 }
 
-func (l *sortedTimeList) remove(t int64) {
-	i := -1
-	var t1 int64
-	for i, t1 = range *l {
-		if t1 == t { break }
-	}
-	if i > -1 {
-		*l = append((*l)[:i], (*l)[i+1:]...)
+func newTimeHeap() *timeHeap {
+	h := new(timeHeap)
+	h.times = make([]int64, 0, 256)
+	h.pos = make(map[int64]int)
+	return h
+}
+
+func (h *timeHeap) add(t int64) {
+	if _, ok := h.pos[t]; ok { return }
+	heap.Push(h, t)
+}
+
+func (h *timeHeap) remove(t int64) {
+	// time could be already removed by clearing
+	if i, ok := h.pos[t]; ok {
+		heap.Remove(h, i)
 	}
 }
 
-func (l *sortedTimeList) len() int {
-	return len(*l)
+func (h *timeHeap) len() int {
+	return len(h.times)
 }
 
-func (l *sortedTimeList) getFirst() int64 {
-	return (*l)[0]
+func (h *timeHeap) getFirst() int64 {
+	return h.times[0]
 }
 
-// Len is the number of elements in the collection.
-func (l sortedTimeList) Len() int {
-	return len(l)
+// Len, Less, Swap, Push and Pop implement heap.Interface, they are called by heap functions only
+func (h *timeHeap) Len() int {
+	return len(h.times)
 }
-// Less reports whether the element with
-// index i should sort before the element with index j.
-func (l sortedTimeList) Less(i, j int) bool {
-	return l[i] < l[j]
+
+func (h *timeHeap) Less(i, j int) bool {
+	return h.times[i] < h.times[j]
 }
-// Swap swaps the elements with indexes i and j.
-func (l sortedTimeList) Swap(i, j int) {
-	l[i], l[j] = l[j], l[i]
+
+func (h *timeHeap) Swap(i, j int) {
+	h.times[i], h.times[j] = h.times[j], h.times[i]
+	h.pos[h.times[i]] = i
+	h.pos[h.times[j]] = j
 }
 
+func (h *timeHeap) Push(x interface{}) {
+	t := x.(int64)
+	h.pos[t] = len(h.times)
+	h.times = append(h.times, t)
+}
+
+func (h *timeHeap) Pop() interface{} {
+	t := h.times[len(h.times)-1]
+	h.times = h.times[:len(h.times)-1]
+	delete(h.pos, t)
+	return t
+}
@@ -9,88 +9,114 @@ import (
 func TestTTLMonitor_Simple(t *testing.T) {
 	mon := newTTLMonitor(10, func(m *keyMeta) {
 		log.Printf("Key '%s' expired!\n", m.key)
+	}, func(m *keyMeta, field string) {
+		log.Printf("Field '%s' of key '%s' expired!\n", field, m.key)
 	})
 
 	k1 := newKeyMeta(`test-key1`)
 	k2 := newKeyMeta(`test-key2`)
-	bigExpireAt := time.Now().Unix()+20
-	smallExpireAt := time.Now().Unix()+10
+	bigExpireAt := nowMillis()+20000
+	smallExpireAt := nowMillis()+10000
 
 	// add one key
-	mon.add(k1, bigExpireAt)
+	mon.add(ttlEntry{m: k1}, bigExpireAt)
 	if len(mon.expireAtKeysMap) != 1 {
 		t.Errorf("expireAtKeysMap was not updated: %v", mon.expireAtKeysMap)
 		t.Fail()
 	}
-	if len(mon.expireAtList) != 1 {
-		t.Errorf("expireAtKeysMap was not updated: %v", mon.expireAtList)
+	if mon.expireAtList.len() != 1 {
+		t.Errorf("expireAtKeysMap was not updated: %v", mon.expireAtList.times)
 		t.Fail()
 	}
 	if len(mon.keyExpireAtMap) != 1 {
 		t.Errorf("keyExpireAtMap was not updated: %v", mon.keyExpireAtMap)
 		t.Fail()
 	}
-	if mon.expireAtList[0] != bigExpireAt {
-		t.Errorf("expireAtList is not updated: %v", mon.expireAtList)
+	if mon.expireAtList.getFirst() != bigExpireAt {
+		t.Errorf("expireAtList is not updated: %v", mon.expireAtList.times)
 		t.Fail()
 	}
 
 	// add another key
-	mon.add(k2, smallExpireAt)
+	mon.add(ttlEntry{m: k2}, smallExpireAt)
 	if len(mon.expireAtKeysMap) != 2 {
 		t.Errorf("expireAtKeysMap was not updated: %v", mon.expireAtKeysMap)
 		t.Fail()
 	}
-	if len(mon.expireAtList) != 2 {
-		t.Errorf("expireAtKeysMap was not updated: %v", mon.expireAtList)
+	if mon.expireAtList.len() != 2 {
+		t.Errorf("expireAtKeysMap was not updated: %v", mon.expireAtList.times)
 		t.Fail()
 	}
 	if len(mon.keyExpireAtMap) != 2 {
 		t.Errorf("keyExpireAtMap was not updated: %v", mon.keyExpireAtMap)
 		t.Fail()
 	}
-	if mon.expireAtList[0] != smallExpireAt && mon.expireAtList[1] != bigExpireAt {
-		t.Errorf("expireAtList is not sorted: %v", mon.expireAtList)
+	if mon.expireAtList.getFirst() != smallExpireAt && mon.expireAtList.times[1] != bigExpireAt {
+		t.Errorf("expireAtList is not sorted: %v", mon.expireAtList.times)
 		t.Fail()
 	}
 
 	// remove second key
-	mon.remove(k2, smallExpireAt)
+	mon.remove(ttlEntry{m: k2}, smallExpireAt)
 	if len(mon.expireAtKeysMap) != 1 {
 		t.Errorf("expireAtKeysMap was not updated: %v", mon.expireAtKeysMap)
 		t.Fail()
 	}
-	if len(mon.expireAtList) != 1 {
-		t.Errorf("expireAtKeysMap was not updated: %v", mon.expireAtList)
+	if mon.expireAtList.len() != 1 {
+		t.Errorf("expireAtKeysMap was not updated: %v", mon.expireAtList.times)
 		t.Fail()
 	}
 	if len(mon.keyExpireAtMap) != 1 {
 		t.Errorf("keyExpireAtMap was not updated: %v", mon.keyExpireAtMap)
 		t.Fail()
 	}
-	if mon.expireAtList[0] != bigExpireAt {
-		t.Errorf("expireAtList is not sorted: %v", mon.expireAtList)
+	if mon.expireAtList.getFirst() != bigExpireAt {
+		t.Errorf("expireAtList is not sorted: %v", mon.expireAtList.times)
 		t.Fail()
 	}
 }
+func TestTTLMonitor_TimeHeap(t *testing.T) {
+	h := newTimeHeap()
+	for _, tm := range []int64{50, 10, 40, 30, 20, 60, 10} {
+		h.add(tm)
+	}
+	h.remove(40)
+	h.remove(100)
+	expected := []int64{10, 20, 30, 50, 60}
+	for _, tm := range expected {
+		if h.len() == 0 || h.getFirst() != tm {
+			t.Fatalf("Expected first time %d, got %v", tm, h.times)
+		}
+		h.remove(tm)
+	}
+	if h.len() != 0 || len(h.pos) != 0 {
+		t.Errorf("Heap is not empty: %v, %v", h.times, h.pos)
+	}
+}
+
 func TestTTLMonitor_Expiration(t *testing.T) {
 	mon := newTTLMonitor(10, func(m *keyMeta) {
 		log.Printf("Key '%s' expired!\n", m.key)
+	}, func(m *keyMeta, field string) {
+		log.Printf("Field '%s' of key '%s' expired!\n", field, m.key)
 	})
 	mon.run()
 
 	k1 := newKeyMeta(`test-key1`)
 	k2 := newKeyMeta(`test-key2`)
-	mon.monitor(k1, 4)
-	mon.monitor(k2, 2)
+	mon.monitor(k1, 4000)
+	mon.monitor(k2, 2000)
+	mon.monitorField(k1, `field`, 1000)
 	log.Print("Waiting for keys expiration")
 	time.Sleep(time.Duration(5*1e9))
+	mon.lock.Lock()
+	defer mon.lock.Unlock()
 
 	if len(mon.expireAtKeysMap) != 0 {
 		t.Error("expireAtKeysMap was not cleared")
 		t.Fail()
 	}
-	if len(mon.expireAtList) != 0 {
+	if mon.expireAtList.len() != 0 {
 		t.Error("expireAtKeysMap was not cleared")
 		t.Fail()
 	}
@@ -99,3 +125,33 @@ func TestTTLMonitor_Expiration(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestTTLMonitor_Clear(t *testing.T) {
+	expired := make(chan string, 10)
+	mon := newTTLMonitor(10, func(m *keyMeta) {
+		expired <- m.key
+	}, func(m *keyMeta, field string) {
+		expired <- m.key+`/`+field
+	})
+	mon.run()
+
+	mon.monitor(newKeyMeta(`test-key1`), 500)
+	mon.monitorField(newKeyMeta(`test-key2`), `field`, 500)
+	mon.clear()
+	mon.lock.Lock()
+	if len(mon.expireAtKeysMap) != 0 || mon.expireAtList.len() != 0 || len(mon.keyExpireAtMap) != 0 {
+		t.Errorf("Monitor was not cleared: %v, %v, %v", mon.expireAtKeysMap, mon.expireAtList.times, mon.keyExpireAtMap)
+	}
+	mon.lock.Unlock()
+
+	// key monitored after clearing expires as usual
+	mon.monitor(newKeyMeta(`test-key3`), 1000)
+	select {
+	case k := <-expired:
+		if k != `test-key3` {
+			t.Errorf("Key '%s' expired after clearing", k)
+		}
+	case <-time.After(2*time.Second):
+		t.Error("Key monitored after clearing did not expire")
+	}
+}
@@ -4,8 +4,12 @@ import (
 	"io"
 	"encoding/binary"
 	"bytes"
+	"bufio"
+	"errors"
 )
 
+var errLineTooLong = errors.New("Line is too long")
+
 func writeSizedData(w io.Writer, buf []byte) (int, error) {
 	cnt := 0
 	binary.Write(w, binary.LittleEndian, int32(len(buf)))
@@ -30,4 +34,20 @@ func readSizedData(r io.Reader, buf *bytes.Buffer) (int, error) {
 	if err != nil { return cnt, err }
 	cnt += n
 	return cnt, nil
-}
\ No newline at end of file
+}
+
+// reads line up to '\n' inclusively, line longer than max length is not read to the end and errLineTooLong is returned,
+// so peer can not make reader buffer line without limit
+func readLimitedLine(r *bufio.Reader, maxLen int) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(line)+len(chunk) > maxLen {
+			return ``, errLineTooLong
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull { continue }
+		if err != nil { return ``, err }
+		return string(line), nil
+	}
+}